@@ -0,0 +1,106 @@
+package main
+
+// Range : A range of keys [Start, Limit) (a nil Start or Limit is unbounded)
+type Range struct {
+	Start []byte
+	Limit []byte
+}
+
+// GetApproximateSizes : Returns the approximate number of bytes used by each range's keys, without reading any data
+// blocks. A table's share of a range is the distance between the offsets of the blocks that contain the range's
+// boundaries (taken from the table's index), so it's only accurate to within a block. The memtables (including
+// those waiting to be flushed) are in memory, so their keys within the range are summed up exactly
+// Overwritten and deleted keys are counted until they're compacted away (same as cleveldb.estimate-num-keys)
+func (db *ClevelDB) GetApproximateSizes(ranges []Range) []uint64 {
+	// A compaction could remove the tables while their indexes are searched, so they're referenced until we're done
+	memtables, tables := db.current()
+	defer unrefTables(tables)
+
+	sizes := make([]uint64, len(ranges))
+	for i, r := range ranges {
+		if r.Start != nil && r.Limit != nil && db.comparator.Compare(r.Start, r.Limit) >= 0 {
+			continue
+		}
+
+		for _, mem := range memtables {
+			sizes[i] += mem.approximateSize(r)
+		}
+		for _, table := range tables {
+			sizes[i] += table.approximateSize(r)
+		}
+	}
+
+	return sizes
+}
+
+// approximateSize : Returns the size of the keys (and their values and merge operands) within the range
+func (mem *Memtable) approximateSize(r Range) uint64 {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	current := mem.header.ptrs[0]
+	if r.Start != nil {
+		current, _ = mem.Get(r.Start)
+	}
+
+	var size uint64
+	for ; current != nil && (r.Limit == nil || mem.cmp.Compare(current.key, r.Limit) < 0); current = current.ptrs[0] {
+		size += uint64(len(current.key) + len(current.val))
+		for _, operand := range current.operands {
+			size += uint64(len(operand))
+		}
+	}
+	return size
+}
+
+// approximateSize : Returns the number of bytes of the table's file between the blocks that contain the range's
+// boundaries (a range that covers every key of the table is the size of the whole file)
+func (ss *SSTable) approximateSize(r Range) uint64 {
+	if !ss.overlaps(r.Start, r.Limit) {
+		return 0
+	}
+
+	// If the table's index can't be loaded, the range is assumed to cover the whole table
+	reader, err := ss.acquire()
+	if err != nil {
+		return uint64(ss.size)
+	}
+	defer reader.release()
+
+	start, err := ss.approximateOffsetOf(reader, r.Start)
+	if err != nil {
+		return uint64(ss.size)
+	}
+	limit := ss.size
+	if r.Limit != nil {
+		limit, err = ss.approximateOffsetOf(reader, r.Limit)
+		if err != nil {
+			return uint64(ss.size)
+		}
+	}
+
+	if limit < start {
+		return 0
+	}
+	return uint64(limit - start)
+}
+
+// approximateOffsetOf : Returns the offset of the block that contains the key (i.e. where the key would be in the
+// table's file). Keys before the table's first key are at the start of the file, and keys after its last key are at
+// the end of the file (a nil key is treated as the start of the file)
+func (ss *SSTable) approximateOffsetOf(reader *tableReader, key []byte) (int64, error) {
+	if key == nil || ss.cmp.Compare(key, ss.smallest) <= 0 {
+		return 0, nil
+	}
+	if ss.cmp.Compare(key, ss.largest) > 0 {
+		return ss.size, nil
+	}
+
+	// Only the index is searched (a partitioned index's partitions aren't cached, so that estimating sizes doesn't
+	// evict the blocks that are read frequently)
+	_, blocks, blockIdx, err := ss.findBlock(reader, key, &ReadOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return blocks[blockIdx].offset, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ClevelDBEstimatesSizesOfMemtableRanges(t *testing.T) {
+	db := newTestClevelDB(t)
+	for i := 0; i < 100; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+
+	// Each key and value is 11 bytes
+	ranges := []Range{
+		{Start: []byte("key000"), Limit: []byte("key050")},
+		{Start: []byte("key090"), Limit: nil},
+		{Start: nil, Limit: nil},
+		{Start: []byte("key050"), Limit: []byte("key000")},
+		{Start: []byte("zzz"), Limit: nil},
+	}
+	expected := []uint64{550, 110, 1100, 0, 0}
+
+	sizes := db.GetApproximateSizes(ranges)
+	for i := range ranges {
+		if sizes[i] != expected[i] {
+			t.Errorf("GetApproximateSizes returns unexpected size for range [%s, %s): %d (expected %d)",
+				ranges[i].Start, ranges[i].Limit, sizes[i], expected[i])
+		}
+	}
+}
+
+func Test_ClevelDBEstimatesSizesOfTableRangesFromIndex(t *testing.T) {
+	for _, partitioned := range []bool{false, true} {
+		db := loadTestDBFromDir(t, NewMemFS(), "db", &Options{PartitionedIndex: partitioned})
+		for i := 0; i < 100; i++ {
+			_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+		}
+		flushTestMemtable(t, db)
+		fileSize := uint64(db.tables[0].size)
+
+		sizes := db.GetApproximateSizes([]Range{
+			{Start: nil, Limit: nil},
+			{Start: []byte("a"), Limit: []byte("z")},
+			{Start: []byte("key000"), Limit: []byte("key050")},
+			{Start: []byte("key050"), Limit: []byte("key100")},
+			{Start: []byte("key020"), Limit: []byte("key030")},
+			{Start: []byte("key100"), Limit: []byte("key200")},
+			{Start: []byte("a"), Limit: []byte("b")},
+		})
+
+		// Ranges that cover every key are the size of the whole file
+		if sizes[0] != fileSize || sizes[1] != fileSize {
+			t.Errorf("GetApproximateSizes returns unexpected sizes for the whole table (partitioned: %v): %v (file size %d)",
+				partitioned, sizes[:2], fileSize)
+		}
+
+		// Both halves of the table hold roughly the same amount of data (give or take a block)
+		if sizes[2] == 0 || sizes[3] == 0 || sizes[2]+sizes[3] != fileSize {
+			t.Errorf("GetApproximateSizes returns unexpected sizes for each half of the table (partitioned: %v): %v",
+				partitioned, sizes[2:4])
+		}
+		if sizes[4] == 0 || sizes[4] >= sizes[2] {
+			t.Errorf("GetApproximateSizes returns unexpected size for a tenth of the table (partitioned: %v): %d",
+				partitioned, sizes[4])
+		}
+		if sizes[5] != 0 || sizes[6] != 0 {
+			t.Errorf("GetApproximateSizes returns unexpected sizes for ranges outside of the table (partitioned: %v): %v",
+				partitioned, sizes[5:])
+		}
+
+		// Estimating sizes doesn't read (or cache) any data blocks
+		if stats := db.BlockCacheStats(); stats.Size != 0 {
+			t.Errorf("GetApproximateSizes caches unexpected blocks (partitioned: %v): %+v", partitioned, stats)
+		}
+	}
+}
+
+func Test_ClevelDBEstimatesSizesAcrossMemtableAndTables(t *testing.T) {
+	db := newTestClevelDB(t)
+	for i := 0; i < 50; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+	flushTestMemtable(t, db)
+	for i := 50; i < 100; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+
+	sizes := db.GetApproximateSizes([]Range{
+		{Start: []byte("key000"), Limit: []byte("key050")},
+		{Start: []byte("key050"), Limit: []byte("key100")},
+	})
+	if sizes[0] != uint64(db.tables[0].size) || sizes[1] != 550 {
+		t.Errorf("GetApproximateSizes returns unexpected sizes: %v", sizes)
+	}
+}
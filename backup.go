@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backup directory layout:
+// - shared/: every backed up SSTable, which is shared by every backup that includes it
+// - private/<id>/: each backup's journal and MANIFEST (which are rewritten by every checkpoint)
+// - meta/<id>: each backup's metadata
+const (
+	sharedBackupDir     = "shared"
+	privateBackupDir    = "private"
+	metaBackupDir       = "meta"
+	sharedTableFilename = "segment_%d_%d_%08x.ss" // file number, size and checksum
+)
+
+var backupNotFoundErr = errors.New("backup not found")
+var backupCorruptErr = errors.New("backup is corrupt")
+var restoreDirNotEmptyErr = errors.New("restore directory is not empty")
+var backupFSMismatchErr = errors.New("db and backup directory are on different file systems")
+var negativeNumToKeepErr = errors.New("number of backups to keep is negative")
+
+// BackupEngine : Manages incremental backups of a db in a local directory
+// Each backup is taken from a snapshot (like a checkpoint), and only copies the SSTables that aren't already in the
+// backup directory (SSTables are immutable, so a table is identified by its file number, size and checksum)
+type BackupEngine struct {
+	dir string
+	cmp Comparator
+	fs  FS
+}
+
+// BackupInfo : Describes a single backup (i.e. a generation)
+type BackupInfo struct {
+	ID        int
+	Timestamp time.Time
+	Size      int64 // total size of the backup's files (including the tables that are shared with other backups)
+	NumFiles  int
+}
+
+// backupMeta : The contents of a backup's metadata file
+type backupMeta struct {
+	timestamp  time.Time
+	comparator string
+	tables     []backedUpTable
+}
+
+type backedUpTable struct {
+	fileNum  int
+	size     int64
+	checksum uint32
+}
+
+func (t backedUpTable) sharedName() string {
+	return fmt.Sprintf(sharedTableFilename, t.fileNum, t.size, t.checksum)
+}
+
+// OpenBackupEngine : Opens (or creates) the backup directory
+// opts.Comparator and opts.FS must match the comparator and the file system of the db that's backed up (a nil
+// *Options uses the defaults)
+func OpenBackupEngine(dir string, opts *Options) (*BackupEngine, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	fs := opts.fs()
+	for _, subdir := range []string{sharedBackupDir, privateBackupDir, metaBackupDir} {
+		err := fs.MkdirAll(filepath.Join(dir, subdir))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &BackupEngine{dir: dir, cmp: opts.comparator(), fs: fs}, nil
+}
+
+// CreateNewBackup : Backs up the db (while writes to the db continue), and returns the new backup's info
+func (e *BackupEngine) CreateNewBackup(db *ClevelDB) (BackupInfo, error) {
+	if db.comparator.Name() != e.cmp.Name() {
+		return BackupInfo{}, comparatorMismatchErr
+	}
+	if !db.fs.SameFS(e.fs) {
+		return BackupInfo{}, backupFSMismatchErr
+	}
+
+	ids, err := e.backupIDs()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	// The backup is taken from a snapshot (same as a checkpoint), which holds a reference to each table, so compaction
+	// can't remove the tables while they're backed up
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+	journalNum := db.checkpointJournalNum()
+
+	// The timestamp is truncated the same way as when the metadata is read back
+	meta := &backupMeta{timestamp: time.Unix(0, timeNow().UnixNano()), comparator: db.comparator.Name()}
+
+	for _, ss := range snapshot.tables {
+		// The table's checksum was taken when the table was written (see tableBuilder.finish), so it isn't read
+		table := backedUpTable{fileNum: ss.fileNum, size: ss.size, checksum: ss.checksum}
+		meta.tables = append(meta.tables, table)
+
+		// Tables that are already in the backup directory (i.e. from a previous backup) aren't copied again
+		sharedPath := filepath.Join(e.dir, sharedBackupDir, table.sharedName())
+		if exists(e.fs, sharedPath) {
+			continue
+		}
+
+		err = e.shareTable(ss.path, sharedPath)
+		if err != nil {
+			return BackupInfo{}, err
+		}
+	}
+
+	privateDir := filepath.Join(e.dir, privateBackupDir, strconv.Itoa(id))
+	err = e.fs.MkdirAll(privateDir)
+	if err == nil {
+		err = writeCheckpointJournals(e.fs, privateDir, privateDir, db.comparator, snapshot, journalNum)
+	}
+	if err != nil {
+		_ = e.fs.RemoveAll(privateDir)
+		return BackupInfo{}, err
+	}
+
+	// The backup only exists once its metadata has been written (which is the last step)
+	err = e.writeMeta(id, meta)
+	if err != nil {
+		_ = e.fs.RemoveAll(privateDir)
+		return BackupInfo{}, err
+	}
+
+	return e.backupInfo(id, meta)
+}
+
+// shareTable : Adds the table to the shared directory. The table is hard linked (or copied, if the link fails) under
+// a temporary name first and then renamed, so a table that was only partially copied is never shared
+func (e *BackupEngine) shareTable(path, sharedPath string) error {
+	tmpPath := sharedPath + ".tmp"
+	err := e.fs.Link(path, tmpPath)
+	if err != nil {
+		err = copyFile(e.fs, path, tmpPath)
+	}
+	if err == nil {
+		err = e.fs.Rename(tmpPath, sharedPath)
+	}
+	if err != nil {
+		_ = e.fs.Remove(tmpPath)
+	}
+	return err
+}
+
+// GetBackupInfo : Returns the info of every backup (from oldest to newest)
+func (e *BackupEngine) GetBackupInfo() ([]BackupInfo, error) {
+	ids, err := e.backupIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []BackupInfo
+	for _, id := range ids {
+		meta, err := e.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := e.backupInfo(id, meta)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// DeleteBackup : Deletes the backup, along with any tables that no other backup uses
+func (e *BackupEngine) DeleteBackup(id int) error {
+	err := e.fs.Remove(e.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return backupNotFoundErr
+	} else if err != nil {
+		return err
+	}
+
+	err = e.fs.RemoveAll(filepath.Join(e.dir, privateBackupDir, strconv.Itoa(id)))
+	if err != nil {
+		return err
+	}
+
+	return e.removeUnusedTables()
+}
+
+// PurgeOldBackups : Deletes every backup except for the most recent numToKeep backups (returns negativeNumToKeepErr if
+// numToKeep is negative)
+func (e *BackupEngine) PurgeOldBackups(numToKeep int) error {
+	if numToKeep < 0 {
+		return negativeNumToKeepErr
+	}
+
+	ids, err := e.backupIDs()
+	if err != nil {
+		return err
+	}
+
+	for len(ids) > numToKeep {
+		err = e.DeleteBackup(ids[0])
+		if err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+
+	return nil
+}
+
+// VerifyBackup : Checks that every file of the backup is intact, by comparing each table's size and checksum with
+// the backup's metadata, and reading every block of each table (verifying the block's checksum and key order)
+func (e *BackupEngine) VerifyBackup(id int) error {
+	meta, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+
+	if meta.comparator != e.cmp.Name() {
+		return comparatorMismatchErr
+	}
+
+	for _, table := range meta.tables {
+		path := filepath.Join(e.dir, sharedBackupDir, table.sharedName())
+		size, checksum, err := tableChecksum(e.fs, path)
+		if err != nil {
+			return fmt.Errorf("%w: %v", backupCorruptErr, err)
+		}
+		if size != table.size || checksum != table.checksum {
+			return fmt.Errorf("%w: %s doesn't match its checksum", backupCorruptErr, table.sharedName())
+		}
+
+		ss, reader, err := loadSSTable(e.fs, path)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", backupCorruptErr, table.sharedName(), err)
+		}
+		if ss.checksum != table.checksum {
+			_ = reader.file.Close()
+			return fmt.Errorf("%w: %s doesn't match its checksum", backupCorruptErr, table.sharedName())
+		}
+
+		err = ss.validate(reader, e.cmp)
+		_ = reader.file.Close()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", backupCorruptErr, table.sharedName(), err)
+		}
+	}
+
+	// Every backup has at least one journal (i.e. the memtable's, even if it's empty)
+	privateDir := filepath.Join(e.dir, privateBackupDir, strconv.Itoa(id))
+	journals, err := journalFilenames(e.fs, privateDir)
+	if err != nil {
+		return fmt.Errorf("%w: %v", backupCorruptErr, err)
+	} else if len(journals) == 0 {
+		return fmt.Errorf("%w: missing journal", backupCorruptErr)
+	}
+	if _, err := e.fs.Stat(filepath.Join(privateDir, manifestFilename)); err != nil {
+		return fmt.Errorf("%w: %v", backupCorruptErr, err)
+	}
+
+	return nil
+}
+
+// RestoreDBFromBackup : Verifies the backup, and then restores it into the directory (which must be empty, or not
+// exist yet). The directory has the same layout as a checkpoint
+func (e *BackupEngine) RestoreDBFromBackup(id int, dir string) error {
+	if filenames, err := e.fs.List(dir); err == nil && len(filenames) > 0 {
+		return restoreDirNotEmptyErr
+	}
+
+	err := e.VerifyBackup(id)
+	if err != nil {
+		return err
+	}
+
+	meta, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+
+	tablesDir := filepath.Join(dir, ssTablesDir)
+	err = e.fs.MkdirAll(tablesDir)
+	if err != nil {
+		return err
+	}
+
+	// The tables are copied (rather than linked), so that the restored db doesn't depend on the backup directory
+	for _, table := range meta.tables {
+		src := filepath.Join(e.dir, sharedBackupDir, table.sharedName())
+		err = copyFile(e.fs, src, filepath.Join(tablesDir, fmt.Sprintf(ssTableFilename, table.fileNum)))
+		if err != nil {
+			return err
+		}
+	}
+
+	privateDir := filepath.Join(e.dir, privateBackupDir, strconv.Itoa(id))
+	err = copyFile(e.fs, filepath.Join(privateDir, manifestFilename), filepath.Join(tablesDir, manifestFilename))
+	if err != nil {
+		return err
+	}
+
+	journals, err := journalFilenames(e.fs, privateDir)
+	if err != nil {
+		return err
+	}
+	for _, filename := range journals {
+		err = copyFile(e.fs, filepath.Join(privateDir, filename), filepath.Join(dir, filename))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalFilenames : Returns the names of the journals in the directory (oldest first)
+func journalFilenames(fs FS, dir string) ([]string, error) {
+	journalNums, err := listJournals(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, len(journalNums))
+	for i, journalNum := range journalNums {
+		filenames[i] = fmt.Sprintf(journalFilename, journalNum)
+	}
+	return filenames, nil
+}
+
+// removeUnusedTables : Removes the shared tables that aren't used by any backup
+func (e *BackupEngine) removeUnusedTables() error {
+	ids, err := e.backupIDs()
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, id := range ids {
+		meta, err := e.readMeta(id)
+		if err != nil {
+			return err
+		}
+		for _, table := range meta.tables {
+			used[table.sharedName()] = true
+		}
+	}
+
+	sharedDir := filepath.Join(e.dir, sharedBackupDir)
+	filenames, err := e.fs.List(sharedDir)
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range filenames {
+		if !used[filename] {
+			err = e.fs.Remove(filepath.Join(sharedDir, filename))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// backupIDs : Returns the IDs of every backup (in ascending order)
+func (e *BackupEngine) backupIDs() ([]int, error) {
+	filenames, err := e.fs.List(filepath.Join(e.dir, metaBackupDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, filename := range filenames {
+		// Metadata that's still being written (i.e. a temporary file) isn't a backup yet
+		id, err := strconv.Atoi(filename)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (e *BackupEngine) backupInfo(id int, meta *backupMeta) (BackupInfo, error) {
+	info := BackupInfo{ID: id, Timestamp: meta.timestamp, NumFiles: len(meta.tables)}
+	for _, table := range meta.tables {
+		info.Size += table.size
+	}
+
+	privateDir := filepath.Join(e.dir, privateBackupDir, strconv.Itoa(id))
+	journals, err := journalFilenames(e.fs, privateDir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	for _, filename := range append(journals, manifestFilename) {
+		fileInfo, err := e.fs.Stat(filepath.Join(privateDir, filename))
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		info.Size += fileInfo.Size()
+		info.NumFiles++
+	}
+
+	return info, nil
+}
+
+func (e *BackupEngine) metaPath(id int) string {
+	return filepath.Join(e.dir, metaBackupDir, strconv.Itoa(id))
+}
+
+// writeMeta : Writes the backup's metadata (one "name value..." entry per line, like the MANIFEST)
+// The metadata is written to a temporary file first and then renamed, so a backup never has partial metadata
+func (e *BackupEngine) writeMeta(id int, meta *backupMeta) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "timestamp %d\n", meta.timestamp.UnixNano())
+	fmt.Fprintf(&buf, "comparator %s\n", meta.comparator)
+	for _, table := range meta.tables {
+		fmt.Fprintf(&buf, "table %d %d %d\n", table.fileNum, table.size, table.checksum)
+	}
+
+	tmpPath := e.metaPath(id) + ".tmp"
+	err := writeFile(e.fs, tmpPath, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return e.fs.Rename(tmpPath, e.metaPath(id))
+}
+
+func (e *BackupEngine) readMeta(id int) (*backupMeta, error) {
+	file, err := e.fs.Open(e.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, backupNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	meta := &backupMeta{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%w: corrupt metadata line: %q", backupCorruptErr, scanner.Text())
+		}
+
+		switch fields[0] {
+		case "timestamp":
+			timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", backupCorruptErr, err)
+			}
+			meta.timestamp = time.Unix(0, timestamp)
+		case "comparator":
+			meta.comparator = fields[1]
+		case "table":
+			var table backedUpTable
+			_, err := fmt.Sscanf(scanner.Text(), "table %d %d %d", &table.fileNum, &table.size, &table.checksum)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", backupCorruptErr, err)
+			}
+			meta.tables = append(meta.tables, table)
+		}
+	}
+
+	return meta, scanner.Err()
+}
+
+// tableChecksum : Returns the size of the table's file and the checksum of its contents, which is computed the same
+// way as when the table was written (see tableBuilder.finish)
+func tableChecksum(fs FS, path string) (int64, uint32, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	// The checksum itself is at the end of the header
+	header := make([]byte, headerSizeInBytes-4)
+	_, err = file.ReadAt(header, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	_, err = file.Seek(headerSizeInBytes, io.SeekStart)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	hash := crc32.NewIEEE()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, _ = hash.Write(header)
+
+	return headerSizeInBytes + size, hash.Sum32(), nil
+}
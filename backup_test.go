@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func Test_BackupEngineCreatesIncrementalBackupsAndRestores(t *testing.T) {
+	db := newTestClevelDB(t)
+	engine, err := OpenBackupEngine("backups", &Options{FS: db.fs})
+	if err != nil {
+		t.Fatalf("error opening backup engine: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("first"))
+	}
+	flushTestMemtable(t, db)
+	_ = db.Put([]byte("key000"), []byte("memtable"))
+
+	first, err := engine.CreateNewBackup(db)
+	if err != nil {
+		t.Fatalf("CreateNewBackup returns unexpected error: %v", err)
+	}
+
+	_ = db.Put([]byte("key001"), []byte("second"))
+	_ = db.Delete([]byte("key002"))
+	flushTestMemtable(t, db)
+
+	second, err := engine.CreateNewBackup(db)
+	if err != nil {
+		t.Fatalf("CreateNewBackup returns unexpected error: %v", err)
+	}
+
+	// The second backup shares the first table, so only the new table is copied
+	if first.ID != 1 || second.ID != 2 || first.NumFiles != 3 || second.NumFiles != 4 {
+		t.Errorf("CreateNewBackup returns unexpected info: %+v, %+v", first, second)
+	}
+	if entries, _ := db.fs.List(filepath.Join(engine.dir, sharedBackupDir)); len(entries) != 2 {
+		t.Errorf("CreateNewBackup leaves unexpected shared tables: %v", entries)
+	}
+
+	infos, err := engine.GetBackupInfo()
+	if err != nil || len(infos) != 2 || infos[0] != first || infos[1] != second {
+		t.Errorf("GetBackupInfo returns unexpected info: %+v (%v)", infos, err)
+	}
+
+	for _, id := range []int{1, 2} {
+		if err := engine.VerifyBackup(id); err != nil {
+			t.Errorf("VerifyBackup(%d) returns unexpected error: %v", id, err)
+		}
+	}
+
+	dir := "restored"
+	if err := engine.RestoreDBFromBackup(1, dir); err != nil {
+		t.Fatalf("RestoreDBFromBackup returns unexpected error: %v", err)
+	}
+	if err := engine.RestoreDBFromBackup(2, dir); err != restoreDirNotEmptyErr {
+		t.Errorf("RestoreDBFromBackup returns unexpected error for non-empty directory: %v", err)
+	}
+
+	restored := loadTestDBFromDir(t, db.fs, dir, nil)
+	for key, expected := range map[string]string{"key000": "memtable", "key001": "first", "key002": "first"} {
+		if val, err := restored.Get([]byte(key)); string(val) != expected || err != nil {
+			t.Errorf(`restored.Get("%s") returns unexpected value: "%s" (%v)`, key, val, err)
+		}
+	}
+}
+
+func Test_BackupEnginePurgesOldBackups(t *testing.T) {
+	db := newTestClevelDB(t)
+	engine, _ := OpenBackupEngine("backups", &Options{FS: db.fs})
+
+	for i := 0; i < 3; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("val"))
+		flushTestMemtable(t, db)
+		if _, err := engine.CreateNewBackup(db); err != nil {
+			t.Fatalf("CreateNewBackup returns unexpected error: %v", err)
+		}
+	}
+
+	// Compacting the db replaces its tables, so the next backup doesn't share any tables with the previous backups
+	if err := db.Compact(); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+	if _, err := engine.CreateNewBackup(db); err != nil {
+		t.Fatalf("CreateNewBackup returns unexpected error: %v", err)
+	}
+
+	if err := engine.PurgeOldBackups(-1); err != negativeNumToKeepErr {
+		t.Errorf("PurgeOldBackups returns unexpected error for negative number of backups: %v", err)
+	}
+	if infos, _ := engine.GetBackupInfo(); len(infos) != 4 {
+		t.Errorf("PurgeOldBackups deletes unexpected backups for negative number of backups: %+v", infos)
+	}
+
+	if err := engine.PurgeOldBackups(1); err != nil {
+		t.Fatalf("PurgeOldBackups returns unexpected error: %v", err)
+	}
+
+	infos, _ := engine.GetBackupInfo()
+	if len(infos) != 1 || infos[0].ID != 4 {
+		t.Errorf("PurgeOldBackups leaves unexpected backups: %+v", infos)
+	}
+	if entries, _ := db.fs.List(filepath.Join(engine.dir, sharedBackupDir)); len(entries) != len(db.tables) {
+		t.Errorf("PurgeOldBackups leaves unexpected shared tables: %v", entries)
+	}
+	if err := engine.VerifyBackup(1); err != backupNotFoundErr {
+		t.Errorf("VerifyBackup returns unexpected error for purged backup: %v", err)
+	}
+	if err := engine.VerifyBackup(4); err != nil {
+		t.Errorf("VerifyBackup returns unexpected error: %v", err)
+	}
+}
+
+func Test_BackupEngineDetectsCorruption(t *testing.T) {
+	db := newTestClevelDB(t)
+	engine, _ := OpenBackupEngine("backups", &Options{FS: db.fs})
+
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("val"))
+	}
+	flushTestMemtable(t, db)
+	if _, err := engine.CreateNewBackup(db); err != nil {
+		t.Fatalf("CreateNewBackup returns unexpected error: %v", err)
+	}
+
+	// Flip a byte in the middle of the backed up table
+	entries, _ := db.fs.List(filepath.Join(engine.dir, sharedBackupDir))
+	path := filepath.Join(engine.dir, sharedBackupDir, entries[0])
+	data, _ := readFile(db.fs, path)
+	data[len(data)/2] ^= 0xff
+	_ = writeFile(db.fs, path, data)
+
+	if err := engine.VerifyBackup(1); !errors.Is(err, backupCorruptErr) {
+		t.Errorf("VerifyBackup returns unexpected error for corrupt table: %v", err)
+	}
+
+	dir := "restored"
+	if err := engine.RestoreDBFromBackup(1, dir); !errors.Is(err, backupCorruptErr) {
+		t.Errorf("RestoreDBFromBackup returns unexpected error for corrupt backup: %v", err)
+	}
+
+	// A db with another comparator can't be backed up into the same directory
+	other := newTestClevelDBWithComparator(t, ReverseBytewiseComparator{})
+	if _, err := engine.CreateNewBackup(other); err != comparatorMismatchErr {
+		t.Errorf("CreateNewBackup returns unexpected error for mismatched comparator: %v", err)
+	}
+
+	// Nor can a db in another file system (its tables couldn't be linked into the backup)
+	if _, err := engine.CreateNewBackup(newTestClevelDB(t)); err != backupFSMismatchErr {
+		t.Errorf("CreateNewBackup returns unexpected error for mismatched file system: %v", err)
+	}
+}
+
+// openCountingFS : Counts the tables that are opened
+type openCountingFS struct {
+	FS
+	mu     sync.Mutex
+	opened int
+}
+
+func (f *openCountingFS) Open(name string) (File, error) {
+	if parseFileNum(name) != 0 {
+		f.mu.Lock()
+		f.opened++
+		f.mu.Unlock()
+	}
+	return f.FS.Open(name)
+}
+
+func (f *openCountingFS) SameFS(other FS) bool {
+	otherFS, ok := other.(*openCountingFS)
+	return ok && otherFS == f
+}
+
+func Test_BackupEngineDoesntReadTablesWhileBackingUp(t *testing.T) {
+	fs := &openCountingFS{FS: NewMemFS()}
+	db := loadTestDBFromDir(t, fs, "db", nil)
+	engine, _ := OpenBackupEngine("backups", &Options{FS: fs})
+
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("val"))
+	}
+	flushTestMemtable(t, db)
+	_ = db.Close()
+
+	// The loaded table's checksum is read from its header, and the tables that were written since the db was loaded
+	// have their checksums taken as they're written
+	db = loadTestDBFromDir(t, fs, "db", nil)
+	_ = db.Put([]byte("key000"), []byte("updated"))
+	flushTestMemtable(t, db)
+
+	fs.opened = 0
+	for i := 0; i < 2; i++ {
+		if _, err := engine.CreateNewBackup(db); err != nil {
+			t.Fatalf("CreateNewBackup returns unexpected error: %v", err)
+		}
+	}
+	if fs.opened != 0 {
+		t.Errorf("CreateNewBackup opens unexpected number of tables: %d", fs.opened)
+	}
+
+	// The second backup doesn't copy the tables again
+	if entries, _ := fs.List(filepath.Join(engine.dir, sharedBackupDir)); len(entries) != 2 {
+		t.Errorf("CreateNewBackup leaves unexpected shared tables: %v", entries)
+	}
+	for _, id := range []int{1, 2} {
+		if err := engine.VerifyBackup(id); err != nil {
+			t.Errorf("VerifyBackup(%d) returns unexpected error: %v", id, err)
+		}
+	}
+}
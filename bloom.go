@@ -2,10 +2,17 @@ package main
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"hash/fnv"
 	"log"
 )
 
+// A table's bloom filter uses 10 bits per key, which (with 7 hash functions) has a false positive rate of about 1%
+const (
+	bloomBitsPerKey = 10
+	bloomNumHashes  = 7
+)
+
 type BloomFilter struct {
 	data      []byte
 	size      int // in bytes
@@ -20,12 +27,26 @@ func newBloomFilter(size int, numHashes int) *BloomFilter {
 	}
 }
 
+// newBloomFilterFromHashes : Builds a filter for the keys with the given hashes (see bloomHash), so that a table
+// builder only needs to keep each key's hash (rather than a copy of the key) until it knows how many keys there are
+func newBloomFilterFromHashes(hashes []uint32, numHashes int) *BloomFilter {
+	b := newBloomFilter((len(hashes)*bloomBitsPerKey+7)/8, numHashes)
+	for _, h := range hashes {
+		b.addHash(h)
+	}
+	return b
+}
+
 func (b *BloomFilter) Add(item []byte) {
+	b.addHash(bloomHash(item))
+}
+
+func (b *BloomFilter) addHash(h uint32) {
 	var bitIdx, targetByteIdx, byteBitIdx int
 
 	for i := 0; i < b.numHashes; i++ {
 		// The index of the bit to flip on
-		bitIdx = b.hash(append(item, byte(i))) % (b.size * 8)
+		bitIdx = b.probe(h, i)
 		targetByteIdx = bitIdx / 8
 		byteBitIdx = bitIdx % 8
 
@@ -34,7 +55,8 @@ func (b *BloomFilter) Add(item []byte) {
 	}
 }
 
-func (b *BloomFilter) hash(item []byte) int {
+// bloomHash : Hashes the item once, and the filter's hash functions are derived from that hash (see probe)
+func bloomHash(item []byte) uint32 {
 	h := fnv.New32a()
 
 	_, err := h.Write(item)
@@ -42,15 +64,23 @@ func (b *BloomFilter) hash(item []byte) int {
 		log.Fatal(err)
 	}
 
-	return int(h.Sum32())
+	return h.Sum32()
+}
+
+// probe : Returns the index of the bit for the i-th hash function, using double hashing (i.e. the i-th hash is the
+// item's hash plus i times a second hash, which is the item's hash rotated by 17 bits)
+func (b *BloomFilter) probe(h uint32, i int) int {
+	delta := h>>17 | h<<15
+	return int((h + uint32(i)*delta) % uint32(b.size*8))
 }
 
 func (b *BloomFilter) MaybeContains(item []byte) bool {
 	var bitIdx, targetByteIdx, byteBitIdx int
+	h := bloomHash(item)
 
 	for i := 0; i < b.numHashes; i++ {
 		// The index of the bit that should be on
-		bitIdx = b.hash(append(item, byte(i))) % (b.size * 8)
+		bitIdx = b.probe(h, i)
 
 		// The index of the byte that contains the bit (that should be on)
 		targetByteIdx = bitIdx / 8
@@ -70,6 +100,28 @@ func (b *BloomFilter) MemoryUsage() int {
 	return binary.Size(b.data)
 }
 
+// encode : Returns the filter block that's written to a table: the filter's bits, followed by the number of hash
+// functions (as a uint8) and a checksum of both (as a uint32)
+func (b *BloomFilter) encode() []byte {
+	block := append(append([]byte(nil), b.data...), byte(b.numHashes))
+	return binary.BigEndian.AppendUint32(block, crc32.ChecksumIEEE(block))
+}
+
+// decodeBloomFilter : Decodes a filter block (see encode)
+func decodeBloomFilter(block []byte) (*BloomFilter, error) {
+	if len(block) < 6 {
+		return nil, corruptSSTableErr
+	}
+
+	data, checksum := block[:len(block)-4], binary.BigEndian.Uint32(block[len(block)-4:])
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, checksumMismatchErr
+	}
+
+	numBytes := len(data) - 1
+	return &BloomFilter{data: data[:numBytes], size: numBytes, numHashes: int(data[numBytes])}, nil
+}
+
 func setBit(n byte, pos uint) byte {
 	n |= 1 << pos
 	return n
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// The cache is split into shards (each with its own lock and LRU list), so that concurrent readers don't all
+// contend on a single lock
+const numBlockCacheShards = 16
+
+const defaultBlockCacheCapacity int64 = 8 << 20 // 8MB (same as LevelDB)
+
+// blockCacheKey : Blocks are identified by the number of their table's file and their offset within the file
+// (file numbers are never reused, so the blocks of deleted tables are simply evicted once they're no longer used)
+type blockCacheKey struct {
+	fileNum int
+	offset  int64
+}
+
+type blockCacheEntry struct {
+	key    blockCacheKey
+	value  any
+	charge int64
+	pinned bool
+}
+
+// BlockCache : A sharded LRU cache of decoded blocks (shared by every SSTable), whose capacity is in bytes
+// Pinned entries (i.e. index blocks, see Options.PinIndexBlocks) count toward the capacity, but are never evicted
+type BlockCache struct {
+	shards [numBlockCacheShards]blockCacheShard
+	hits   uint64
+	misses uint64
+}
+
+type blockCacheShard struct {
+	mu         sync.Mutex
+	capacity   int64
+	size       int64
+	pinnedSize int64
+	lru        *list.List // unpinned entries, from most to least recently used
+	entries    map[blockCacheKey]*list.Element
+}
+
+// BlockCacheStats : A snapshot of the cache's statistics
+type BlockCacheStats struct {
+	Hits       uint64
+	Misses     uint64
+	Size       int64 // the total charge of every entry (including pinned entries)
+	PinnedSize int64
+	Capacity   int64
+}
+
+func newBlockCache(capacity int64) *BlockCache {
+	cache := &BlockCache{}
+	for i := range cache.shards {
+		cache.shards[i].capacity = capacity / numBlockCacheShards
+		cache.shards[i].lru = list.New()
+		cache.shards[i].entries = make(map[blockCacheKey]*list.Element)
+	}
+	return cache
+}
+
+func (c *BlockCache) shard(key blockCacheKey) *blockCacheShard {
+	// Blocks of the same table are spread across the shards by their offset
+	h := uint64(key.fileNum)*0x9E3779B97F4A7C15 ^ uint64(key.offset)
+	h ^= h >> 29
+	return &c.shards[h%numBlockCacheShards]
+}
+
+// Get : Returns the cached value (and marks it as the most recently used)
+func (c *BlockCache) Get(key blockCacheKey) (any, bool) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	element, ok := shard.entries[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	entry := element.Value.(*blockCacheEntry)
+	if !entry.pinned {
+		shard.lru.MoveToFront(element)
+	}
+	return entry.value, true
+}
+
+// Insert : Caches the value, evicting the least recently used entries if the shard is over capacity (values that are
+// larger than a shard's capacity aren't cached)
+func (c *BlockCache) Insert(key blockCacheKey, value any, charge int64) {
+	c.insert(&blockCacheEntry{key: key, value: value, charge: charge})
+}
+
+// Pin : Caches the value until it's erased (i.e. it's never evicted)
+func (c *BlockCache) Pin(key blockCacheKey, value any, charge int64) {
+	c.insert(&blockCacheEntry{key: key, value: value, charge: charge, pinned: true})
+}
+
+func (c *BlockCache) insert(entry *blockCacheEntry) {
+	shard := c.shard(entry.key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if element, ok := shard.entries[entry.key]; ok {
+		// A pinned entry isn't replaced by an unpinned copy (e.g. a partition that was read before it was pinned)
+		if element.Value.(*blockCacheEntry).pinned && !entry.pinned {
+			return
+		}
+		shard.remove(element)
+	}
+
+	// An entry that's larger than the shard could never be evicted (since the entry that was just inserted is never
+	// evicted), so it isn't cached at all
+	if !entry.pinned && entry.charge > shard.capacity {
+		return
+	}
+
+	var element *list.Element
+	if entry.pinned {
+		// Pinned entries aren't kept in the LRU list (so they can't be evicted)
+		element = &list.Element{Value: entry}
+		shard.pinnedSize += entry.charge
+	} else {
+		element = shard.lru.PushFront(entry)
+	}
+
+	shard.entries[entry.key] = element
+	shard.size += entry.charge
+
+	// Evict least recently used entries (but never the one that was just inserted)
+	for shard.size > shard.capacity && shard.lru.Len() > 0 && shard.lru.Back() != element {
+		shard.remove(shard.lru.Back())
+	}
+}
+
+// Erase : Removes the value from the cache (including pinned values)
+func (c *BlockCache) Erase(key blockCacheKey) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if element, ok := shard.entries[key]; ok {
+		shard.remove(element)
+	}
+}
+
+func (s *blockCacheShard) remove(element *list.Element) {
+	entry := element.Value.(*blockCacheEntry)
+	if entry.pinned {
+		s.pinnedSize -= entry.charge
+	} else {
+		s.lru.Remove(element)
+	}
+
+	delete(s.entries, entry.key)
+	s.size -= entry.charge
+}
+
+func (c *BlockCache) Stats() BlockCacheStats {
+	stats := BlockCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		stats.Size += shard.size
+		stats.PinnedSize += shard.pinnedSize
+		stats.Capacity += shard.capacity
+		shard.mu.Unlock()
+	}
+
+	return stats
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_BlockCacheEvictsLeastRecentlyUsedBlocks(t *testing.T) {
+	// Every key hashes to a shard, so give each shard room for 2 blocks of 10 bytes
+	cache := newBlockCache(20 * numBlockCacheShards)
+	keys := make([]blockCacheKey, 3)
+
+	// Find 3 keys that land in the same shard
+	for offset, found := int64(0), 0; found < len(keys); offset++ {
+		key := blockCacheKey{fileNum: 1, offset: offset}
+		if found == 0 || cache.shard(key) == cache.shard(keys[0]) {
+			keys[found] = key
+			found++
+		}
+	}
+
+	cache.Insert(keys[0], "a", 10)
+	cache.Insert(keys[1], "b", 10)
+
+	// Using the first block makes the second block the least recently used one
+	if val, ok := cache.Get(keys[0]); !ok || val != "a" {
+		t.Errorf("cache.Get returns unexpected value: %v", val)
+	}
+	cache.Insert(keys[2], "c", 10)
+
+	if _, ok := cache.Get(keys[1]); ok {
+		t.Errorf("cache.Get returns an evicted block")
+	}
+	if _, ok := cache.Get(keys[0]); !ok {
+		t.Errorf("cache.Get doesn't return the most recently used block")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Size != 20 {
+		t.Errorf("cache.Stats returns unexpected stats: %+v", stats)
+	}
+}
+
+func Test_BlockCacheNeverEvictsPinnedBlocks(t *testing.T) {
+	cache := newBlockCache(10 * numBlockCacheShards)
+	pinned := blockCacheKey{fileNum: 1, offset: 0}
+	cache.Pin(pinned, "index", 10)
+
+	// Fill every shard with unpinned blocks
+	for offset := int64(1); offset < 100; offset++ {
+		cache.Insert(blockCacheKey{fileNum: 2, offset: offset}, "block", 10)
+	}
+
+	if _, ok := cache.Get(pinned); !ok {
+		t.Errorf("cache.Get doesn't return the pinned block")
+	}
+
+	stats := cache.Stats()
+	if stats.PinnedSize != 10 || stats.Size > stats.Capacity+10 {
+		t.Errorf("cache.Stats returns unexpected stats: %+v", stats)
+	}
+
+	cache.Erase(pinned)
+	if _, ok := cache.Get(pinned); ok || cache.Stats().PinnedSize != 0 {
+		t.Errorf("cache.Get returns an erased block")
+	}
+}
+
+func Test_ClevelDBReadsBlocksThroughCache(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.blockCache = newBlockCache(defaultBlockCacheCapacity)
+	db.tableCache = newTableCache(db.blockCache, &Options{PinIndexBlocks: true})
+
+	for i := 0; i < 100; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+	}
+	flushTestMemtable(t, db)
+
+	if stats := db.BlockCacheStats(); stats.PinnedSize != acquireTestReader(t, db.tables[0]).index.size() {
+		t.Errorf("storage.BlockCacheStats returns unexpected pinned size: %v", stats.PinnedSize)
+	}
+
+	// The first read of the block is a miss, and every read after that is a hit
+	for i := 0; i < 3; i++ {
+		if val, err := db.Get([]byte("key050")); string(val) != "50" || err != nil {
+			t.Errorf(`storage.Get("key050") returns unexpected value: "%s" (%v)`, val, err)
+		}
+	}
+
+	if stats := db.BlockCacheStats(); stats.Misses != 1 || stats.Hits != 2 {
+		t.Errorf("storage.BlockCacheStats returns unexpected stats: %+v", stats)
+	}
+
+	// Iterators that don't fill the cache leave it as is
+	size := db.BlockCacheStats().Size
+	iter := db.NewIterator(&ReadOptions{})
+	if len(collectKeys(iter, iter.Next)) != 100 || db.BlockCacheStats().Size != size {
+		t.Errorf("NewIterator (without filling the cache) changes the cache's size: %v", db.BlockCacheStats().Size)
+	}
+	iter.Release()
+
+	iter = db.NewIterator(nil)
+	collectKeys(iter, iter.Next)
+	if db.BlockCacheStats().Size <= size {
+		t.Errorf("NewIterator doesn't fill the cache")
+	}
+	iter.Release()
+}
+
+func Test_BlockCacheSkipsBlocksLargerThanShard(t *testing.T) {
+	cache := newBlockCache(10 * numBlockCacheShards)
+	key := blockCacheKey{fileNum: 1, offset: 0}
+	cache.Insert(key, "small", 5)
+
+	// Replacing the block with one that doesn't fit also drops the old block
+	cache.Insert(key, "large", 11)
+	if val, ok := cache.Get(key); ok {
+		t.Errorf("cache.Get returns a block that's larger than its shard: %v", val)
+	}
+
+	stats := cache.Stats()
+	if stats.Size != 0 {
+		t.Errorf("cache.Stats returns unexpected size: %+v", stats)
+	}
+}
+
+func Test_ClevelDBPinsIndexPartitions(t *testing.T) {
+	// The cache is small enough that reading every data block evicts most of them
+	db := loadTestDBFromDir(t, NewMemFS(), "db", &Options{
+		PartitionedIndex:   true,
+		PinIndexBlocks:     true,
+		BlockCacheCapacity: 64 * numBlockCacheShards,
+	})
+	for i := 0; i < 500; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+	}
+	flushTestMemtable(t, db)
+
+	iter := db.NewIterator(nil)
+	if len(collectKeys(iter, iter.Next)) != 500 {
+		t.Errorf("NewIterator returns unexpected number of keys")
+	}
+	iter.Release()
+
+	// Every partition (and the top-level index) of each table is still cached, even though the data blocks were
+	// evicted
+	var pinnedSize int64
+	for _, table := range db.tables {
+		reader, err := table.acquire()
+		if err != nil {
+			t.Fatalf("error opening table: %v", err)
+		}
+		if !reader.index.partitioned {
+			t.Fatalf("table doesn't have a partitioned index")
+		}
+
+		for _, handle := range reader.index.blocks {
+			if _, ok := db.blockCache.Get(blockCacheKey{fileNum: reader.fileNum, offset: handle.offset}); !ok {
+				t.Errorf("cache.Get doesn't return the pinned partition at offset %d", handle.offset)
+			}
+			pinnedSize += handle.size
+		}
+		if _, ok := db.blockCache.Get(blockCacheKey{fileNum: reader.fileNum, offset: reader.index.offset}); !ok {
+			t.Errorf("cache.Get doesn't return the pinned index")
+		}
+		pinnedSize += reader.index.size()
+		reader.release()
+	}
+
+	if stats := db.BlockCacheStats(); stats.PinnedSize != pinnedSize || stats.Size > stats.Capacity+pinnedSize {
+		t.Errorf("storage.BlockCacheStats returns unexpected stats: %+v (expected pinned size %d)", stats, pinnedSize)
+	}
+
+	// The pinned blocks are erased once the tables' readers are closed
+	for _, table := range db.tables {
+		db.tableCache.evict(table.fileNum)
+	}
+	if stats := db.BlockCacheStats(); stats.PinnedSize != 0 {
+		t.Errorf("storage.BlockCacheStats returns unexpected pinned size after closing the tables: %d", stats.PinnedSize)
+	}
+}
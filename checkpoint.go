@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+var checkpointExistsErr = errors.New("checkpoint directory already exists")
+
+// Checkpoint : Creates a consistent copy of the db in the given directory (which must not exist yet), while writes
+// to the db continue. The directory has the same layout as the db (i.e. journals, and a tables directory with the
+// SSTables and the MANIFEST), so loading a db from within the directory opens the checkpoint
+//
+// The checkpoint is taken from a snapshot (writes are only blocked while the snapshot copies the memtable): SSTables
+// are immutable, so they're hard linked (or copied, if they're on another file system), and each of the snapshot's
+// memtables (including the full memtables that are waiting to be flushed) is rewritten to its own journal (rather
+// than copied, since the live journal may have a partially written record at the end)
+func (db *ClevelDB) Checkpoint(dir string) error {
+	if exists(db.fs, dir) {
+		return checkpointExistsErr
+	}
+
+	// The snapshot holds a reference to each table, so compaction can't remove them while they're linked
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+
+	journalNum := db.checkpointJournalNum()
+
+	tablesDir := filepath.Join(dir, ssTablesDir)
+	err := db.fs.MkdirAll(tablesDir)
+	if err != nil {
+		return err
+	}
+
+	err = writeCheckpoint(db.fs, dir, tablesDir, db.comparator, snapshot, journalNum)
+	if err != nil {
+		_ = db.fs.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// checkpointJournalNum : Returns the number of a checkpoint's first journal, which is numbered after every table, so
+// that none of the journals look like they've already been flushed
+func (db *ClevelDB) checkpointJournalNum() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.nextFileNum
+}
+
+// writeCheckpoint : Writes the snapshot's tables and memtables to the directory (the memtables' journals are numbered
+// from journalNum, oldest first)
+func writeCheckpoint(fs FS, dir, tablesDir string, cmp Comparator, snapshot *Snapshot, journalNum int) error {
+	for _, table := range snapshot.tables {
+		path := filepath.Join(tablesDir, fmt.Sprintf(ssTableFilename, table.fileNum))
+		if err := fs.Link(table.path, path); err != nil {
+			if err := copyFile(fs, table.path, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeCheckpointJournals(fs, dir, tablesDir, cmp, snapshot, journalNum)
+}
+
+// writeCheckpointJournals : Writes the MANIFEST to tablesDir, and each of the snapshot's memtables to its own journal
+// in dir (numbered from journalNum, oldest first)
+func writeCheckpointJournals(fs FS, dir, tablesDir string, cmp Comparator, snapshot *Snapshot, journalNum int) error {
+	err := writeManifest(fs, tablesDir, &manifest{comparator: cmp.Name()})
+	if err != nil {
+		return err
+	}
+
+	for i := len(snapshot.memtables) - 1; i >= 0; i-- {
+		journalFile, err := fs.Create(filepath.Join(dir, fmt.Sprintf(journalFilename, journalNum)))
+		if err != nil {
+			return err
+		}
+		journalNum++
+
+		err = writeMemtableToJournal(journalFile, snapshot.memtables[i])
+		if closeErr := journalFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMemtableToJournal : Writes the records that recreate the memtable when the journal is recovered
+// The range tombstones are written first, since replaying a range tombstone deletes the keys that it covers (and
+// any key that was written after the range tombstone is in the memtable with its current value)
+func writeMemtableToJournal(file File, mem *Memtable) error {
+	for _, tombstone := range mem.rangeTombstones {
+		_, err := writeRecordToFile(file, DeleteRange, tombstone.start, tombstone.limit, 0, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	for current := mem.header.ptrs[0]; current != nil; current = current.ptrs[0] {
+		var err error
+		if current.operands != nil {
+			_, err = writeRecordToFile(file, Merge, current.key, encodeOperands(current.operands), 0, false)
+		} else {
+			_, err = writeKeyValPairToFile(file, current.key, current.val, current.expiresAt, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return file.Sync()
+}
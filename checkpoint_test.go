@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// loadTestDBFromDir : Loads the db in the file system's directory (the db is closed once the test finishes)
+func loadTestDBFromDir(t *testing.T, fs FS, dir string, opts *Options) *ClevelDB {
+	if opts == nil {
+		opts = &Options{}
+	}
+	withDir := *opts
+	withDir.FS, withDir.Dir = fs, dir
+
+	db, err := loadClevelDB(&withDir)
+	if err != nil {
+		t.Fatalf("error loading db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func Test_ClevelDBCheckpointIsConsistentAndOpenable(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.mergeOperator = StringAppendOperator{Delimiter: []byte(",")}
+
+	for i := 0; i < 30; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("flushed"))
+		if i%10 == 9 {
+			flushTestMemtable(t, db)
+		}
+	}
+
+	// The memtable has a mix of values, tombstones, a range tombstone and merge operands
+	_ = db.Put([]byte("key001"), []byte("memtable"))
+	_ = db.Delete([]byte("key002"))
+	_ = db.DeleteRange([]byte("key010"), []byte("key020"))
+	_ = db.Put([]byte("key015"), []byte("after range"))
+	_ = db.Merge([]byte("key025"), []byte("merged"))
+
+	dir := "checkpoint"
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatalf("Checkpoint returns unexpected error: %v", err)
+	}
+	if err := db.Checkpoint(dir); err != checkpointExistsErr {
+		t.Errorf("Checkpoint returns unexpected error for existing directory: %v", err)
+	}
+
+	// Writes (and compaction, which removes the tables) after the checkpoint don't affect it
+	_ = db.Put([]byte("key003"), []byte("after checkpoint"))
+	flushTestMemtable(t, db)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	checkpoint := loadTestDBFromDir(t, db.fs, dir, &Options{MergeOperator: StringAppendOperator{Delimiter: []byte(",")}})
+
+	for key, expected := range map[string]string{
+		"key000": "flushed", "key001": "memtable", "key003": "flushed", "key015": "after range",
+		"key025": "flushed,merged", "key029": "flushed",
+	} {
+		if val, err := checkpoint.Get([]byte(key)); string(val) != expected || err != nil {
+			t.Errorf(`checkpoint.Get("%s") returns unexpected value: "%s" (%v)`, key, val, err)
+		}
+	}
+
+	for _, key := range []string{"key002", "key010", "key019"} {
+		if val, err := checkpoint.Get([]byte(key)); err != notFoundInDBErr {
+			t.Errorf(`checkpoint.Get("%s") returns unexpected value for deleted key: "%s" (%v)`, key, val, err)
+		}
+	}
+}
+
+func Test_ClevelDBCheckpointIncludesMemtablesWaitingToBeFlushed(t *testing.T) {
+	fs := blockingFS{FS: NewMemFS(), release: make(chan struct{})}
+	defer close(fs.release)
+	db := loadTestDBFromDir(t, fs, "db", &Options{MaxImmutableMemtables: 4})
+
+	// The flush hangs, so the first memtable's keys are only in memory
+	putTestKeys(t, db, 0, 600)
+	db.mu.Lock()
+	numImmutables := len(db.immutables)
+	db.mu.Unlock()
+	if numImmutables == 0 {
+		t.Fatalf("db has no memtables waiting to be flushed")
+	}
+
+	// Writes continue while the checkpoints are taken
+	done := make(chan struct{})
+	go func() {
+		putTestKeys(t, db, 600, 800)
+		close(done)
+	}()
+
+	var dirs []string
+	for i := 0; i < 3; i++ {
+		dir := fmt.Sprintf("checkpoint%d", i)
+		if err := db.Checkpoint(dir); err != nil {
+			t.Fatalf("Checkpoint returns unexpected error: %v", err)
+		}
+		dirs = append(dirs, dir)
+	}
+	<-done
+
+	// Every checkpoint has the keys that were written before it, and the keys written during it are a prefix of the
+	// later writes (i.e. a write is never only partly in the checkpoint)
+	for _, dir := range dirs {
+		checkpoint := loadTestDBFromDir(t, fs.FS, dir, nil)
+		iter := checkpoint.NewIterator(nil)
+		keys := collectKeys(iter, iter.Next)
+		iter.Release()
+
+		if len(keys) < 600 {
+			t.Errorf("checkpoint %s has unexpected number of keys: %d", dir, len(keys))
+		}
+		for i, key := range keys {
+			if expected := fmt.Sprintf("key%05d=value", i); key != expected {
+				t.Errorf("checkpoint %s returns unexpected key: %s (expected %s)", dir, key, expected)
+				break
+			}
+		}
+	}
+}
@@ -1,31 +1,64 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
-	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
 const (
 	p                      float32 = 0.5 // from Skip List paper; Redis/LevelDB use 0.25
 	maxLevel               int     = 24  // arbitrary (i.e. don't remember)
-	journalFilename                = "journal.log"
+	journalFilename                = "journal_%d.log"
 	maxMemtableSizeInBytes int     = 4000 // kept small for testing
 )
 
 var notFoundInTableErr = errors.New("key not found in table")
 var notFoundInDBErr = errors.New("key not found in db")
 var deletedErr = errors.New("key is deleted")
+var corruptJournalErr = errors.New("corrupt journal")
+
+// timeNow is used for all TTL checks (tests replace it to simulate the passage of time)
+var timeNow = time.Now
 
 type ClevelDB struct {
 	memtable         *Memtable
-	flushingMemtable *Memtable
 	tables           []*SSTable
 	journal          bool
-	journalFile      *os.File
+	journalFile      File // the journal of the current memtable (see switchMemtable)
+	fs               FS
+	dir              string
+	tablesDir        string
+	nextFileNum      int
+	mergeOperator    MergeOperator
+	blockCache       *BlockCache // nil if the block cache is disabled
+	tableCache       *TableCache
+	partitionedIndex bool // whether the index of each new SSTable is partitioned
+	comparator       Comparator
+	lock             io.Closer // the lock on the directory's LOCK file (nil unless the db was loaded from a directory)
+	recovering       bool      // set while the journals are replayed
+
+	// Writes are serialized by writeMu, which a snapshot also holds while it copies the memtable (see NewSnapshot)
+	writeMu sync.Mutex
+
+	// The memtable and tables are swapped under mu, which also guards the background work (see makeRoomForWrite)
+	mu                    sync.Mutex
+	bgCond                *sync.Cond  // broadcast whenever a background flush or compaction finishes
+	immutables            []*Memtable // full memtables that are waiting to be flushed (oldest first)
+	bgRunning             bool        // whether the background goroutine (or Compact) is running
+	bgErr                 error       // the error from the background work, after which the db can't be written to
+	compactionPending     bool
+	l0SlowdownTrigger     int // the number of tables at which writes are slowed down (0 never slows them down)
+	l0StopTrigger         int // the number of tables at which writes are stopped (0 never stops them)
+	maxImmutableMemtables int
+	stallStats            WriteStallStats
+	compactionStats       compactionStats
+
+	metrics metrics // latency histograms and counters (see WriteMetrics)
 }
 
 func init() {
@@ -33,278 +66,300 @@ func init() {
 }
 
 type SkipListNode struct {
-	key  []byte
-	val  []byte
-	ptrs [maxLevel]*SkipListNode
+	key       []byte
+	val       []byte
+	expiresAt int64    // unix timestamp in nanoseconds (0 if the key never expires)
+	operands  [][]byte // merge operands (oldest first) that haven't been folded into a value yet
+	ptrs      [maxLevel]*SkipListNode
 }
 
-func loadClevelDB() (*ClevelDB, error) {
-	journalFile, _ := os.OpenFile(journalFilename, os.O_APPEND|os.O_RDWR|os.O_CREATE, os.ModePerm)
+func loadClevelDB(opts *Options) (*ClevelDB, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	fs := opts.fs()
+	tablesDir := filepath.Join(opts.Dir, ssTablesDir)
 
-	db := recoverMemtable(journalFile)
-	if db.Size() == 0 {
-		db = newClevelDB(true, journalFile)
+	// Only one ClevelDB can have the directory open at a time (the lock is held until Close)
+	err := fs.MkdirAll(tablesDir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := fs.Lock(filepath.Join(opts.Dir, lockFilename))
+	if err != nil {
+		return nil, err
 	}
 
-	tables := loadSSTables(ssTablesDir)
-	if len(tables) > 0 {
-		db.tables = tables
+	db, err := openClevelDB(opts, fs, tablesDir)
+	if err != nil {
+		_ = lock.Close()
+		return nil, err
 	}
 
+	db.lock = lock
 	return db, nil
 }
 
-func newClevelDB(journal bool, journalFile *os.File) *ClevelDB {
-	newDB := &ClevelDB{}
-	newMemtable := newMemtable()
-
-	newDB.memtable = newMemtable
-	newDB.journal = journal
-	newDB.journalFile = journalFile
-	return newDB
-}
-
-// Get : searches memtable first, and if key isn't found, searches all SSTables
-func (db *ClevelDB) Get(key []byte) ([]byte, error) {
-	node, err := db.memtable.Get(key)
-	if err != nil && err != notFoundInTableErr {
+func openClevelDB(opts *Options, fs FS, tablesDir string) (*ClevelDB, error) {
+	// Keys are ordered by the comparator, so a db can only be reopened with the comparator it was created with
+	err := checkManifest(fs, tablesDir, opts.comparator())
+	if err != nil {
 		return nil, err
-	} else if err == nil {
-		return node.val, nil
 	}
 
-	// Code reaches here if key not found in memtable
-	// Search most recently flushed tables first
-	// Return immediately if key is found
-	for i := len(db.tables) - 1; i > 0; i-- {
-		_, val, _, err := db.tables[i].Get(key)
-		if err == notFoundInTableErr {
-			continue // Continue searching in other tables
-		} else if err != nil {
-			return nil, err
-		} else if val == nil {
-			return nil, notFoundInDBErr
-		}
-
-		return val, nil
+	// An ingestion that was committed before a crash is finished before the tables are loaded
+	err = finishIngestion(fs, tablesDir)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, notFoundInDBErr
-}
-
-// Put : Inserts value into memtable (i.e. skip list)
-func (db *ClevelDB) Put(key, val []byte) error {
-	if db.journal {
-		_, err := writeKeyValPairToFile(db.journalFile, key, val, true)
-		if err != nil {
-			return err
-		}
+	db := newClevelDB(true, nil)
+	db.mergeOperator = opts.MergeOperator
+	db.comparator = opts.comparator()
+	db.memtable = newMemtable(db.comparator)
+	db.fs = fs
+	db.dir = opts.Dir
+	db.tablesDir = tablesDir
+	db.partitionedIndex = opts.PartitionedIndex
+	db.l0SlowdownTrigger = opts.l0SlowdownWritesTrigger()
+	db.l0StopTrigger = opts.l0StopWritesTrigger()
+	db.maxImmutableMemtables = opts.maxImmutableMemtables()
+
+	if capacity := opts.blockCacheCapacity(); capacity > 0 {
+		db.blockCache = newBlockCache(capacity)
 	}
+	db.tableCache = newTableCache(db.blockCache, opts)
 
-	mdb := db.memtable
-
-	// Track nodes who have a forward pointer that will need to be updated if a new node is inserted
-	update := make([]*SkipListNode, maxLevel)
-	current := mdb.header
-	searchKey := string(key)
-
-	for level := mdb.topLevel; level > 0; level-- {
-		for current.ptrs[level-1] != nil && string(current.ptrs[level-1].key) < searchKey {
-			current = current.ptrs[level-1]
-		}
-		// Prior to descending, store the rightmost node that was reached on the current level
-		update[level-1] = current
+	// The tables are loaded first, since they determine which journals have already been flushed
+	tables, err := db.loadSSTables(tablesDir)
+	if err != nil {
+		return nil, err
 	}
-
-	current = current.ptrs[0]
-
-	// If there is an existing node with the matching key, just update its value.
-	// Otherwise, insert new node below.
-	if current != nil && searchKey == string(current.val) {
-		current.val = val
-		mdb.size -= len(current.val) + len(val)
-
-		return db.checkAndHandleFlush()
+	if len(tables) > 0 {
+		db.tables = tables
+		db.nextFileNum = tables[0].fileNum + 1
 	}
 
-	// Delete new node (at random level)
-	newLevel := randomLevel()
-
-	// If the new level is higher than the current max level, we'll need to also
-	// update the header node at the new higher levels, so we Add the header's new
-	// levels to the update vector
-	if newLevel > mdb.topLevel {
-		for level := mdb.topLevel; level < newLevel; level++ {
-			update[level] = mdb.header
+	// A journal can't be replayed if it's corrupt (e.g. one of its records has an unknown op)
+	err = db.recoverJournals()
+	if err != nil {
+		db.waitForBackgroundWork()
+		for _, table := range db.tables {
+			table.unref()
 		}
-		mdb.topLevel = newLevel
+		return nil, err
 	}
 
-	// Create new node with empty forward pointers
-	newNode := &SkipListNode{key: key, val: val}
-
-	for i := 0; i < newLevel; i++ {
-		// Use update vector to fill new node's forward pointers
-		newNode.ptrs[i] = update[i].ptrs[i]
-		// Re-direct the update vector's pointers to point at the new node
-		update[i].ptrs[i] = newNode
-	}
+	return db, nil
+}
 
-	mdb.size += len(key) + len(val)
+func newClevelDB(journal bool, journalFile File) *ClevelDB {
+	newDB := &ClevelDB{comparator: BytewiseComparator{}}
+	newMemtable := newMemtable(newDB.comparator)
 
-	return db.checkAndHandleFlush()
+	newDB.memtable = newMemtable
+	newDB.journal = journal
+	newDB.journalFile = journalFile
+	newDB.fs = OSFS{}
+	newDB.tablesDir = ssTablesDir
+	newDB.nextFileNum = 1
+	newDB.tableCache = newTableCache(nil, &Options{})
+	newDB.bgCond = sync.NewCond(&newDB.mu)
+	newDB.l0SlowdownTrigger = defaultL0SlowdownWritesTrigger
+	newDB.l0StopTrigger = defaultL0StopWritesTrigger
+	newDB.maxImmutableMemtables = defaultMaxImmutableMemtables
+	return newDB
 }
 
-func (db *ClevelDB) checkAndHandleFlush() error {
-	if db.memtable.size <= maxMemtableSizeInBytes {
-		return nil
+// Close : Closes the journal and the tables' files, and releases the directory's LOCK file (so that the db can be
+// loaded again). The memtable isn't flushed, since it's recovered from its journal when the db is loaded (but any
+// background flush or compaction is finished first)
+// Note: snapshots and iterators keep the tables that they reference open until they're released
+func (db *ClevelDB) Close() error {
+	db.waitForBackgroundWork()
+
+	var err error
+	if db.journalFile != nil {
+		err = db.journalFile.Close()
+		db.journalFile = nil
+		db.journal = false
 	}
 
-	numTables := len(db.tables)
-	filename := fmt.Sprintf(ssTableFilename, numTables+1)
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		return err
+	for _, table := range db.tables {
+		table.unref()
 	}
+	db.tables = nil
 
-	// TODO: Need to block reads until flush is complete (or allow flushingMemtable to also be searched)
-	go func(db *ClevelDB) {
-		ssTable, err := flushMemtable(db, file)
-		if err != nil {
-			fmt.Printf("error flushing memtable: %v", err)
+	if db.lock != nil {
+		if unlockErr := db.lock.Close(); err == nil {
+			err = unlockErr
 		}
+		db.lock = nil
+	}
+	return err
+}
+
+// Get : searches memtable first, and if key isn't found, searches all SSTables
+// Merge operands are collected until the key's value (or tombstone) is found, and then folded into that value
+func (db *ClevelDB) Get(key []byte) ([]byte, error) {
+	defer db.metrics.getLatency.observeSince(time.Now())
 
-		// Prepends new table to slice, so tables are in descending order
-		db.tables = append([]*SSTable{ssTable}, db.tables...)
-	}(db)
+	memtables, tables := db.current()
+	defer unrefTables(tables)
 
-	return nil
+	return db.get(memtables, tables, key)
 }
 
-func randomLevel() int {
-	level := 1
-	for rand.Float32() < p && level < maxLevel {
-		level++
+// current : Returns the db's current memtables (i.e. the memtable, followed by the full memtables that are waiting to
+// be flushed, newest first) and tables (which a background flush or compaction may replace)
+// Each table is referenced while db.mu is held, so that compaction can't remove a table's file while it's being read
+// (the tables must be released with unrefTables once the read is done)
+func (db *ClevelDB) current() ([]*Memtable, []*SSTable) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	memtables := make([]*Memtable, 0, len(db.immutables)+1)
+	memtables = append(memtables, db.memtable)
+	for i := len(db.immutables) - 1; i >= 0; i-- {
+		memtables = append(memtables, db.immutables[i])
 	}
-	return level
-}
 
-// Delete : Marks key as deleted in memtable
-func (db *ClevelDB) Delete(key []byte) error {
-	// Replace key's value with "tombstone" (i.e. nil)
-	return db.Put(key, nil)
+	tables := append([]*SSTable(nil), db.tables...)
+	for _, table := range tables {
+		table.ref()
+	}
+	return memtables, tables
 }
 
-// Size - Returns the size in bytes
-func (db *ClevelDB) Size() int {
-	return db.memtable.size
+// unrefTables : Releases the references to the tables that were returned by current
+func unrefTables(tables []*SSTable) {
+	for _, table := range tables {
+		table.unref()
+	}
 }
 
-// RangeScan : Scans for values across memtable and all SStables
-func (db *ClevelDB) RangeScan(start, limit []byte) (Iterator, error) {
-	var activeIterators []Iterator
-
-	// Add memtable iterator
-	memtableIterator, err := db.memtable.RangeScan(start, limit)
-	if err != nil && err != notFoundInTableErr {
-		return nil, err
+// get : Searches the given memtables and tables (i.e. either the db's or a snapshot's)
+func (db *ClevelDB) get(memtables []*Memtable, tables []*SSTable, key []byte) ([]byte, error) {
+	l := &lookup{key: key}
+	for i := 0; i < len(memtables) && !l.done; i++ {
+		db.lookupInMemtable(memtables[i], l)
 	}
 
-	activeIterators = append(activeIterators, memtableIterator)
-
-	// Add sstable iterators
-	for _, table := range db.tables {
-		ssTableIterator, err := table.RangeScan(start, limit)
+	// Search most recently flushed tables first (i.e. tables are in descending order)
+	// Return as soon as the key's value (or tombstone) is found
+	for i := 0; i < len(tables) && !l.done; i++ {
+		op, val, err := tables[i].Get(key)
 		if err != nil && err != notFoundInTableErr {
 			return nil, err
+		} else if err == nil {
+			db.applyRecord(l, op, val)
 		}
 
-		activeIterators = append(activeIterators, ssTableIterator)
+		// A table's range tombstones only apply to keys in older tables
+		if !l.done && coveredByRangeTombstone(tables[i].cmp, tables[i].rangeTombstones, key) {
+			db.finishLookup(l, nil)
+		}
 	}
 
-	// After this call, all iterators point to either the 'start' key or the closest key greater than 'start'
-	var minKeyIterator Iterator
-
-	// Continue grabbing the min key until you find one that isn't a tombstone (i.e. non-nil value)
-	for {
-		activeIterators, minKeyIterator = getMinKeyIterator(activeIterators)
-		if minKeyIterator.Value() != nil {
-			break
-		}
+	if !l.done {
+		db.finishLookup(l, nil)
 	}
+	return l.val, l.err
+}
 
-	return &ClevelIterator{
-		iterators:      activeIterators,
-		minKeyIterator: minKeyIterator,
-		limit:          limit,
-	}, nil
+// Put : Inserts value into memtable (i.e. skip list)
+func (db *ClevelDB) Put(key, val []byte) error {
+	defer db.metrics.putLatency.observeSince(time.Now())
+	return db.put(key, val, 0)
 }
 
-func getMinKeyIterator(activeIterators []Iterator) ([]Iterator, Iterator) {
-	// Find iterator with the smallest key
-	// In the event of a tie, we want the first iterator (i.e. most recently flushed)
-	var minIterIndex int
-	minKey := activeIterators[0].Key()
-	for i, iterator := range activeIterators {
-		if string(iterator.Key()) < string(minKey) {
-			minIterIndex = i
-		}
-	}
+// PutWithTTL : Inserts value into memtable, which will be hidden from reads once the ttl has elapsed
+// and physically removed from the SSTables during compaction
+func (db *ClevelDB) PutWithTTL(key, val []byte, ttl time.Duration) error {
+	defer db.metrics.putLatency.observeSince(time.Now())
+	return db.put(key, val, timeNow().Add(ttl).UnixNano())
+}
 
-	// We also need to consume the key of the min iterator AND any other "older" iterators that contain the
-	// same outdated key (by calling `Next()`)
-	// As an optimization, we can also check if the return value is false" and remove the iterator from active iterators
-	// because "false" indicates there are no more keys in that iterator within our target range
-	for i, iterator := range activeIterators {
-		if i == minIterIndex {
-			continue
-		}
+func (db *ClevelDB) put(key, val []byte, expiresAt int64) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	err := db.makeRoomForWrite()
+	if err != nil {
+		return err
+	}
 
-		if string(iterator.Key()) == string(minKey) {
-			if !iterator.Next() {
-				activeIterators = remove(activeIterators, i)
-			}
+	if db.journal {
+		_, err := writeKeyValPairToFile(db.journalFile, key, val, expiresAt, true)
+		if err != nil {
+			return err
 		}
 	}
 
-	return activeIterators, activeIterators[minIterIndex]
+	db.memtable.Put(key, val, expiresAt)
+	return nil
 }
 
-type ClevelIterator struct {
-	iterators      []Iterator
-	minKeyIterator Iterator
-	limit          []byte
+// isExpired : Returns true if the expiry timestamp has passed (0 means the key never expires)
+func isExpired(expiresAt int64) bool {
+	return expiresAt != 0 && expiresAt <= timeNow().UnixNano()
 }
 
-func (i *ClevelIterator) Next() bool {
-	// Since iterators are removed when they return "false", our "combined" iterator returns "false" when none left
-	if len(i.iterators) == 0 || bytes.Compare(i.minKeyIterator.Key(), i.limit) >= 0 {
-		return false
+// newTableFile : Creates the file for the next SSTable (file numbers only ever increase, so that a
+// larger file number always means a more recent table)
+// The file has a temporary name until the table is complete (see installTableFile)
+func (db *ClevelDB) newTableFile() (File, error) {
+	filename := filepath.Join(db.tablesDir, fmt.Sprintf(tableTmpFilename, db.nextFileNum))
+	file, err := db.fs.Create(filename)
+	if err != nil {
+		return nil, err
 	}
 
-	i.minKeyIterator.Next()
-
-	// This retrieves the active iterator with the smallest key
-	activeIterators, minIterator := getMinKeyIterator(i.iterators)
+	db.nextFileNum++
+	return file, nil
+}
 
-	i.iterators = activeIterators
-	i.minKeyIterator = minIterator
+// initTable : Shares the db's caches with a newly written (or loaded) table, and hands the table's reader (whose
+// file is still open) over to the table cache
+func (db *ClevelDB) initTable(ss *SSTable, reader *tableReader) {
+	ss.blockCache = db.blockCache
+	ss.tableCache = db.tableCache
+	ss.cmp = db.comparator
+	ss.fs = db.fs
+	ss.metrics = &db.metrics
 
-	return true
+	db.tableCache.insert(reader).release()
 }
 
-func (i *ClevelIterator) Error() error {
-	return nil
+// BlockCacheStats : Returns the block cache's hit/miss statistics and usage (which are all 0 if it's disabled)
+func (db *ClevelDB) BlockCacheStats() BlockCacheStats {
+	if db.blockCache == nil {
+		return BlockCacheStats{}
+	}
+	return db.blockCache.Stats()
 }
 
-func (i *ClevelIterator) Key() []byte {
-	return i.minKeyIterator.Key()
+func randomLevel() int {
+	level := 1
+	for rand.Float32() < p && level < maxLevel {
+		level++
+	}
+	return level
 }
 
-func (i *ClevelIterator) Value() []byte {
-	return i.minKeyIterator.Value()
+// Delete : Marks key as deleted in memtable
+func (db *ClevelDB) Delete(key []byte) error {
+	defer db.metrics.deleteLatency.observeSince(time.Now())
+
+	// Replace key's value with "tombstone" (i.e. nil)
+	return db.put(key, nil, 0)
 }
 
-func remove(slice []Iterator, s int) []Iterator {
-	return append(slice[:s], slice[s+1:]...)
+// Size - Returns the size in bytes
+func (db *ClevelDB) Size() int {
+	db.mu.Lock()
+	memtable := db.memtable
+	db.mu.Unlock()
+	return memtable.memoryUsage()
 }
@@ -1,55 +1,65 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
-	"os"
+	"io"
+	"sync"
 	"testing"
+	"time"
 )
 
 func Test_ClevelDBGetReturnsCorrectValue(t *testing.T) {
 	testGetReturnsCorrectValue(t, newClevelDB(false, nil))
 }
 
-func Test_ClevelDBGetReturnsCorrectValueFromSSTable(t *testing.T) {
+// newTestClevelDB : Returns a db whose SSTables are written to memory (see MemFS)
+func newTestClevelDB(t *testing.T) *ClevelDB {
 	db := newClevelDB(false, nil)
+	db.fs = NewMemFS()
+	if err := db.fs.MkdirAll(db.tablesDir); err != nil {
+		t.Fatalf("error creating tables directory: %v", err)
+	}
+	return db
+}
 
-	_ = db.Put([]byte("firstName"), []byte("neha"))
-	_ = db.Put([]byte("lastName"), []byte("munoz"))
-	_ = db.Put([]byte("maidenName"), []byte("savant"))
-	_ = db.Put([]byte("middleName"), []byte("gajendra"))
+// flushTestMemtable : Flushes the db's memtable to a new SSTable (synchronously, unlike makeRoomForWrite)
+func flushTestMemtable(t *testing.T, db *ClevelDB) {
+	db.pauseBackgroundWork()
+	defer db.resumeBackgroundWork()
 
-	file, err := os.OpenFile("test/segment_1.ss", os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		fmt.Printf("error opening file: %v", err)
-		return
+	if err := flushMemtable(db); err != nil {
+		t.Fatalf("error flushing memtable: %v", err)
 	}
+}
 
-	ssTable, err := flushMemtable(db, file)
+// acquireTestReader : Returns the table's reader (which is released once the test finishes)
+func acquireTestReader(t *testing.T, table *SSTable) *tableReader {
+	reader, err := table.acquire()
 	if err != nil {
-		fmt.Printf("error flushing memtable: %v", err)
+		t.Fatalf("error opening table: %v", err)
 	}
 
-	db.tables = append(db.tables, ssTable)
+	t.Cleanup(reader.release)
+	return reader
+}
+
+func Test_ClevelDBGetReturnsCorrectValueFromSSTable(t *testing.T) {
+	db := newTestClevelDB(t)
+
+	_ = db.Put([]byte("firstName"), []byte("neha"))
+	_ = db.Put([]byte("lastName"), []byte("munoz"))
+	_ = db.Put([]byte("maidenName"), []byte("savant"))
+	_ = db.Put([]byte("middleName"), []byte("gajendra"))
 
-	db.memtable = newMemtable()
+	flushTestMemtable(t, db)
 
 	_ = db.Put([]byte("firstName"), []byte("nitin"))
 	_ = db.Put([]byte("lastName"), []byte("savant"))
 	_ = db.Put([]byte("maidenName"), []byte(""))
 	_ = db.Delete([]byte("middleName"))
 
-	file, err = os.OpenFile("test/segment_2.ss", os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		fmt.Printf("error opening file: %v", err)
-		return
-	}
-
-	ssTable, err = flushMemtable(db, file)
-	if err != nil {
-		fmt.Printf("error flushing memtable: %v", err)
-	}
-
-	db.tables = append(db.tables, ssTable)
+	flushTestMemtable(t, db)
 
 	var tests = []struct {
 		key   string
@@ -76,6 +86,512 @@ func Test_ClevelDBGetReturnsCorrectValueFromSSTable(t *testing.T) {
 	}
 }
 
+// setTestTime : Replaces the clock used for TTL checks (and restores it once the test is complete)
+func setTestTime(t *testing.T, now time.Time) {
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+}
+
+func Test_ClevelDBGetHidesExpiredKeys(t *testing.T) {
+	db := newTestClevelDB(t)
+	now := time.Now()
+	setTestTime(t, now)
+
+	_ = db.Put([]byte("firstName"), []byte("neha"))
+	_ = db.Put([]byte("lastName"), []byte("munoz"))
+	flushTestMemtable(t, db)
+
+	_ = db.PutWithTTL([]byte("firstName"), []byte("nitin"), time.Minute)
+	_ = db.PutWithTTL([]byte("session"), []byte("abc"), time.Hour)
+	flushTestMemtable(t, db)
+
+	_ = db.PutWithTTL([]byte("lastName"), []byte("savant"), time.Minute)
+
+	var tests = []struct {
+		key    string
+		value  string
+		err    error
+		offset time.Duration
+	}{
+		{"firstName", "nitin", nil, 0},
+		{"lastName", "savant", nil, 0},
+		{"session", "abc", nil, 0},
+		{"firstName", "", notFoundInDBErr, time.Minute},
+		{"lastName", "", notFoundInDBErr, time.Minute},
+		{"session", "abc", nil, time.Minute},
+		{"session", "", notFoundInDBErr, time.Hour},
+	}
+
+	for _, test := range tests {
+		setTestTime(t, now.Add(test.offset))
+
+		actualValue, actualErr := db.Get([]byte(test.key))
+		if string(actualValue) != test.value {
+			t.Errorf(`storage.Get("%s") returns unexpected value after %v: "%s"`, test.key, test.offset, actualValue)
+		}
+
+		if test.err != actualErr {
+			t.Errorf(`storage.Get("%s") returns unexpected err after %v: "%s"`, test.key, test.offset, actualErr)
+		}
+	}
+}
+
+func Test_ClevelDBRangeScanSkipsExpiredKeys(t *testing.T) {
+	db := newTestClevelDB(t)
+	now := time.Now()
+	setTestTime(t, now)
+
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	_ = db.PutWithTTL([]byte("b"), []byte("nitin"), time.Minute)
+	_ = db.Put([]byte("c"), []byte("neha"))
+
+	setTestTime(t, now.Add(time.Minute))
+
+	iter, _ := db.RangeScan([]byte("a"), []byte("d"))
+
+	var actualKeys []string
+	for {
+		actualKeys = append(actualKeys, string(iter.Key()))
+		if !iter.Next() {
+			break
+		}
+	}
+
+	if len(actualKeys) != 2 || actualKeys[0] != "a" || actualKeys[1] != "c" {
+		t.Errorf(`storage.RangeScan returns unexpected keys: %v`, actualKeys)
+	}
+}
+
+func Test_ClevelDBCompactDropsExpiredAndDeletedKeys(t *testing.T) {
+	db := newTestClevelDB(t)
+	now := time.Now()
+	setTestTime(t, now)
+
+	_ = db.Put([]byte("firstName"), []byte("neha"))
+	_ = db.Put([]byte("lastName"), []byte("munoz"))
+	_ = db.Put([]byte("middleName"), []byte("gajendra"))
+	flushTestMemtable(t, db)
+
+	_ = db.PutWithTTL([]byte("firstName"), []byte("nitin"), time.Minute)
+	_ = db.PutWithTTL([]byte("lastName"), []byte("savant"), time.Hour)
+	_ = db.Delete([]byte("middleName"))
+	flushTestMemtable(t, db)
+
+	setTestTime(t, now.Add(time.Minute))
+
+	err := db.Compact()
+	if err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	if len(db.tables) != 1 {
+		t.Fatalf("expected 1 table after compaction, found %d", len(db.tables))
+	}
+
+	var actualKeys []string
+	var actualExpiry int64
+	_ = db.tables[0].scan(func(op uint8, key, val []byte, expiresAt int64) {
+		actualKeys = append(actualKeys, string(key))
+		actualExpiry = expiresAt
+	})
+
+	if len(actualKeys) != 1 || actualKeys[0] != "lastName" {
+		t.Errorf("compacted table contains unexpected keys: %v", actualKeys)
+	}
+
+	// The remaining key's TTL must survive compaction
+	if actualExpiry != now.Add(time.Hour).UnixNano() {
+		t.Errorf("compacted table contains unexpected expiry: %v", actualExpiry)
+	}
+
+	val, err := db.Get([]byte("lastName"))
+	if string(val) != "savant" || err != nil {
+		t.Errorf(`storage.Get("lastName") returns unexpected value after compaction: "%s" (%v)`, val, err)
+	}
+}
+
+func Test_ClevelDBDeleteRangeHidesKeysInAllTables(t *testing.T) {
+	db := newTestClevelDB(t)
+
+	_ = db.Put([]byte("tenant1/a"), []byte("1"))
+	_ = db.Put([]byte("tenant1/b"), []byte("2"))
+	_ = db.Put([]byte("tenant2/a"), []byte("3"))
+	flushTestMemtable(t, db)
+
+	_ = db.Put([]byte("tenant1/c"), []byte("4"))
+	_ = db.Put([]byte("tenant0/a"), []byte("5"))
+	_ = db.DeleteRange([]byte("tenant1/"), []byte("tenant2/"))
+
+	// Keys written after the range tombstone aren't deleted
+	_ = db.Put([]byte("tenant1/b"), []byte("6"))
+
+	var tests = []struct {
+		key   string
+		value string
+		err   error
+	}{
+		{"tenant0/a", "5", nil},
+		{"tenant1/a", "", notFoundInDBErr},
+		{"tenant1/b", "6", nil},
+		{"tenant1/c", "", notFoundInDBErr},
+		{"tenant2/a", "3", nil},
+	}
+
+	check := func(stage string) {
+		for _, test := range tests {
+			actualValue, actualErr := db.Get([]byte(test.key))
+			if string(actualValue) != test.value || actualErr != test.err {
+				t.Errorf(`storage.Get("%s") returns unexpected value %s: "%s" (%v)`, test.key, stage, actualValue, actualErr)
+			}
+		}
+	}
+
+	check("from memtable")
+
+	flushTestMemtable(t, db)
+	check("from sstables")
+
+	err := db.Compact()
+	if err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+	check("after compaction")
+
+	var actualKeys []string
+	_ = db.tables[0].scan(func(op uint8, key, val []byte, expiresAt int64) {
+		actualKeys = append(actualKeys, string(key))
+	})
+
+	if len(actualKeys) != 3 || len(db.tables[0].rangeTombstones) != 0 {
+		t.Errorf("compacted table contains unexpected keys: %v (and %d range tombstones)", actualKeys, len(db.tables[0].rangeTombstones))
+	}
+}
+
+func Test_ClevelDBSnapshotIsUnaffectedByLaterWrites(t *testing.T) {
+	db := newTestClevelDB(t)
+
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	_ = db.Put([]byte("b"), []byte("nitin"))
+	flushTestMemtable(t, db)
+	_ = db.Put([]byte("c"), []byte("neha"))
+
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+
+	_ = db.Put([]byte("a"), []byte("david"))
+	_ = db.Delete([]byte("b"))
+	_ = db.Put([]byte("c"), []byte("karli"))
+	_ = db.Put([]byte("d"), []byte("karli"))
+	flushTestMemtable(t, db)
+
+	// Compaction removes the tables that the snapshot is reading from
+	if err := db.Compact(); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	tests := []struct {
+		key, val string
+		err      error
+	}{
+		{"a", "cassie", nil},
+		{"b", "nitin", nil},
+		{"c", "neha", nil},
+		{"d", "", notFoundInDBErr},
+	}
+
+	for _, test := range tests {
+		actualVal, actualErr := snapshot.Get([]byte(test.key))
+		if string(actualVal) != test.val || actualErr != test.err {
+			t.Errorf(`snapshot.Get("%s") returns unexpected value: "%s" (%v)`, test.key, actualVal, actualErr)
+		}
+	}
+
+	iter := db.NewIterator(&ReadOptions{Snapshot: snapshot})
+	defer iter.Release()
+	checkKeys(t, "NewIterator (with snapshot)", collectKeys(iter, iter.Next), []string{"a=cassie", "b=nitin", "c=neha"})
+
+	iter = db.NewIterator(nil)
+	defer iter.Release()
+	checkKeys(t, "NewIterator", collectKeys(iter, iter.Next), []string{"a=david", "c=karli", "d=karli"})
+}
+
+func Test_ClevelDBKeepsTablesUntilReadsFinish(t *testing.T) {
+	db := newTestClevelDB(t)
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	flushTestMemtable(t, db)
+	_ = db.Put([]byte("b"), []byte("nitin"))
+	flushTestMemtable(t, db)
+
+	// A read that started before the compaction keeps reading from the tables that it replaced
+	_, tables := db.current()
+	if err := db.Compact(); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	for _, table := range tables {
+		if _, err := db.fs.Stat(table.path); err != nil {
+			t.Errorf("Compact removes table that's still being read: %v", err)
+		}
+	}
+	if val, err := db.get([]*Memtable{db.memtable}, tables, []byte("a")); string(val) != "cassie" || err != nil {
+		t.Errorf(`storage.get("a") returns unexpected value from replaced tables: "%s" (%v)`, val, err)
+	}
+
+	unrefTables(tables)
+	for _, table := range tables {
+		if _, err := db.fs.Stat(table.path); err == nil {
+			t.Errorf("Compact doesn't remove replaced table once the read is done: %s", table.path)
+		}
+	}
+}
+
+func Test_ClevelDBRangeDelIteratorHidesCoveredKeys(t *testing.T) {
+	db := newTestClevelDB(t)
+
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	_ = db.Put([]byte("b"), []byte("nitin"))
+	_ = db.Put([]byte("c"), []byte("neha"))
+	flushTestMemtable(t, db)
+
+	_ = db.DeleteRange([]byte("b"), []byte("c"))
+
+	ssTableIterator, _ := db.tables[0].RangeScan([]byte("a"), []byte("d"))
+	iter := &rangeDelIterator{Iterator: ssTableIterator, tombstones: db.memtable.rangeTombstones, cmp: db.comparator}
+
+	expectedKeys := []string{"a", "b", "c"}
+	expectedVals := []string{"cassie", "", "neha"}
+
+	for i := 0; i < len(expectedKeys); i++ {
+		actualKey := string(iter.Key())
+		actualVal := string(iter.Value())
+
+		if expectedKeys[i] != actualKey || expectedVals[i] != actualVal {
+			t.Errorf(`rangeDelIterator returns unexpected key/value: "%s: %s" at index: %v`, actualKey, actualVal, i)
+		}
+		iter.Next()
+	}
+}
+
+func Test_ClevelDBRecoversRangeTombstoneFromJournal(t *testing.T) {
+	journalFile, err := NewMemFS().OpenAppend(fmt.Sprintf(journalFilename, 1))
+	if err != nil {
+		t.Fatalf("error opening journal: %v", err)
+	}
+
+	db := newClevelDB(true, journalFile)
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	_ = db.Put([]byte("b"), []byte("nitin"))
+	_ = db.DeleteRange([]byte("a"), []byte("b"))
+
+	_, _ = journalFile.Seek(0, io.SeekStart)
+	recovered := newClevelDB(false, nil)
+	if err := recovered.replayJournal(journalFile); err != nil {
+		t.Fatalf("replayJournal returns unexpected error: %v", err)
+	}
+
+	if val, err := recovered.Get([]byte("a")); err != notFoundInDBErr {
+		t.Errorf(`storage.Get("a") returns unexpected value after recovery: "%s" (%v)`, val, err)
+	}
+
+	if val, err := recovered.Get([]byte("b")); string(val) != "nitin" || err != nil {
+		t.Errorf(`storage.Get("b") returns unexpected value after recovery: "%s" (%v)`, val, err)
+	}
+
+	if len(recovered.memtable.rangeTombstones) != 1 {
+		t.Errorf("expected 1 recovered range tombstone, found %d", len(recovered.memtable.rangeTombstones))
+	}
+}
+
+func uint64Bytes(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func Test_ClevelDBMergeFoldsOperandsAcrossTables(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.mergeOperator = UInt64AddOperator{}
+
+	_ = db.Put([]byte("counter"), uint64Bytes(10))
+	_ = db.Merge([]byte("other"), uint64Bytes(3))
+	flushTestMemtable(t, db)
+
+	_ = db.Merge([]byte("counter"), uint64Bytes(5))
+	_ = db.Merge([]byte("other"), uint64Bytes(4))
+	flushTestMemtable(t, db)
+
+	_ = db.Merge([]byte("counter"), uint64Bytes(1))
+	_ = db.Merge([]byte("counter"), uint64Bytes(2))
+
+	var tests = []struct {
+		key   string
+		value uint64
+	}{
+		{"counter", 18},
+		{"other", 7},
+	}
+
+	check := func(stage string) {
+		for _, test := range tests {
+			actualValue, actualErr := db.Get([]byte(test.key))
+			if actualErr != nil || len(actualValue) != 8 || binary.BigEndian.Uint64(actualValue) != test.value {
+				t.Errorf(`storage.Get("%s") returns unexpected value %s: %v (%v)`, test.key, stage, actualValue, actualErr)
+			}
+		}
+	}
+
+	check("before compaction")
+
+	flushTestMemtable(t, db)
+	err := db.Compact()
+	if err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+	check("after compaction")
+
+	// Compaction must fold every operand into a plain value
+	_ = db.tables[0].scan(func(op uint8, key, val []byte, expiresAt int64) {
+		if op == Merge {
+			t.Errorf(`compacted table contains merge operands for "%s"`, key)
+		}
+	})
+}
+
+func Test_ClevelDBMergeStopsAtTombstones(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.mergeOperator = StringAppendOperator{Delimiter: []byte(",")}
+
+	_ = db.Put([]byte("deleted"), []byte("a"))
+	_ = db.Put([]byte("rangeDeleted"), []byte("a"))
+	flushTestMemtable(t, db)
+
+	_ = db.Delete([]byte("deleted"))
+	_ = db.DeleteRange([]byte("rangeDeleted"), []byte("rangeDeleted0"))
+	flushTestMemtable(t, db)
+
+	_ = db.Merge([]byte("deleted"), []byte("b"))
+	_ = db.Merge([]byte("rangeDeleted"), []byte("b"))
+	_ = db.Merge([]byte("rangeDeleted"), []byte("c"))
+
+	var tests = []struct {
+		key   string
+		value string
+	}{
+		{"deleted", "b"},
+		{"rangeDeleted", "b,c"},
+	}
+
+	for _, test := range tests {
+		actualValue, actualErr := db.Get([]byte(test.key))
+		if string(actualValue) != test.value || actualErr != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value: "%s" (%v)`, test.key, actualValue, actualErr)
+		}
+	}
+}
+
+func Test_ClevelDBMergeWithoutOperatorReturnsError(t *testing.T) {
+	db := newClevelDB(false, nil)
+
+	err := db.Merge([]byte("counter"), uint64Bytes(1))
+	if err != noMergeOperatorErr {
+		t.Errorf("storage.Merge returns unexpected err: %v", err)
+	}
+}
+
+func Test_ClevelDBRangeScanFoldsMergeOperands(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.mergeOperator = StringAppendOperator{Delimiter: []byte(",")}
+
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	_ = db.Merge([]byte("b"), []byte("nitin"))
+	_ = db.Merge([]byte("b"), []byte("neha"))
+	_ = db.Put([]byte("c"), []byte("david"))
+
+	iter, _ := db.RangeScan([]byte("a"), []byte("d"))
+
+	expectedKeys := []string{"a", "b", "c"}
+	expectedVals := []string{"cassie", "nitin,neha", "david"}
+
+	for i := 0; i < len(expectedKeys); i++ {
+		actualKey := string(iter.Key())
+		actualVal := string(iter.Value())
+
+		if expectedKeys[i] != actualKey || expectedVals[i] != actualVal {
+			t.Errorf(`storage.RangeScan returns unexpected key/value: "%s: %s" at index: %v`, actualKey, actualVal, i)
+		}
+		iter.Next()
+	}
+}
+
+func Test_ClevelDBRecoversMergeOperandsFromJournal(t *testing.T) {
+	journalFile, err := NewMemFS().OpenAppend(fmt.Sprintf(journalFilename, 1))
+	if err != nil {
+		t.Fatalf("error opening journal: %v", err)
+	}
+
+	db := newClevelDB(true, journalFile)
+	db.mergeOperator = UInt64AddOperator{}
+	_ = db.Merge([]byte("counter"), uint64Bytes(1))
+	_ = db.Merge([]byte("counter"), uint64Bytes(2))
+
+	_, _ = journalFile.Seek(0, io.SeekStart)
+	recovered := newClevelDB(false, nil)
+	recovered.mergeOperator = UInt64AddOperator{}
+	if err := recovered.replayJournal(journalFile); err != nil {
+		t.Fatalf("replayJournal returns unexpected error: %v", err)
+	}
+
+	val, err := recovered.Get([]byte("counter"))
+	if err != nil || binary.BigEndian.Uint64(val) != 3 {
+		t.Errorf(`storage.Get("counter") returns unexpected value after recovery: %v (%v)`, val, err)
+	}
+}
+
+func Test_ClevelDBDoesntReplayJournalWithUnknownOp(t *testing.T) {
+	journalFile, err := NewMemFS().OpenAppend(fmt.Sprintf(journalFilename, 1))
+	if err != nil {
+		t.Fatalf("error opening journal: %v", err)
+	}
+
+	db := newClevelDB(true, journalFile)
+	_ = db.Put([]byte("firstName"), []byte("neha"))
+	_, _ = writeRecordToFile(journalFile, Merge+1, []byte("lastName"), []byte("munoz"), 0, true)
+	_ = db.Put([]byte("middleName"), []byte("gajendra"))
+
+	_, _ = journalFile.Seek(0, io.SeekStart)
+	recovered := newClevelDB(false, nil)
+	if err := recovered.replayJournal(journalFile); err != corruptJournalErr {
+		t.Errorf("replayJournal returns unexpected error: %v", err)
+	}
+}
+
+func Test_MergeOperatorsCombineOperands(t *testing.T) {
+	val, err := UInt64AddOperator{}.FullMerge(nil, uint64Bytes(1), [][]byte{uint64Bytes(2), uint64Bytes(3)})
+	if err != nil || binary.BigEndian.Uint64(val) != 6 {
+		t.Errorf("UInt64AddOperator.FullMerge returns unexpected value: %v (%v)", val, err)
+	}
+
+	_, err = UInt64AddOperator{}.FullMerge(nil, nil, [][]byte{[]byte("not a uint64")})
+	if err != invalidMergeOperandErr {
+		t.Errorf("UInt64AddOperator.FullMerge returns unexpected err: %v", err)
+	}
+
+	operand, ok := UInt64AddOperator{}.PartialMerge(nil, uint64Bytes(2), uint64Bytes(3))
+	if !ok || binary.BigEndian.Uint64(operand) != 5 {
+		t.Errorf("UInt64AddOperator.PartialMerge returns unexpected value: %v", operand)
+	}
+
+	appendOperator := StringAppendOperator{Delimiter: []byte(",")}
+
+	val, _ = appendOperator.FullMerge(nil, nil, [][]byte{[]byte("a"), []byte("b")})
+	if string(val) != "a,b" {
+		t.Errorf(`StringAppendOperator.FullMerge returns unexpected value: "%s"`, val)
+	}
+
+	val, _ = appendOperator.FullMerge(nil, []byte("a"), [][]byte{[]byte("b")})
+	if string(val) != "a,b" {
+		t.Errorf(`StringAppendOperator.FullMerge returns unexpected value: "%s"`, val)
+	}
+}
+
 func Test_ClevelDBDeleteRemovesValue(t *testing.T) {
 	testDeleteSetsValueToNil(t, newClevelDB(false, nil))
 }
@@ -84,6 +600,10 @@ func Test_ClevelDBRangeScanAndNextReturnCorrectOrderedValues(t *testing.T) {
 	testRangeScanAndNextReturnCorrectOrderedValues(t, newClevelDB(false, nil))
 }
 
+func Test_ClevelDBRangeScanAndPrevReturnCorrectReverseOrderedValues(t *testing.T) {
+	testRangeScanAndPrevReturnCorrectReverseOrderedValues(t, newClevelDB(false, nil))
+}
+
 func Benchmark_ClevelDBFillSeq(b *testing.B) {
 	benchmarkFillSeq(b, newClevelDB(false, nil))
 }
@@ -119,3 +639,124 @@ func Benchmark_ClevelDBLogDeleteSeq(b *testing.B) {
 func Benchmark_ClevelDBLogReadSeq(b *testing.B) {
 	benchmarkReadSeq(b, newClevelDB(true, nil))
 }
+
+func Test_ClevelDBServesReadersWhileWriting(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.mergeOperator = StringAppendOperator{Delimiter: []byte(",")}
+
+	var writers, readers sync.WaitGroup
+	errs := make(chan error, 16)
+	done := make(chan struct{})
+
+	// Writers change the memtable's nodes in place (and insert new ones), while readers search the memtable
+	for writer := 0; writer < 2; writer++ {
+		writers.Add(1)
+		go func(writer int) {
+			defer writers.Done()
+			for i := 0; i < 500; i++ {
+				key := []byte(fmt.Sprintf("key%03d", (i*7+writer)%100))
+				switch i % 4 {
+				case 0, 1:
+					_ = db.Put(key, []byte(fmt.Sprintf("value%d", i)))
+				case 2:
+					_ = db.Merge(key, []byte("operand"))
+				case 3:
+					_ = db.DeleteRange(key, append(key, 0))
+				}
+			}
+		}(writer)
+	}
+
+	for reader := 0; reader < 4; reader++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if _, err := db.Get([]byte("key050")); err != nil && err != notFoundInDBErr {
+					errs <- fmt.Errorf(`storage.Get("key050") returns unexpected error: %v`, err)
+					return
+				}
+				if _, multiGetErrs := db.MultiGet([][]byte{[]byte("key010"), []byte("key090")}); multiGetErrs[0] != nil &&
+					multiGetErrs[0] != notFoundInDBErr {
+					errs <- fmt.Errorf("MultiGet returns unexpected error: %v", multiGetErrs[0])
+					return
+				}
+
+				iter := db.NewIterator(nil)
+				for ok := iter.Valid(); ok; ok = iter.Next() {
+					_ = iter.Value()
+				}
+				iter.Release()
+
+				_ = db.GetApproximateSizes([]Range{{}})
+				_, _ = db.GetProperty("cleveldb.estimate-num-keys")
+				_, _ = db.GetProperty("cleveldb.approximate-memory-usage")
+				_ = db.Size()
+			}
+		}()
+	}
+
+	// The readers stop once the writers are done
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func Test_ClevelDBServesConcurrentReaders(t *testing.T) {
+	db := newTestClevelDB(t)
+	for i := 0; i < 100; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+	}
+	flushTestMemtable(t, db)
+
+	// Every reader shares the table's file (without any caching), so interleaved reads must not affect each other
+	db.tables[0].blockCache = nil
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+
+	for reader := 0; reader < 8; reader++ {
+		wg.Add(2)
+
+		go func(reader int) {
+			defer wg.Done()
+			for i := reader; i < 100; i += 8 {
+				key := []byte(fmt.Sprintf("key%03d", i))
+				if val, err := db.Get(key); string(val) != fmt.Sprint(i) || err != nil {
+					errs <- fmt.Errorf(`storage.Get("%s") returns unexpected value: "%s" (%v)`, key, val, err)
+					return
+				}
+			}
+		}(reader)
+
+		go func() {
+			defer wg.Done()
+			iter := db.NewIterator(nil)
+			defer iter.Release()
+
+			for i := 0; i < 100; i++ {
+				if string(iter.Key()) != fmt.Sprintf("key%03d", i) {
+					errs <- fmt.Errorf(`iterator returns unexpected key: "%s" at index: %v`, iter.Key(), i)
+					return
+				}
+				iter.Next()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
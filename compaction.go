@@ -0,0 +1,142 @@
+package main
+
+import "time"
+
+// Compact : Merges every SSTable into a single new table
+// Overwritten keys are reduced to their most recent value (with any merge operands folded in), while deleted and
+// expired keys (and keys covered by range tombstones) are dropped entirely, which is safe because there are no older
+// tables left for a tombstone to shadow
+// Compact waits for any background flush or compaction to finish first
+func (db *ClevelDB) Compact() error {
+	db.pauseBackgroundWork()
+	defer db.resumeBackgroundWork()
+
+	return db.compact()
+}
+
+// compact : Same as Compact, except the background work must already be paused (or compact must be running as the
+// background work)
+func (db *ClevelDB) compact() error {
+	db.mu.Lock()
+	tables := db.tables
+	db.mu.Unlock()
+	if len(tables) == 0 {
+		return nil
+	}
+	start := time.Now()
+
+	// Replay every table (starting with the oldest) into a memtable, so that more recent
+	// values and tombstones overwrite the older ones
+	merged := newMemtable(db.comparator)
+	for i := len(tables) - 1; i >= 0; i-- {
+		// A table's range tombstones are older than its own keys (but more recent than any older table's keys)
+		for _, tombstone := range tables[i].rangeTombstones {
+			merged.DeleteRange(tombstone.start, tombstone.limit)
+		}
+
+		var mergeErr error
+		err := tables[i].scan(func(op uint8, key, val []byte, expiresAt int64) {
+			if op != Merge {
+				merged.Put(key, val, expiresAt)
+				return
+			}
+
+			operands, err := decodeOperands(val)
+			if err != nil {
+				mergeErr = err
+				return
+			}
+
+			for _, operand := range operands {
+				if db.mergeOperator == nil {
+					mergeErr = noMergeOperatorErr
+					return
+				}
+
+				err = merged.Merge(key, operand, db.mergeOperator)
+				if err != nil {
+					mergeErr = err
+					return
+				}
+			}
+		})
+		if err != nil {
+			return err
+		} else if mergeErr != nil {
+			return mergeErr
+		}
+	}
+
+	// Only the keys that are still "live" need to be written to the compacted table (range tombstones are dropped too)
+	// The compacted table replaces every table, so it also records that their journals have been flushed
+	live := newMemtable(db.comparator)
+	for _, table := range tables {
+		if table.journalNum > live.journalNum {
+			live.journalNum = table.journalNum
+		}
+	}
+	for current := merged.header.ptrs[0]; current != nil; current = current.ptrs[0] {
+		// Since the oldest table has been reached, any remaining operands are merged as if the key doesn't exist
+		if current.operands != nil {
+			val, err := db.fullMerge(current.key, nil, current.operands)
+			if err != nil {
+				return err
+			}
+			live.Put(current.key, val, 0)
+			continue
+		}
+
+		if current.val == nil || isExpired(current.expiresAt) {
+			continue
+		}
+		live.Put(current.key, current.val, current.expiresAt)
+	}
+
+	var compacted []*SSTable
+	if live.header.ptrs[0] != nil {
+		db.mu.Lock()
+		file, err := db.newTableFile()
+		db.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		ssTable, reader, err := writeSSTable(file, live, db.partitionedIndex)
+		if err != nil {
+			return err
+		}
+		err = db.installTableFile(ssTable, reader)
+		if err != nil {
+			return err
+		}
+		db.initTable(ssTable, reader)
+		compacted = append(compacted, ssTable)
+	}
+
+	// Any tables flushed while we were compacting were prepended to the slice, so they need to be kept
+	db.mu.Lock()
+	numFlushed := len(db.tables) - len(tables)
+	db.tables = append(db.tables[:numFlushed:numFlushed], compacted...)
+
+	db.compactionStats.compactions++
+	db.compactionStats.duration += time.Since(start)
+	db.metrics.compactionDuration.observeSince(start)
+	for _, table := range tables {
+		db.compactionStats.bytesRead += table.size
+	}
+	for _, table := range compacted {
+		db.compactionStats.bytesWritten += table.size
+	}
+	db.mu.Unlock()
+
+	// Snapshots and iterators may still be reading the old tables, so their files are only removed once every
+	// reference is released
+	// The oldest table is removed first: if the db crashes before every old table is removed, the remaining ones are
+	// the most recent, so their tombstones still hide any older values that compaction dropped
+	for i := len(tables) - 1; i >= 0; i-- {
+		tables[i].obsolete = true
+		tables[i].unref()
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import "bytes"
+
+// Comparator : Defines the order of keys in the memtable, the SSTables and every iterator
+// A db must always be reopened with the same comparator (its name is stored in the MANIFEST)
+type Comparator interface {
+	// Compare : Returns a negative number if a < b, 0 if a == b, and a positive number if a > b
+	Compare(a, b []byte) int
+
+	// Name : Identifies the ordering (a comparator's name should change whenever its ordering changes)
+	Name() string
+
+	// FindShortestSeparator : Returns a (preferably short) key k such that start <= k < limit, assuming start < limit
+	// Returning start itself is always correct
+	FindShortestSeparator(start, limit []byte) []byte
+
+	// FindShortSuccessor : Returns a (preferably short) key k such that k >= key
+	// Returning key itself is always correct
+	FindShortSuccessor(key []byte) []byte
+}
+
+// BytewiseComparator : Orders keys lexicographically by their bytes (the default comparator)
+type BytewiseComparator struct{}
+
+func (BytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (BytewiseComparator) Name() string {
+	return "cleveldb.BytewiseComparator"
+}
+
+// FindShortestSeparator : Increments the first byte where start and limit differ (and drops the rest of start),
+// as long as the result is still smaller than limit
+func (BytewiseComparator) FindShortestSeparator(start, limit []byte) []byte {
+	minLen := len(start)
+	if len(limit) < minLen {
+		minLen = len(limit)
+	}
+
+	diff := 0
+	for diff < minLen && start[diff] == limit[diff] {
+		diff++
+	}
+
+	// One key is a prefix of the other, so there's nothing shorter in between
+	if diff == minLen {
+		return start
+	}
+
+	if start[diff] < 0xff && start[diff]+1 < limit[diff] {
+		separator := append([]byte{}, start[:diff+1]...)
+		separator[diff]++
+		return separator
+	}
+	return start
+}
+
+// FindShortSuccessor : Increments the first byte that can be incremented (and drops the rest of the key)
+func (BytewiseComparator) FindShortSuccessor(key []byte) []byte {
+	for i, b := range key {
+		if b != 0xff {
+			successor := append([]byte{}, key[:i+1]...)
+			successor[i]++
+			return successor
+		}
+	}
+
+	// Every byte is 0xff
+	return key
+}
+
+// ReverseBytewiseComparator : Orders keys in the reverse of BytewiseComparator's order
+type ReverseBytewiseComparator struct{}
+
+func (ReverseBytewiseComparator) Compare(a, b []byte) int {
+	return -bytes.Compare(a, b)
+}
+
+func (ReverseBytewiseComparator) Name() string {
+	return "cleveldb.ReverseBytewiseComparator"
+}
+
+func (ReverseBytewiseComparator) FindShortestSeparator(start, limit []byte) []byte {
+	return start
+}
+
+func (ReverseBytewiseComparator) FindShortSuccessor(key []byte) []byte {
+	return key
+}
+
+// BigEndianIntegerComparator : Orders keys as unsigned big-endian integers of any length (e.g. 0x0100 sorts after
+// 0x02, unlike with BytewiseComparator), which suits keys that are encoded integers of varying widths
+// Keys that encode the same integer (i.e. that only differ by their leading zero bytes) are ordered by length, so
+// that distinct keys never compare as equal
+type BigEndianIntegerComparator struct{}
+
+func (BigEndianIntegerComparator) Compare(a, b []byte) int {
+	trimmedA := bytes.TrimLeft(a, "\x00")
+	trimmedB := bytes.TrimLeft(b, "\x00")
+
+	// Without leading zeros, a longer key is always a larger integer
+	if len(trimmedA) != len(trimmedB) {
+		return len(trimmedA) - len(trimmedB)
+	}
+
+	if cmp := bytes.Compare(trimmedA, trimmedB); cmp != 0 {
+		return cmp
+	}
+	return len(a) - len(b)
+}
+
+func (BigEndianIntegerComparator) Name() string {
+	return "cleveldb.BigEndianIntegerComparator"
+}
+
+func (BigEndianIntegerComparator) FindShortestSeparator(start, limit []byte) []byte {
+	return start
+}
+
+func (BigEndianIntegerComparator) FindShortSuccessor(key []byte) []byte {
+	return key
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// newTestClevelDBWithComparator : Same as newTestClevelDB, except keys are ordered by the given comparator
+func newTestClevelDBWithComparator(t *testing.T, cmp Comparator) *ClevelDB {
+	db := newTestClevelDB(t)
+	db.comparator = cmp
+	db.memtable = newMemtable(cmp)
+	return db
+}
+
+func Test_ClevelDBOrdersKeysWithReverseComparator(t *testing.T) {
+	db := newTestClevelDBWithComparator(t, ReverseBytewiseComparator{})
+
+	for i := 0; i < 60; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+		if i%20 == 19 {
+			flushTestMemtable(t, db)
+		}
+	}
+	_ = db.Put([]byte("key030"), []byte("updated"))
+
+	// In reverse order, the range starts at the larger key
+	_ = db.DeleteRange([]byte("key045"), []byte("key040"))
+
+	iter := db.NewIterator(&ReadOptions{LowerBound: []byte("key050"), UpperBound: []byte("key025")})
+	checkKeys(t, "NewIterator (reverse comparator)", collectKeys(iter, iter.Next), []string{
+		"key050=50", "key049=49", "key048=48", "key047=47", "key046=46", "key040=40", "key039=39", "key038=38",
+		"key037=37", "key036=36", "key035=35", "key034=34", "key033=33", "key032=32", "key031=31", "key030=updated",
+		"key029=29", "key028=28", "key027=27", "key026=26",
+	})
+	iter.Release()
+
+	for _, key := range []string{"key000", "key019", "key059"} {
+		if _, err := db.Get([]byte(key)); err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected error: %v`, key, err)
+		}
+	}
+	if val, err := db.Get([]byte("key042")); err != notFoundInDBErr {
+		t.Errorf(`storage.Get("key042") returns unexpected value for deleted key: "%s" (%v)`, val, err)
+	}
+
+	vals, _ := db.MultiGet([][]byte{[]byte("key001"), []byte("key059"), []byte("key030")})
+	if string(vals[0]) != "1" || string(vals[1]) != "59" || string(vals[2]) != "updated" {
+		t.Errorf("storage.MultiGet returns unexpected values: %q", vals)
+	}
+}
+
+func Test_ClevelDBOrdersKeysWithBigEndianIntegerComparator(t *testing.T) {
+	db := newTestClevelDBWithComparator(t, BigEndianIntegerComparator{})
+
+	// Keys are the minimal big-endian encoding of each number, so they vary in width
+	encode := func(n uint64) []byte {
+		buf := binary.BigEndian.AppendUint64(nil, n)
+		for len(buf) > 1 && buf[0] == 0 {
+			buf = buf[1:]
+		}
+		return buf
+	}
+
+	for _, n := range []uint64{70000, 3, 256, 1, 255, 65536, 2} {
+		_ = db.Put(encode(n), []byte(fmt.Sprint(n)))
+	}
+	flushTestMemtable(t, db)
+	_ = db.Put(encode(1000), []byte("1000"))
+
+	iter := db.NewIterator(&ReadOptions{LowerBound: encode(2), UpperBound: encode(70000)})
+	var vals []string
+	for ok := iter.Valid(); ok; ok = iter.Next() {
+		vals = append(vals, string(iter.Value()))
+	}
+	iter.Release()
+
+	checkKeys(t, "NewIterator (big-endian integer comparator)", vals, []string{"2", "3", "255", "256", "1000", "65536"})
+
+	if val, err := db.Get(encode(256)); string(val) != "256" || err != nil {
+		t.Errorf(`storage.Get(256) returns unexpected value: "%s" (%v)`, val, err)
+	}
+}
+
+func Test_ComparatorsFindShortSeparatorsAndSuccessors(t *testing.T) {
+	cmp := BytewiseComparator{}
+
+	tests := []struct {
+		start, limit, expected string
+	}{
+		{"abcdef", "abzz", "abd"},
+		{"abc", "abd", "abc"},      // the differing byte can't be incremented without reaching the limit
+		{"abc", "abcdef", "abc"},   // start is a prefix of the limit
+		{"ab\xff", "ac", "ab\xff"}, // 0xff can't be incremented
+		{"foo", "zoo", "g"},
+	}
+	for _, test := range tests {
+		separator := cmp.FindShortestSeparator([]byte(test.start), []byte(test.limit))
+		if string(separator) != test.expected {
+			t.Errorf("FindShortestSeparator(%q, %q) returns unexpected separator: %q", test.start, test.limit, separator)
+		}
+	}
+
+	for key, expected := range map[string]string{"abc": "b", "\xff\xffa": "\xff\xffb", "\xff": "\xff"} {
+		if successor := cmp.FindShortSuccessor([]byte(key)); string(successor) != expected {
+			t.Errorf("FindShortSuccessor(%q) returns unexpected successor: %q", key, successor)
+		}
+	}
+
+	if cmp := (BigEndianIntegerComparator{}); cmp.Compare([]byte{0x01, 0x00}, []byte{0x02}) <= 0 ||
+		cmp.Compare([]byte{0x00, 0x02}, []byte{0x02}) == 0 {
+		t.Errorf("BigEndianIntegerComparator.Compare returns unexpected order")
+	}
+}
+
+func Test_ManifestRejectsDifferentComparator(t *testing.T) {
+	fs, dir := NewMemFS(), ssTablesDir
+	_ = fs.MkdirAll(dir)
+
+	// A new db records its comparator, after which only the same comparator can open it
+	if err := checkManifest(fs, dir, ReverseBytewiseComparator{}); err != nil {
+		t.Fatalf("checkManifest returns unexpected error for a new db: %v", err)
+	}
+	if err := checkManifest(fs, dir, ReverseBytewiseComparator{}); err != nil {
+		t.Errorf("checkManifest returns unexpected error for the same comparator: %v", err)
+	}
+	if err := checkManifest(fs, dir, BytewiseComparator{}); !errors.Is(err, comparatorMismatchErr) {
+		t.Errorf("checkManifest returns unexpected error for a different comparator: %v", err)
+	}
+
+	m, err := readManifest(fs, dir)
+	if err != nil || m.comparator != (ReverseBytewiseComparator{}).Name() {
+		t.Errorf("readManifest returns unexpected manifest: %+v (%v)", m, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+const (
+	crashTestKeys     = 40  // the number of keys that the workloads write to
+	crashTestSessions = 6   // the number of times the db is reloaded (i.e. crashes) in each run
+	crashTestMaxOps   = 200 // the maximum number of changes to the file system before each crash
+	crashTestCounters = 3   // the number of keys that the workloads merge into
+)
+
+// crashTestOp : A change to the db, along with the same change to the model of the db's contents (a nil model means
+// that the change doesn't change the db's contents, e.g. a flush)
+// Merges use UInt64AddOperator (on their own keys, see crashTestCounters), and the model stores each counter's value
+// in decimal
+type crashTestOp struct {
+	name  string
+	apply func(db *ClevelDB) error
+	model func(model map[string]string)
+}
+
+// randomCrashTestOp : Returns a random Put, Merge, Delete, DeleteRange, flush or compaction
+func randomCrashTestOp(rng *rand.Rand, seq int) crashTestOp {
+	key := fmt.Sprintf("key%03d", rng.Intn(crashTestKeys))
+
+	switch n := rng.Intn(24); {
+	case n < 4:
+		counter := fmt.Sprintf("counter%d", rng.Intn(crashTestCounters))
+		return crashTestOp{
+			name:  fmt.Sprintf("Merge(%s, 1)", counter),
+			apply: func(db *ClevelDB) error { return db.Merge([]byte(counter), uint64Bytes(1)) },
+			model: func(model map[string]string) {
+				var count int
+				_, _ = fmt.Sscan(model[counter], &count)
+				model[counter] = fmt.Sprint(count + 1)
+			},
+		}
+	case n < 16:
+		// Values are padded, so that the memtable fills up (and is flushed in the background) within a few sessions
+		val := fmt.Sprintf("value%d-%0300d", seq, 0)
+		return crashTestOp{
+			name:  fmt.Sprintf("Put(%s, %s)", key, val),
+			apply: func(db *ClevelDB) error { return db.Put([]byte(key), []byte(val)) },
+			model: func(model map[string]string) { model[key] = val },
+		}
+	case n < 19:
+		return crashTestOp{
+			name:  fmt.Sprintf("Delete(%s)", key),
+			apply: func(db *ClevelDB) error { return db.Delete([]byte(key)) },
+			model: func(model map[string]string) { delete(model, key) },
+		}
+	case n < 21:
+		limit := fmt.Sprintf("key%03d", rng.Intn(crashTestKeys))
+		return crashTestOp{
+			name:  fmt.Sprintf("DeleteRange(%s, %s)", key, limit),
+			apply: func(db *ClevelDB) error { return db.DeleteRange([]byte(key), []byte(limit)) },
+			model: func(model map[string]string) {
+				for k := range model {
+					if k >= key && k < limit {
+						delete(model, k)
+					}
+				}
+			},
+		}
+	case n < 23:
+		return crashTestOp{name: "flush", apply: flushCrashTestDB}
+	default:
+		return crashTestOp{name: "Compact", apply: func(db *ClevelDB) error { return db.Compact() }}
+	}
+}
+
+// flushCrashTestDB : Flushes the memtable (in the foreground, unlike makeRoomForWrite)
+func flushCrashTestDB(db *ClevelDB) error {
+	db.pauseBackgroundWork()
+	defer db.resumeBackgroundWork()
+
+	return flushMemtable(db)
+}
+
+// crashTestContents : Returns the value of every key in the db (with the counters in decimal, see crashTestOp)
+func crashTestContents(t *testing.T, db *ClevelDB) map[string]string {
+	contents := make(map[string]string)
+	for i := 0; i < crashTestKeys; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		val, err := db.Get([]byte(key))
+		if err == nil {
+			contents[key] = string(val)
+		} else if err != notFoundInDBErr {
+			t.Fatalf(`storage.Get("%s") returns unexpected error: %v`, key, err)
+		}
+	}
+
+	for i := 0; i < crashTestCounters; i++ {
+		key := fmt.Sprintf("counter%d", i)
+		val, err := db.Get([]byte(key))
+		if err == nil {
+			contents[key] = fmt.Sprint(binary.BigEndian.Uint64(val))
+		} else if err != notFoundInDBErr {
+			t.Fatalf(`storage.Get("%s") returns unexpected error: %v`, key, err)
+		}
+	}
+	return contents
+}
+
+// runCrashTest : Runs random workloads against a db, and crashes the db at a random point of each workload
+// Every change that was acknowledged (i.e. returned without an error) has to survive the crash. The change that
+// failed (because of the crash) either survives as a whole or not at all
+func runCrashTest(t *testing.T, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	fs := NewFaultFS(NewMemFS())
+	opts := &Options{FS: fs, Dir: "db", MergeOperator: UInt64AddOperator{}}
+
+	model := make(map[string]string)
+	var failed *crashTestOp
+
+	for session := 0; session < crashTestSessions; session++ {
+		fs.SetShortReads(rng.Intn(2) == 0)
+		db, err := loadClevelDB(opts)
+		if err != nil {
+			t.Fatalf("seed %d: loadClevelDB returns unexpected error after crash %d: %v", seed, session, err)
+		}
+		fs.SetShortReads(false)
+
+		contents := crashTestContents(t, db)
+		if failed != nil && failed.model != nil && !reflect.DeepEqual(contents, model) {
+			failed.model(model)
+		}
+		if !reflect.DeepEqual(contents, model) {
+			lastOp := "none"
+			if failed != nil {
+				lastOp = failed.name
+			}
+			t.Fatalf("seed %d: db has unexpected contents after crash %d (failed op: %s)-- Expected: %v, Actual: %v",
+				seed, session, lastOp, model, contents)
+		}
+
+		fs.CrashAfter(rng.Intn(crashTestMaxOps))
+		failed = nil
+		// Full memtables are flushed (and compacted) in the background, while the workload keeps writing
+		for seq := 0; failed == nil; seq++ {
+			op := randomCrashTestOp(rng, seq)
+			if err := op.apply(db); err != nil {
+				failed = &op
+			} else if op.model != nil {
+				op.model(model)
+			}
+		}
+
+		_ = db.Close()
+		if rng.Intn(2) == 0 {
+			err = fs.DropUnsyncedData()
+		} else {
+			err = fs.TearUnsyncedData(rng)
+		}
+		if err != nil {
+			t.Fatalf("seed %d: error crashing file system: %v", seed, err)
+		}
+	}
+}
+
+func Test_ClevelDBKeepsAcknowledgedWritesAfterCrashes(t *testing.T) {
+	for seed := int64(1); seed <= 50; seed++ {
+		runCrashTest(t, seed)
+	}
+}
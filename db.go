@@ -7,9 +7,17 @@ type DB interface {
 	RangeScan(start, limit []byte) (Iterator, error)
 }
 
+// Iterator : Moves over a range of keys in either direction
+// Each of the positioning methods returns true if the iterator ends up at a key (i.e. is valid)
 type Iterator interface {
+	First() bool
+	Last() bool
+	Seek(key []byte) bool
 	Next() bool
+	Prev() bool
+	Valid() bool
 	Error() error
 	Key() []byte
 	Value() []byte
+	Release()
 }
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FaultOp : A kind of operation that FaultFS can fail
+type FaultOp int
+
+const (
+	FaultWrite FaultOp = iota // Write, WriteAt and Truncate
+	FaultSync
+	FaultRename
+	numFaultOps
+)
+
+// otherOp : Any other operation that changes the file system (e.g. Create or Remove), which can only fail once the
+// file system has crashed
+const otherOp FaultOp = -1
+
+var injectedFaultErr = errors.New("injected fault")
+
+// FaultFS : Wraps another FS, and injects the faults that a crash (or a failing disk) would cause:
+// - Data that was written but not synced is lost (or only partially kept) when the file system "crashes"
+// - The nth write, sync or rename fails (FailNth), or every change fails once the file system has crashed (CrashAfter)
+// - Reads return fewer bytes than requested (SetShortReads)
+//
+// Changes to the directory tree (i.e. creating, renaming, linking and removing files) are durable as soon as they
+// return, so only the contents of files are lost by a crash
+type FaultFS struct {
+	fs         FS
+	mu         sync.Mutex
+	nodes      map[string]*faultNode // the files opened through FaultFS, keyed by path (hard links share a node)
+	counts     [numFaultOps]int      // the number of operations of each kind so far
+	failAt     [numFaultOps]int      // the (1-based) count at which each kind of operation fails (0 if it doesn't)
+	crashAfter int                   // the number of changes left before the crash (0 if no crash is scheduled)
+	crashed    bool
+	shortReads bool
+}
+
+// faultNode : The durability of a file's contents
+type faultNode struct {
+	dirty  bool   // whether the file was changed since it was last synced
+	synced []byte // the contents of the file when it was last synced (only set if dirty)
+}
+
+// NewFaultFS : Returns a FaultFS that wraps the given FS (which shouldn't be changed other than through FaultFS)
+func NewFaultFS(fs FS) *FaultFS {
+	return &FaultFS{fs: fs, nodes: make(map[string]*faultNode)}
+}
+
+// FailNth : The nth operation of the given kind (counting from now) fails with injectedFaultErr (without changing
+// anything). Every other operation of that kind succeeds
+func (f *FaultFS) FailNth(op FaultOp, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failAt[op] = f.counts[op] + n
+}
+
+// CrashAfter : The file system "crashes" after n more changes (i.e. writes, syncs, truncates and changes to the
+// directory tree), after which every change fails with injectedFaultErr until the unsynced data is dropped
+func (f *FaultFS) CrashAfter(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.crashAfter = n + 1
+}
+
+// SetShortReads : Whether Read returns (at most) half of the requested bytes
+// Note: ReadAt always reads as much as it can, since io.ReaderAt has to return an error for a short read
+func (f *FaultFS) SetShortReads(enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.shortReads = enabled
+}
+
+// DropUnsyncedData : Reverts every file to its contents when it was last synced (i.e. simulates a crash, after
+// which the file system works again). Files that are still open shouldn't be used afterwards
+func (f *FaultFS) DropUnsyncedData() error {
+	return f.revert(nil)
+}
+
+// TearUnsyncedData : Same as DropUnsyncedData, except a random prefix of the data that was appended to a file since
+// it was last synced is kept (i.e. simulates a crash in the middle of writing the appended data)
+func (f *FaultFS) TearUnsyncedData(rng *rand.Rand) error {
+	return f.revert(rng)
+}
+
+func (f *FaultFS) revert(rng *rand.Rand) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for path, node := range f.nodes {
+		if !node.dirty {
+			continue
+		}
+
+		data := node.synced
+		if rng != nil {
+			current, err := readFile(f.fs, path)
+			if err != nil {
+				return err
+			}
+			if bytes.HasPrefix(current, node.synced) {
+				data = current[:len(node.synced)+rng.Intn(len(current)-len(node.synced)+1)]
+			}
+		}
+
+		// Hard links share a node, so the node is only reverted once
+		err := writeFile(f.fs, path, data)
+		if err != nil {
+			return err
+		}
+		node.dirty, node.synced = false, nil
+	}
+
+	f.failAt = [numFaultOps]int{}
+	f.crashAfter = 0
+	f.crashed = false
+	return nil
+}
+
+// check : Returns injectedFaultErr if the operation has to fail
+func (f *FaultFS) check(op FaultOp) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashAfter > 0 {
+		f.crashAfter--
+		f.crashed = f.crashAfter == 0
+	}
+	if f.crashed {
+		return injectedFaultErr
+	}
+
+	if op != otherOp {
+		f.counts[op]++
+		if f.failAt[op] == f.counts[op] {
+			f.failAt[op] = 0
+			return injectedFaultErr
+		}
+	}
+	return nil
+}
+
+// node : Returns the file's node (creating it if the file hasn't been opened through FaultFS before)
+func (f *FaultFS) node(name string) *faultNode {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name = filepath.Clean(name)
+	node, ok := f.nodes[name]
+	if !ok {
+		node = &faultNode{}
+		f.nodes[name] = node
+	}
+	return node
+}
+
+// movePaths : Calls move for the path and for every path inside it (if it's a directory)
+func (f *FaultFS) movePaths(name string, move func(path, suffix string)) {
+	name = filepath.Clean(name)
+	prefix := name + string(filepath.Separator)
+	for path := range f.nodes {
+		if path == name || strings.HasPrefix(path, prefix) {
+			move(path, path[len(name):])
+		}
+	}
+}
+
+func (f *FaultFS) Create(name string) (File, error) {
+	err := f.check(otherOp)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.fs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Creating (or truncating) the file is durable, so it has no unsynced data yet
+	node := f.node(name)
+	f.mu.Lock()
+	node.dirty, node.synced = false, nil
+	f.mu.Unlock()
+
+	return &faultFile{File: file, fs: f, node: node}, nil
+}
+
+func (f *FaultFS) Open(name string) (File, error) {
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, node: f.node(name)}, nil
+}
+
+func (f *FaultFS) OpenAppend(name string) (File, error) {
+	// The file is only created if it doesn't exist yet (which is a change)
+	if !exists(f.fs, name) {
+		err := f.check(otherOp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := f.fs.OpenAppend(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, node: f.node(name)}, nil
+}
+
+func (f *FaultFS) Rename(oldname, newname string) error {
+	err := f.check(FaultRename)
+	if err != nil {
+		return err
+	}
+
+	err = f.fs.Rename(oldname, newname)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.movePaths(newname, func(path, _ string) {
+		delete(f.nodes, path)
+	})
+	f.movePaths(oldname, func(path, suffix string) {
+		f.nodes[filepath.Clean(newname)+suffix] = f.nodes[path]
+		delete(f.nodes, path)
+	})
+	return nil
+}
+
+func (f *FaultFS) Remove(name string) error {
+	err := f.check(otherOp)
+	if err != nil {
+		return err
+	}
+
+	err = f.fs.Remove(name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.nodes, filepath.Clean(name))
+	return nil
+}
+
+func (f *FaultFS) RemoveAll(name string) error {
+	err := f.check(otherOp)
+	if err != nil {
+		return err
+	}
+
+	err = f.fs.RemoveAll(name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.movePaths(name, func(path, _ string) {
+		delete(f.nodes, path)
+	})
+	return nil
+}
+
+func (f *FaultFS) SameFS(other FS) bool {
+	otherFaultFS, ok := other.(*FaultFS)
+	return ok && otherFaultFS == f
+}
+
+func (f *FaultFS) Link(oldname, newname string) error {
+	err := f.check(otherOp)
+	if err != nil {
+		return err
+	}
+
+	err = f.fs.Link(oldname, newname)
+	if err != nil {
+		return err
+	}
+
+	// Both names share the same contents (and so the same unsynced data)
+	node := f.node(oldname)
+	f.mu.Lock()
+	f.nodes[filepath.Clean(newname)] = node
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *FaultFS) MkdirAll(dir string) error {
+	if exists(f.fs, dir) {
+		return nil
+	}
+
+	err := f.check(otherOp)
+	if err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(dir)
+}
+
+func (f *FaultFS) List(dir string) ([]string, error) {
+	return f.fs.List(dir)
+}
+
+func (f *FaultFS) Stat(name string) (os.FileInfo, error) {
+	return f.fs.Stat(name)
+}
+
+// Lock : Locks aren't affected by faults (a crashed process' locks are released, which Close does)
+func (f *FaultFS) Lock(name string) (io.Closer, error) {
+	return f.fs.Lock(name)
+}
+
+// faultFile : A file that was opened through FaultFS
+type faultFile struct {
+	File
+	fs   *FaultFS
+	node *faultNode
+}
+
+func (f *faultFile) Read(buf []byte) (int, error) {
+	f.fs.mu.Lock()
+	shortReads := f.fs.shortReads
+	f.fs.mu.Unlock()
+
+	// At least one byte is read, so that callers that read until the buffer is full still make progress
+	if shortReads && len(buf) > 1 {
+		buf = buf[:len(buf)/2]
+	}
+	return f.File.Read(buf)
+}
+
+// change : Checks whether the change has to fail, and otherwise keeps the file's contents from when it was last
+// synced (unless the file already has unsynced data)
+func (f *faultFile) change(op FaultOp) error {
+	err := f.fs.check(op)
+	if err != nil {
+		return err
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.node.dirty {
+		return nil
+	}
+
+	info, err := f.File.Stat()
+	if err != nil {
+		return err
+	}
+
+	synced := make([]byte, info.Size())
+	_, err = f.File.ReadAt(synced, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	f.node.dirty, f.node.synced = true, synced
+	return nil
+}
+
+func (f *faultFile) Write(buf []byte) (int, error) {
+	err := f.change(FaultWrite)
+	if err != nil {
+		return 0, err
+	}
+	return f.File.Write(buf)
+}
+
+func (f *faultFile) WriteAt(buf []byte, offset int64) (int, error) {
+	err := f.change(FaultWrite)
+	if err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(buf, offset)
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	err := f.change(FaultWrite)
+	if err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *faultFile) Sync() error {
+	err := f.fs.check(FaultSync)
+	if err != nil {
+		return err
+	}
+
+	err = f.File.Sync()
+	if err != nil {
+		return err
+	}
+
+	f.fs.mu.Lock()
+	f.node.dirty, f.node.synced = false, nil
+	f.fs.mu.Unlock()
+	return nil
+}
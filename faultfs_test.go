@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// loadTestFaultDB : Returns a db in the FaultFS, which the test closes (e.g. to simulate a crash) before reloading it
+func loadTestFaultDB(t *testing.T, fs *FaultFS) *ClevelDB {
+	db, err := loadClevelDB(&Options{FS: fs, Dir: "db"})
+	if err != nil {
+		t.Fatalf("error loading db: %v", err)
+	}
+	return db
+}
+
+func Test_FaultFSFailsNthOperation(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	db := loadTestFaultDB(t, fs)
+
+	// A failed write isn't acknowledged (and so isn't recovered), but the db can still be written to afterwards
+	fs.FailNth(FaultWrite, 1)
+	if err := db.Put([]byte("failed"), []byte("value")); err == nil {
+		t.Errorf("storage.Put returns no error for failed write")
+	}
+	_ = db.Put([]byte("key"), []byte("value"))
+
+	// A failed sync isn't acknowledged either, so its (unsynced) record is lost in a crash
+	fs.FailNth(FaultSync, 1)
+	if err := db.Put([]byte("unsynced"), []byte("value")); err == nil {
+		t.Errorf("storage.Put returns no error for failed sync")
+	}
+
+	// A flush that can't rename its table leaves the memtable's records in the journal
+	fs.FailNth(FaultRename, 1)
+	db.pauseBackgroundWork()
+	if err := flushMemtable(db); err != injectedFaultErr {
+		t.Errorf("flushMemtable returns unexpected error for failed rename: %v", err)
+	}
+	db.resumeBackgroundWork()
+
+	_ = db.Close()
+	if err := fs.DropUnsyncedData(); err != nil {
+		t.Fatalf("DropUnsyncedData returns unexpected error: %v", err)
+	}
+
+	db = loadTestFaultDB(t, fs)
+	defer db.Close()
+
+	if val, err := db.Get([]byte("key")); string(val) != "value" || err != nil {
+		t.Errorf(`storage.Get("key") returns unexpected value after crash: "%s" (%v)`, val, err)
+	}
+	for _, key := range []string{"failed", "unsynced"} {
+		if val, err := db.Get([]byte(key)); err != notFoundInDBErr {
+			t.Errorf(`storage.Get("%s") returns unexpected value for unacknowledged write: "%s" (%v)`, key, val, err)
+		}
+	}
+	if names, _ := fs.List(db.tablesDir); !reflect.DeepEqual(names, []string{manifestFilename}) {
+		t.Errorf("fs.List returns unexpected tables after failed flush: %v", names)
+	}
+}
+
+func Test_ClevelDBRecoversFromTornJournalAndShortReads(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	db := loadTestFaultDB(t, fs)
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+
+	// The last record is only partially written before the crash
+	fs.FailNth(FaultSync, 1)
+	_ = db.Put([]byte("torn"), []byte("value"))
+	journalPath := db.journalPath(db.memtable.journalNum)
+	journal, _ := readFile(fs, journalPath)
+	_ = db.Close()
+	_ = fs.DropUnsyncedData()
+	_ = writeFile(fs, journalPath, journal[:len(journal)-3])
+
+	fs.SetShortReads(true)
+	db = loadTestFaultDB(t, fs)
+	fs.SetShortReads(false)
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		if val, err := db.Get([]byte(key)); string(val) != "value" || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value after recovery: "%s" (%v)`, key, val, err)
+		}
+	}
+	if val, err := db.Get([]byte("torn")); err != notFoundInDBErr {
+		t.Errorf(`storage.Get("torn") returns unexpected value for partial record: "%s" (%v)`, val, err)
+	}
+
+	// The partial record was truncated, so records written after recovery are recovered too
+	_ = db.Put([]byte("after"), []byte("value"))
+	_ = db.Close()
+
+	db = loadTestFaultDB(t, fs)
+	defer db.Close()
+	if val, err := db.Get([]byte("after")); string(val) != "value" || err != nil {
+		t.Errorf(`storage.Get("after") returns unexpected value after reloading: "%s" (%v)`, val, err)
+	}
+}
+
+func Test_ClevelDBRecoversMemtablesWaitingToBeFlushed(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	db, err := loadClevelDB(&Options{FS: fs, Dir: "db", MergeOperator: UInt64AddOperator{}})
+	if err != nil {
+		t.Fatalf("error loading db: %v", err)
+	}
+
+	// The full memtable can't be flushed before the crash, so its writes are only in its own journal
+	db.pauseBackgroundWork()
+	_ = db.Merge([]byte("counter"), uint64Bytes(1))
+	putTestKeys(t, db, 0, 400)
+	_ = db.Merge([]byte("counter"), uint64Bytes(2))
+	_ = db.Put([]byte("key00000"), []byte("updated"))
+
+	fs.CrashAfter(0)
+	db.resumeBackgroundWork()
+	_ = db.Close()
+	if err := fs.DropUnsyncedData(); err != nil {
+		t.Fatalf("DropUnsyncedData returns unexpected error: %v", err)
+	}
+
+	db = loadTestDBFromDir(t, fs, "db", &Options{MergeOperator: UInt64AddOperator{}})
+	for key, expected := range map[string]string{"key00000": "updated", "key00001": "value", "key00399": "value"} {
+		if val, err := db.Get([]byte(key)); string(val) != expected || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value after recovery: "%s" (%v)`, key, val, err)
+		}
+	}
+	if val, err := db.Get([]byte("counter")); err != nil || binary.BigEndian.Uint64(val) != 3 {
+		t.Errorf(`storage.Get("counter") returns unexpected value after recovery: %v (%v)`, val, err)
+	}
+
+	// The recovered full memtable is flushed in the background, after which its journal is removed
+	db.waitForBackgroundWork()
+	if journalNums, _ := listJournals(fs, "db"); len(journalNums) != 1 || len(db.tables) != 1 {
+		t.Errorf("db has unexpected journals (%v) and tables (%d) after flushing recovered memtable", journalNums, len(db.tables))
+	}
+}
+
+func Test_ClevelDBDoesntReplayFlushedJournal(t *testing.T) {
+	fs := NewMemFS()
+	opts := &Options{FS: fs, Dir: "db", MergeOperator: UInt64AddOperator{}}
+	db, err := loadClevelDB(opts)
+	if err != nil {
+		t.Fatalf("error loading db: %v", err)
+	}
+
+	_ = db.Merge([]byte("counter"), uint64Bytes(1))
+	journalPath := db.journalPath(db.memtable.journalNum)
+	journal, _ := readFile(fs, journalPath)
+	flushTestMemtable(t, db)
+	_ = db.Close()
+
+	// The db crashed after the table was installed, but before the journal was removed
+	_ = writeFile(fs, journalPath, journal)
+
+	db = loadTestDBFromDir(t, fs, "db", opts)
+	if val, err := db.Get([]byte("counter")); err != nil || binary.BigEndian.Uint64(val) != 1 {
+		t.Errorf(`storage.Get("counter") returns unexpected value after recovery: %v (%v)`, val, err)
+	}
+	if exists(fs, journalPath) {
+		t.Errorf("loadClevelDB doesn't remove flushed journal: %s", journalPath)
+	}
+}
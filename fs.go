@@ -0,0 +1,184 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// File : An open file (*os.File implements File)
+type File interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FS : The file system that the db's files are stored in (OSFS, or MemFS for tests)
+// Missing files and directories are reported with errors that match os.ErrNotExist (i.e. through errors.Is)
+type FS interface {
+	// Create : Creates (or truncates) the file, which is opened for reading and writing
+	Create(name string) (File, error)
+
+	// Open : Opens the file for reading
+	Open(name string) (File, error)
+
+	// OpenAppend : Opens the file for reading and appending (creating it if it doesn't exist)
+	OpenAppend(name string) (File, error)
+
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+
+	// Link : Creates newname as a hard link to oldname (i.e. both names share the same contents)
+	Link(oldname, newname string) error
+
+	MkdirAll(dir string) error
+
+	// List : Returns the names of the directory's entries (in sorted order)
+	List(dir string) ([]string, error)
+
+	Stat(name string) (os.FileInfo, error)
+
+	// Lock : Takes an exclusive lock on the file (creating it if needed), which is held until the returned Closer is
+	// closed. Returns dbLockedErr if the file is already locked
+	Lock(name string) (io.Closer, error)
+
+	// SameFS : Returns true if the other file system is this one (i.e. files can be renamed and linked between them)
+	SameFS(other FS) bool
+}
+
+// OSFS : The operating system's file system
+type OSFS struct{}
+
+func (OSFS) Create(name string) (File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (OSFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (OSFS) OpenAppend(name string) (File, error) {
+	return os.OpenFile(name, os.O_APPEND|os.O_RDWR|os.O_CREATE, os.ModePerm)
+}
+
+func (OSFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (OSFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (OSFS) SameFS(other FS) bool {
+	_, ok := other.(OSFS)
+	return ok
+}
+
+func (OSFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, os.ModePerm)
+}
+
+func (OSFS) List(dir string) ([]string, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(dirEntries))
+	for i, entry := range dirEntries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Lock(name string) (io.Closer, error) {
+	file, err := lockFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return osLock{file: file}, nil
+}
+
+type osLock struct {
+	file *os.File
+}
+
+func (l osLock) Close() error {
+	return unlockFile(l.file)
+}
+
+// readFile : Returns the contents of the file
+func readFile(fs FS, name string) ([]byte, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// writeFile : Replaces the contents of the file (which is synced, so the contents are durable once writeFile returns)
+func writeFile(fs FS, name string, data []byte) error {
+	file, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyFile : Copies the file at src to dst (which is synced, so the copy is durable once copyFile returns)
+func copyFile(fs FS, src, dst string) error {
+	srcFile, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := fs.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dstFile, srcFile)
+	if err == nil {
+		err = dstFile.Sync()
+	}
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// exists : Returns true if the file (or directory) exists
+func exists(fs FS, name string) bool {
+	_, err := fs.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Purposely kept small for testing; should ideally be a multiple of disk block size (e.g. 4KB)
+const indexPartitionSizeInBytes = 64
+
+// Index : A table's index, which either has an entry for every data block, or is partitioned (i.e. it's a top-level
+// index with an entry for every index partition, and the partitions are only loaded through the block cache on
+// demand, so the memory used by a table's index doesn't grow with the size of the table, unless the partitions are
+// pinned, see Options.PinIndexBlocks)
+type Index struct {
+	blocks      []indexBlock // the data blocks (or the index partitions, if the index is partitioned)
+	offset      int64
+	partitioned bool
+}
+
+type indexBlock struct {
+	key      []byte // greater than every key of the previous block, and <= the block's first key
+	offset   int64
+	size     int64
+	checksum uint32 // CRC-32 of the block's records
+}
+
+// Index entries are encoded as: key length | key | block offset | block size | block checksum
+func encodeIndexBlock(toAppend []byte, block indexBlock) []byte {
+	toAppend = binary.BigEndian.AppendUint16(toAppend, uint16(len(block.key)))
+	toAppend = append(toAppend, block.key...)
+	toAppend = binary.BigEndian.AppendUint32(toAppend, uint32(block.offset))
+	toAppend = binary.BigEndian.AppendUint32(toAppend, uint32(block.size))
+	toAppend = binary.BigEndian.AppendUint32(toAppend, block.checksum)
+	return toAppend
+}
+
+func decodeIndexBlocks(buf []byte) ([]indexBlock, error) {
+	var indexBlocks []indexBlock
+	for len(buf) > 0 {
+		if len(buf) < 2 {
+			return nil, corruptSSTableErr
+		}
+		keyLength := int(binary.BigEndian.Uint16(buf))
+		buf = buf[2:]
+
+		// The key is followed by the block's offset, size and checksum
+		if len(buf) < keyLength+12 {
+			return nil, corruptSSTableErr
+		}
+
+		indexBlocks = append(indexBlocks, indexBlock{
+			key:      buf[:keyLength],
+			offset:   int64(binary.BigEndian.Uint32(buf[keyLength:])),
+			size:     int64(binary.BigEndian.Uint32(buf[keyLength+4:])),
+			checksum: binary.BigEndian.Uint32(buf[keyLength+8:]),
+		})
+		buf = buf[keyLength+12:]
+	}
+
+	return indexBlocks, nil
+}
+
+// writeIndex : Writes the index (immediately after the key-value data on disk)
+// A partitioned index is written as a series of partitions (each holding the entries of several data blocks),
+// followed by the top-level index (with an entry for each partition)
+// Returns the index that's kept in memory (i.e. the top-level index, if the index is partitioned)
+func writeIndex(file File, blocks []indexBlock, partitioned bool) (*Index, error) {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	if !partitioned {
+		var toAppend []byte
+		for _, block := range blocks {
+			toAppend = encodeIndexBlock(toAppend, block)
+		}
+
+		_, err = file.Write(toAppend)
+		if err != nil {
+			return nil, errors.New("error writing index block to file")
+		}
+		return &Index{blocks: blocks, offset: offset}, nil
+	}
+
+	var partitions []indexBlock
+	var partition []byte
+	var firstKey []byte
+
+	for i, block := range blocks {
+		if len(partition) == 0 {
+			firstKey = block.key
+		}
+		partition = encodeIndexBlock(partition, block)
+
+		if len(partition) >= indexPartitionSizeInBytes || i == len(blocks)-1 {
+			_, err = file.Write(partition)
+			if err != nil {
+				return nil, errors.New("error writing index partition to file")
+			}
+
+			// A partition's key is the key of its first block
+			partitions = append(partitions, indexBlock{
+				key:      firstKey,
+				offset:   offset,
+				size:     int64(len(partition)),
+				checksum: crc32.ChecksumIEEE(partition),
+			})
+			offset += int64(len(partition))
+			partition = nil
+		}
+	}
+
+	var toAppend []byte
+	for _, partition := range partitions {
+		toAppend = encodeIndexBlock(toAppend, partition)
+	}
+
+	_, err = file.Write(toAppend)
+	if err != nil {
+		return nil, errors.New("error writing index block to file")
+	}
+	return &Index{blocks: partitions, offset: offset, partitioned: true}, nil
+}
+
+// indexSeparator : Returns a (preferably short) index key for a block, i.e. a key k such that last < k <= first
+// (where last is the last key of the previous block, and first is the block's first key)
+func indexSeparator(cmp Comparator, last, first []byte) []byte {
+	// FindShortestSeparator returns a key within [last, first), so last itself can't be used
+	separator := cmp.FindShortestSeparator(last, first)
+	if cmp.Compare(separator, last) <= 0 || cmp.Compare(separator, first) > 0 {
+		return first
+	}
+	return separator
+}
+
+// size : Returns the size of the index (as stored in the SSTable)
+func (index *Index) size() int64 {
+	var size int64
+	for _, block := range index.blocks {
+		size += int64(2 + len(block.key) + 12)
+	}
+	return size
+}
+
+// numPartitions : Returns the number of index partitions (an index that isn't partitioned has a single partition)
+func (index *Index) numPartitions() int {
+	if !index.partitioned {
+		return 1
+	}
+	return len(index.blocks)
+}
+
+// Performs a binary search and return the position of the index block whose range matches the key
+// (i.e. the last block whose key is less than or equal to the key, or the first block if there isn't one)
+func (index *Index) search(cmp Comparator, key []byte) int {
+	return searchIndexBlocks(cmp, index.blocks, key)
+}
+
+func searchIndexBlocks(cmp Comparator, blocks []indexBlock, key []byte) int {
+	left := 0
+	right := len(blocks) - 1
+
+	for left < right {
+		// Round up, so that 'left = mid' always makes progress
+		mid := left + (right-left+1)/2
+		c := cmp.Compare(key, blocks[mid].key)
+
+		if c == 0 {
+			return mid
+		}
+
+		if c < 0 {
+			right = mid - 1
+		} else {
+			left = mid
+		}
+	}
+
+	return left
+}
+
+// pinIndex : Pins the reader's index in the block cache, along with every partition of a partitioned index (which are
+// read when the table is opened, rather than on demand), so that none of them are ever evicted
+// A partition that can't be read is left to be loaded on demand, and the partitions of a memory-mapped table aren't
+// pinned, since they're decoded in place rather than read through the block cache
+func (r *tableReader) pinIndex(cache *BlockCache) {
+	r.pinnedIn = cache
+	r.pin(blockCacheKey{fileNum: r.fileNum, offset: r.index.offset}, r.index, r.index.size())
+	if !r.index.partitioned || r.data != nil {
+		return
+	}
+
+	for _, handle := range r.index.blocks {
+		buf, err := r.readAt(handle, true)
+		if err != nil {
+			continue
+		}
+
+		blocks, err := decodeIndexBlocks(buf)
+		if err != nil {
+			continue
+		}
+		r.pin(blockCacheKey{fileNum: r.fileNum, offset: handle.offset}, blocks, handle.size)
+	}
+}
+
+func (r *tableReader) pin(key blockCacheKey, value any, charge int64) {
+	r.pinnedIn.Pin(key, value, charge)
+	r.pinned = append(r.pinned, key)
+}
+
+// partition : Returns the data blocks of the index partition at the given position, loading the partition through
+// the block cache (same as data blocks)
+func (ss *SSTable) partition(reader *tableReader, partitionIdx int, ro *ReadOptions) ([]indexBlock, error) {
+	index := reader.index
+	if !index.partitioned {
+		return index.blocks, nil
+	}
+
+	handle := index.blocks[partitionIdx]
+	useCache := ss.blockCache != nil && reader.data == nil
+
+	key := blockCacheKey{fileNum: ss.fileNum, offset: handle.offset}
+	if useCache {
+		if cached, ok := ss.blockCache.Get(key); ok {
+			return cached.([]indexBlock), nil
+		}
+	}
+
+	buf, err := reader.readAt(handle, ro.VerifyChecksums)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := decodeIndexBlocks(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if useCache && ro.FillCache {
+		ss.blockCache.Insert(key, blocks, handle.size)
+	}
+	return blocks, nil
+}
+
+// findBlock : Returns the position (i.e. the partition and the block within the partition) of the data block whose
+// range matches the key, along with the partition's data blocks
+func (ss *SSTable) findBlock(reader *tableReader, key []byte, ro *ReadOptions) (int, []indexBlock, int, error) {
+	partitionIdx := 0
+	if reader.index.partitioned {
+		partitionIdx = reader.index.search(ss.cmp, key)
+	}
+
+	blocks, err := ss.partition(reader, partitionIdx, ro)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	return partitionIdx, blocks, searchIndexBlocks(ss.cmp, blocks, key), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func Test_IndexSearchMatchesLinearSearch(t *testing.T) {
+	for _, cmp := range []Comparator{BytewiseComparator{}, ReverseBytewiseComparator{}, BigEndianIntegerComparator{}} {
+		// The blocks' keys are a sorted (deduplicated) sample of the generated keys
+		search := func(keys [][]byte, target []byte) bool {
+			sort.Slice(keys, func(i, j int) bool {
+				return cmp.Compare(keys[i], keys[j]) < 0
+			})
+
+			var blocks []indexBlock
+			for i, key := range keys {
+				if i == 0 || cmp.Compare(key, keys[i-1]) != 0 {
+					blocks = append(blocks, indexBlock{key: key})
+				}
+			}
+			if len(blocks) == 0 {
+				return true
+			}
+
+			// The last block whose first key is less than or equal to the target (or the first block if there isn't one)
+			expected := 0
+			for i, block := range blocks {
+				if cmp.Compare(block.key, target) <= 0 {
+					expected = i
+				}
+			}
+
+			index := &Index{blocks: blocks}
+			return index.search(cmp, target) == expected
+		}
+
+		if err := quick.Check(search, &quick.Config{MaxCount: 2000}); err != nil {
+			t.Errorf("Index.search (%s) doesn't match a linear search: %v", cmp.Name(), err)
+		}
+	}
+}
+
+func Test_PartitionedIndexLoadsPartitionsOnDemand(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.partitionedIndex = true
+	db.blockCache = newBlockCache(defaultBlockCacheCapacity)
+	db.tableCache = newTableCache(db.blockCache, &Options{})
+
+	for i := 0; i < 200; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+	}
+	flushTestMemtable(t, db)
+
+	// Reopen the table, so that its index is loaded from the file
+	table, reader, err := loadSSTable(db.fs, db.tables[0].path)
+	if err != nil {
+		t.Fatalf("error loading table: %v", err)
+	}
+	db.tableCache.evict(db.tables[0].fileNum)
+	db.initTable(table, reader)
+	db.tables[0] = table
+
+	index := acquireTestReader(t, table).index
+	if !index.partitioned || index.numPartitions() < 2 {
+		t.Fatalf("expected the table to have multiple index partitions: %v", index.numPartitions())
+	}
+	if string(table.smallest) != "key000" || string(table.largest) != "key199" {
+		t.Errorf(`loadSSTable returns unexpected smallest and largest keys: "%s", "%s"`, table.smallest, table.largest)
+	}
+
+	// The first read loads a partition and a data block, and the second read finds both in the cache
+	for i := 0; i < 2; i++ {
+		if val, err := db.Get([]byte("key150")); string(val) != "150" || err != nil {
+			t.Errorf(`storage.Get("key150") returns unexpected value: "%s" (%v)`, val, err)
+		}
+	}
+	if stats := db.BlockCacheStats(); stats.Misses != 2 || stats.Hits != 2 {
+		t.Errorf("storage.BlockCacheStats returns unexpected stats: %+v", stats)
+	}
+
+	vals, errs := db.MultiGet([][]byte{[]byte("key001"), []byte("key100"), []byte("key199"), []byte("key200")})
+	for i, expected := range []string{"1", "100", "199", ""} {
+		if string(vals[i]) != expected {
+			t.Errorf("storage.MultiGet returns unexpected value: \"%s\" (%v)", vals[i], errs[i])
+		}
+	}
+
+	// The iterator moves across partitions in both directions
+	iter := db.NewIterator(&ReadOptions{VerifyChecksums: true})
+	if keys := collectKeys(iter, iter.Next); len(keys) != 200 || keys[199] != "key199=199" {
+		t.Errorf("NewIterator returns unexpected keys: %v", keys)
+	}
+	iter.Last()
+	if keys := collectKeys(iter, iter.Prev); len(keys) != 200 || keys[199] != "key000=0" {
+		t.Errorf("NewIterator returns unexpected keys in reverse: %v", keys)
+	}
+	if iter.Seek([]byte("key0995")); string(iter.Key()) != "key100" {
+		t.Errorf(`ClevelIterator.Seek returns unexpected key: "%s"`, iter.Key())
+	}
+	if err := iter.Error(); err != nil {
+		t.Errorf("NewIterator returns unexpected error: %v", err)
+	}
+	iter.Release()
+}
+
+func Test_IndexSeparatorsAreShortenedAndLookupsStayCorrectAtBlockBoundaries(t *testing.T) {
+	db := newTestClevelDB(t)
+
+	// Keys with long, distinct suffixes (every record is larger than a block, so each key gets its own block)
+	var keys []string
+	for c := 'a'; c <= 'y'; c += 2 {
+		key := string(c) + strings.Repeat("z", 10)
+		keys = append(keys, key)
+		_ = db.Put([]byte(key), []byte(key[:5]))
+	}
+	flushTestMemtable(t, db)
+
+	// Every block after the first is indexed by a single-byte separator (e.g. "b" between "azz..." and "czz...")
+	blocks := acquireTestReader(t, db.tables[0]).index.blocks
+	if len(blocks) != len(keys) || string(blocks[0].key) != keys[0] {
+		t.Fatalf("table has unexpected index: %v blocks", len(blocks))
+	}
+	for i, block := range blocks[1:] {
+		if len(block.key) != 1 || string(block.key) <= keys[i] || string(block.key) > keys[i+1] {
+			t.Errorf("index has unexpected separator between %q and %q: %q", keys[i], keys[i+1], block.key)
+		}
+	}
+
+	for _, key := range keys {
+		if val, err := db.Get([]byte(key)); string(val) != key[:5] || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value: "%s" (%v)`, key, val, err)
+		}
+	}
+
+	// Keys on either side of each separator (i.e. between the last key of one block and the first key of the next)
+	for _, key := range []string{"b", "azzzzzzzzzzz", "bzzz", "czzzzzzzzz", "z"} {
+		if val, err := db.Get([]byte(key)); err != notFoundInDBErr {
+			t.Errorf(`storage.Get("%s") returns unexpected value for missing key: "%s" (%v)`, key, val, err)
+		}
+	}
+
+	iter := db.NewIterator(&ReadOptions{UpperBound: []byte("b")})
+	if !iter.Last() || string(iter.Key()) != keys[0] {
+		t.Errorf(`ClevelIterator.Last returns unexpected key: "%s"`, iter.Key())
+	}
+	iter.Release()
+
+	iter = db.NewIterator(nil)
+	for _, test := range []struct{ seek, expected string }{
+		{"b", keys[1]}, {"azzzzzzzzzzz", keys[1]}, {"bzzz", keys[1]}, {keys[1], keys[1]}, {"czzzzzzzzzzz", keys[2]},
+	} {
+		if iter.Seek([]byte(test.seek)); string(iter.Key()) != test.expected {
+			t.Errorf(`ClevelIterator.Seek("%s") returns unexpected key: "%s"`, test.seek, iter.Key())
+		}
+	}
+	iter.Release()
+}
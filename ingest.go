@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ingestFilename       = "ingest_%d.tmp"
+	ingestCommitFilename = "INGEST"
+)
+
+var overlappingIngestedFilesErr = errors.New("ingested files have overlapping keys")
+
+// IngestExternalFile : Bulk-loads SSTable files (e.g. written by SSTableWriter) into the db, without writing their
+// keys to the journal or the memtable
+// Every file is validated (i.e. its checksums, and the order of its keys according to the db's comparator) before
+// any of them are installed, and the files' key ranges can't overlap each other. The files are copied, so the
+// original files are left as is
+//
+// There are no sequence numbers, so each file is assigned a new file number instead, which places it after every
+// existing table (tables are ordered by file number when the db is loaded). That makes an ingested file's keys
+// more recent than any existing keys, so the memtable is flushed first if it overlaps one of the files
+// A file that doesn't overlap any table is placed at the end of the tables (i.e. at the lowest level), where it
+// doesn't need to be searched before the other tables
+// Background flushes wait until the ingestion is done, since a memtable that's waiting to be flushed is more recent
+// than the files (so they're flushed first), and writes are blocked once the files have been validated (so that the
+// memtable doesn't change while it's checked for overlaps)
+//
+// The copies keep their temporary names until the ingestion is committed (see commitIngestion), so a crash either
+// leaves every file ingested or none of them
+func (db *ClevelDB) IngestExternalFile(paths []string) error {
+	db.pauseBackgroundWork()
+	defer db.resumeBackgroundWork()
+
+	var ingested []*SSTable
+	var readers []*tableReader
+
+	// Any copies that were made are removed if the ingestion fails before it's committed (afterwards, the copies are
+	// installed when the db is loaded again, see finishIngestion)
+	committed, installed := false, false
+	defer func() {
+		if installed {
+			return
+		}
+		for i, reader := range readers {
+			_ = reader.file.Close()
+			if !committed {
+				_ = db.fs.Remove(ingested[i].path)
+			}
+		}
+	}()
+
+	for _, path := range paths {
+		table, reader, err := db.copyExternalFile(path)
+		if table != nil {
+			ingested = append(ingested, table)
+			readers = append(readers, reader)
+		}
+		if err != nil {
+			return fmt.Errorf("error ingesting %s: %w", path, err)
+		}
+	}
+
+	// Sort the files by their smallest keys, so that only neighboring files need to be checked for overlaps
+	order := make([]int, len(ingested))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return db.comparator.Compare(ingestSpan(ingested[order[i]])[0], ingestSpan(ingested[order[j]])[0]) < 0
+	})
+	for i := 1; i < len(order); i++ {
+		previous, next := ingestSpan(ingested[order[i-1]]), ingestSpan(ingested[order[i]])
+		if db.comparator.Compare(previous[1], next[0]) >= 0 {
+			return overlappingIngestedFilesErr
+		}
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	for _, table := range ingested {
+		if db.memtableOverlaps(ingestSpan(table)) {
+			err := flushMemtable(db)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	db.mu.Lock()
+	fileNums := make([]int, len(ingested))
+	for i := range fileNums {
+		fileNums[i] = db.nextFileNum
+		db.nextFileNum++
+	}
+	db.mu.Unlock()
+
+	err := db.commitIngestion(ingested, fileNums)
+	if err != nil {
+		return err
+	}
+	committed = true
+
+	// Only the names of the copies change from here on, so they're renamed before the tables are installed
+	for i, table := range ingested {
+		path := filepath.Join(db.tablesDir, fmt.Sprintf(ssTableFilename, fileNums[i]))
+		err := db.fs.Rename(table.path, path)
+		if err != nil {
+			return err
+		}
+
+		table.path = path
+		table.fileNum = fileNums[i]
+		readers[i].fileNum = fileNums[i]
+	}
+
+	// Every copy has been renamed, so the ingestion doesn't need to be finished after a crash
+	err = db.fs.Remove(filepath.Join(db.tablesDir, ingestCommitFilename))
+	if err != nil {
+		return err
+	}
+
+	// The tables are installed all at once (i.e. readers never see some of the files without the others)
+	tables := append([]*SSTable(nil), db.tables...)
+	for i, table := range ingested {
+		db.initTable(table, readers[i])
+
+		if db.tablesOverlap(tables, ingestSpan(table)) {
+			tables = append([]*SSTable{table}, tables...)
+		} else {
+			tables = append(tables, table)
+		}
+	}
+	db.mu.Lock()
+	db.tables = tables
+	db.mu.Unlock()
+	installed = true
+
+	return nil
+}
+
+// commitIngestion : Writes the INGEST file, which lists each copy along with the file number that it's installed
+// under (one "copy fileNum" pair per line). The INGEST file is written to a temporary file first and then renamed
+// (like the MANIFEST), which makes the rename the ingestion's single commit point: once the INGEST file exists, the
+// copies are installed even if the db crashes before they're all renamed (see finishIngestion)
+func (db *ClevelDB) commitIngestion(ingested []*SSTable, fileNums []int) error {
+	var b strings.Builder
+	for i, table := range ingested {
+		fmt.Fprintf(&b, "%s %d\n", filepath.Base(table.path), fileNums[i])
+	}
+
+	tmpPath := filepath.Join(db.tablesDir, ingestCommitFilename+".tmp")
+	err := writeFile(db.fs, tmpPath, []byte(b.String()))
+	if err != nil {
+		_ = db.fs.Remove(tmpPath)
+		return err
+	}
+
+	err = db.fs.Rename(tmpPath, filepath.Join(db.tablesDir, ingestCommitFilename))
+	if err != nil {
+		_ = db.fs.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// finishIngestion : Renames the copies of an ingestion that was committed (see commitIngestion), but not finished
+// before a crash, and then removes the INGEST file. The copies of an ingestion that wasn't committed are removed
+// along with the other temporary files (see loadSSTables)
+func finishIngestion(fs FS, tablesDir string) error {
+	_ = fs.Remove(filepath.Join(tablesDir, ingestCommitFilename+".tmp"))
+
+	commitPath := filepath.Join(tablesDir, ingestCommitFilename)
+	data, err := readFile(fs, commitPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		copyName, fileNumStr, _ := strings.Cut(line, " ")
+		fileNum, err := strconv.Atoi(fileNumStr)
+		if _, isCopy := scanFileNum(copyName, ingestFilename); err != nil || fileNum <= 0 || !isCopy {
+			return fmt.Errorf("corrupt INGEST line: %q", line)
+		}
+
+		// A copy that no longer exists was already renamed before the crash
+		copyPath := filepath.Join(tablesDir, copyName)
+		if !exists(fs, copyPath) {
+			continue
+		}
+		err = fs.Rename(copyPath, filepath.Join(tablesDir, fmt.Sprintf(ssTableFilename, fileNum)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return fs.Remove(commitPath)
+}
+
+// copyExternalFile : Copies the file into the tables directory (under a temporary name, so that the copy isn't
+// loaded if the db crashes before the ingestion is committed), and validates the copy
+func (db *ClevelDB) copyExternalFile(path string) (*SSTable, *tableReader, error) {
+	db.mu.Lock()
+	copyPath := filepath.Join(db.tablesDir, fmt.Sprintf(ingestFilename, db.nextFileNum))
+	db.nextFileNum++
+	db.mu.Unlock()
+
+	err := copyFile(db.fs, path, copyPath)
+	if err != nil {
+		_ = db.fs.Remove(copyPath)
+		return nil, nil, err
+	}
+
+	table, reader, err := loadSSTable(db.fs, copyPath)
+	if err != nil {
+		_ = db.fs.Remove(copyPath)
+		return nil, nil, err
+	}
+	table.cmp = db.comparator
+
+	// The table is returned even if it's invalid, so that the caller removes it
+	if table.smallest == nil && len(table.rangeTombstones) == 0 {
+		return table, reader, emptyTableErr
+	}
+	return table, reader, table.validate(reader, db.comparator)
+}
+
+// validate : Reads every block of the table (verifying its checksum), and checks that the table's keys (and its
+// index keys) are in increasing order according to the comparator
+func (ss *SSTable) validate(reader *tableReader, cmp Comparator) error {
+	ro := &ReadOptions{VerifyChecksums: true}
+
+	// A table that only contains range tombstones has no blocks
+	numPartitions := 0
+	if len(reader.index.blocks) > 0 {
+		numPartitions = reader.index.numPartitions()
+	}
+
+	var last []byte
+	for partitionIdx := 0; partitionIdx < numPartitions; partitionIdx++ {
+		blocks, err := ss.partition(reader, partitionIdx, ro)
+		if err != nil {
+			return err
+		}
+
+		// A partition's key is the key of its first block
+		if len(blocks) == 0 {
+			return corruptSSTableErr
+		}
+		if reader.index.partitioned && cmp.Compare(reader.index.blocks[partitionIdx].key, blocks[0].key) != 0 {
+			return unorderedKeysErr
+		}
+
+		for _, block := range blocks {
+			entries, err := reader.readBlock(block, true)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				return corruptSSTableErr
+			}
+
+			// A block's index key has to separate the block from the previous block
+			if (last != nil && cmp.Compare(block.key, last) <= 0) || cmp.Compare(block.key, entries[0].key) > 0 {
+				return unorderedKeysErr
+			}
+
+			for _, entry := range entries {
+				if last != nil && cmp.Compare(entry.key, last) <= 0 {
+					return unorderedKeysErr
+				}
+				last = entry.key
+			}
+		}
+	}
+
+	for _, tombstone := range ss.rangeTombstones {
+		if cmp.Compare(tombstone.start, tombstone.limit) >= 0 {
+			return unorderedKeysErr
+		}
+	}
+	return nil
+}
+
+// ingestSpan : Returns the smallest and largest keys that the table affects (including its range tombstones,
+// whose limits are treated as inclusive)
+func ingestSpan(ss *SSTable) [2][]byte {
+	span := [2][]byte{ss.smallest, ss.largest}
+	for _, tombstone := range ss.rangeTombstones {
+		if span[0] == nil || ss.cmp.Compare(tombstone.start, span[0]) < 0 {
+			span[0] = tombstone.start
+		}
+		if span[1] == nil || ss.cmp.Compare(tombstone.limit, span[1]) > 0 {
+			span[1] = tombstone.limit
+		}
+	}
+	return span
+}
+
+// memtableOverlaps : Returns true if the memtable has any keys (or range tombstones) within the span
+func (db *ClevelDB) memtableOverlaps(span [2][]byte) bool {
+	node, _ := db.memtable.Get(span[0])
+	if node != nil && db.comparator.Compare(node.key, span[1]) <= 0 {
+		return true
+	}
+
+	for _, tombstone := range db.memtable.rangeTombstones {
+		if db.comparator.Compare(tombstone.start, span[1]) <= 0 && db.comparator.Compare(tombstone.limit, span[0]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tablesOverlap : Returns true if any of the tables affect keys within the span
+func (db *ClevelDB) tablesOverlap(tables []*SSTable, span [2][]byte) bool {
+	for _, table := range tables {
+		other := ingestSpan(table)
+		if other[0] != nil && db.comparator.Compare(other[0], span[1]) <= 0 && db.comparator.Compare(other[1], span[0]) >= 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// writeExternalFile : Writes the key-value pairs (whose keys must be sorted) to a new SSTable file in the file
+// system, outside of any db
+func writeExternalFile(t *testing.T, fs FS, opts *Options, pairs ...string) string {
+	withFS := Options{}
+	if opts != nil {
+		withFS = *opts
+	}
+	withFS.FS = fs
+
+	names, _ := fs.List(".")
+	path := fmt.Sprintf("external_%d.ss", len(names))
+	writer, err := NewSSTableWriter(path, &withFS)
+	if err != nil {
+		t.Fatalf("error creating writer: %v", err)
+	}
+
+	for i := 0; i < len(pairs); i += 2 {
+		if err := writer.Put([]byte(pairs[i]), []byte(pairs[i+1])); err != nil {
+			t.Fatalf("error writing %q: %v", pairs[i], err)
+		}
+	}
+
+	if err := writer.Finish(); err != nil {
+		t.Fatalf("error finishing table: %v", err)
+	}
+	return path
+}
+
+func Test_SSTableWriterAndReaderRoundTrip(t *testing.T) {
+	for _, partitioned := range []bool{false, true} {
+		fs, path := NewMemFS(), "external.ss"
+		writer, _ := NewSSTableWriter(path, &Options{PartitionedIndex: partitioned, FS: fs})
+
+		for i := 0; i < 100; i++ {
+			if err := writer.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i))); err != nil {
+				t.Fatalf("SSTableWriter.Put returns unexpected error: %v", err)
+			}
+		}
+		if err := writer.Delete([]byte("key100")); err != nil {
+			t.Fatalf("SSTableWriter.Delete returns unexpected error: %v", err)
+		}
+		if err := writer.Put([]byte("key050"), []byte("out of order")); err != unorderedKeysErr {
+			t.Errorf("SSTableWriter.Put returns unexpected error for out of order key: %v", err)
+		}
+		if err := writer.Finish(); err != nil {
+			t.Fatalf("SSTableWriter.Finish returns unexpected error: %v", err)
+		}
+
+		reader, err := OpenSSTableReader(path, &Options{FS: fs})
+		if err != nil {
+			t.Fatalf("error opening reader: %v", err)
+		}
+
+		if string(reader.Smallest()) != "key000" || string(reader.Largest()) != "key100" {
+			t.Errorf(`SSTableReader returns unexpected bounds: "%s", "%s"`, reader.Smallest(), reader.Largest())
+		}
+		if val, err := reader.Get([]byte("key042")); string(val) != "42" || err != nil {
+			t.Errorf(`SSTableReader.Get("key042") returns unexpected value: "%s" (%v)`, val, err)
+		}
+		if _, err := reader.Get([]byte("key100")); err != deletedErr {
+			t.Errorf(`SSTableReader.Get("key100") returns unexpected error for deleted key: %v`, err)
+		}
+
+		iter := reader.NewIterator(&ReadOptions{LowerBound: []byte("key097")})
+		checkKeys(t, "SSTableReader.NewIterator", collectKeys(iter, iter.Next), []string{"key097=97", "key098=98", "key099=99", "key100="})
+		iter.Release()
+		reader.Close()
+	}
+}
+
+func Test_ClevelDBIngestsExternalFiles(t *testing.T) {
+	db := newTestClevelDB(t)
+
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("old"))
+	}
+	flushTestMemtable(t, db)
+	_ = db.Put([]byte("key005"), []byte("memtable"))
+	_ = db.Put([]byte("key100"), []byte("memtable"))
+
+	// The first file overlaps the memtable and the table, whereas the second file doesn't overlap anything
+	overlapping := writeExternalFile(t, db.fs, nil, "key005", "ingested", "key010", "ingested", "key050", "ingested")
+	separate := writeExternalFile(t, db.fs, nil, "key200", "ingested", "key201", "ingested")
+
+	if err := db.IngestExternalFile([]string{overlapping, separate}); err != nil {
+		t.Fatalf("IngestExternalFile returns unexpected error: %v", err)
+	}
+
+	// Ingested keys are more recent than the existing keys (including those that were in the memtable)
+	for key, expected := range map[string]string{
+		"key000": "old", "key005": "ingested", "key010": "ingested", "key050": "ingested", "key100": "memtable",
+		"key201": "ingested",
+	} {
+		if val, err := db.Get([]byte(key)); string(val) != expected || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value: "%s" (%v)`, key, val, err)
+		}
+	}
+
+	// The memtable was flushed first, the overlapping file is the most recent table, and the separate file is last
+	if len(db.tables) != 4 || db.memtable.size != 0 {
+		t.Fatalf("IngestExternalFile leaves unexpected tables: %v (memtable size: %v)", len(db.tables), db.memtable.size)
+	}
+	if string(db.tables[0].smallest) != "key005" || string(db.tables[3].smallest) != "key200" {
+		t.Errorf("IngestExternalFile places tables in unexpected order: %s, %s", db.tables[0].smallest, db.tables[3].smallest)
+	}
+
+	// The original files are left as is
+	if _, err := db.fs.Stat(overlapping); err != nil {
+		t.Errorf("IngestExternalFile removes the original file: %v", err)
+	}
+}
+
+func Test_ClevelDBIngestRejectsInvalidFiles(t *testing.T) {
+	db := newTestClevelDBWithComparator(t, ReverseBytewiseComparator{})
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	flushTestMemtable(t, db)
+
+	// Keys written in bytewise order are out of order for the db's (reverse) comparator
+	unordered := writeExternalFile(t, db.fs, nil, "b", "1", "c", "2")
+	if err := db.IngestExternalFile([]string{unordered}); !errors.Is(err, unorderedKeysErr) {
+		t.Errorf("IngestExternalFile returns unexpected error for unordered keys: %v", err)
+	}
+
+	first := writeExternalFile(t, db.fs, &Options{Comparator: ReverseBytewiseComparator{}}, "f", "1", "d", "2")
+	second := writeExternalFile(t, db.fs, &Options{Comparator: ReverseBytewiseComparator{}}, "e", "3")
+	if err := db.IngestExternalFile([]string{first, second}); err != overlappingIngestedFilesErr {
+		t.Errorf("IngestExternalFile returns unexpected error for overlapping files: %v", err)
+	}
+
+	// Nothing is installed (and no copies are left behind) when ingestion fails
+	if len(db.tables) != 1 {
+		t.Errorf("IngestExternalFile installs tables after failing: %v", len(db.tables))
+	}
+	if entries, _ := db.fs.List(db.tablesDir); len(entries) != 1 {
+		t.Errorf("IngestExternalFile leaves unexpected files after failing: %v", entries)
+	}
+	if _, err := db.Get([]byte("e")); err != notFoundInDBErr {
+		t.Errorf(`storage.Get("e") returns unexpected error after failed ingestion: %v`, err)
+	}
+}
+
+func Test_ClevelDBIngestsAllFilesOrNoneAfterCrash(t *testing.T) {
+	// The file system crashes after n changes, for every n until the ingestion succeeds
+	for n := 0; ; n++ {
+		fs := NewFaultFS(NewMemFS())
+		db := loadTestFaultDB(t, fs)
+		_ = db.Put([]byte("key005"), []byte("memtable"))
+		first := writeExternalFile(t, fs, nil, "key005", "ingested", "key010", "ingested")
+		second := writeExternalFile(t, fs, nil, "key200", "ingested")
+
+		fs.CrashAfter(n)
+		ingestErr := db.IngestExternalFile([]string{first, second})
+		_ = db.Close()
+		if err := fs.DropUnsyncedData(); err != nil {
+			t.Fatalf("DropUnsyncedData returns unexpected error: %v", err)
+		}
+
+		db = loadTestFaultDB(t, fs)
+		firstVal, _ := db.Get([]byte("key010"))
+		secondVal, _ := db.Get([]byte("key200"))
+		if (firstVal == nil) != (secondVal == nil) || (ingestErr == nil && firstVal == nil) {
+			t.Errorf("crash after %d changes leaves partial ingestion: %q, %q (%v)", n, firstVal, secondVal, ingestErr)
+		}
+
+		expected := "memtable"
+		if firstVal != nil {
+			expected = "ingested"
+		}
+		if val, err := db.Get([]byte("key005")); string(val) != expected || err != nil {
+			t.Errorf(`storage.Get("key005") returns unexpected value after crash %d: "%s" (%v)`, n, val, err)
+		}
+
+		// The copies of an ingestion that wasn't committed are removed, along with the INGEST file
+		names, _ := fs.List(db.tablesDir)
+		for _, name := range names {
+			if _, isCopy := scanFileNum(name, ingestFilename); isCopy || strings.HasPrefix(name, ingestCommitFilename) {
+				t.Errorf("loadClevelDB leaves ingestion file after crash %d: %s", n, name)
+			}
+		}
+
+		_ = db.Close()
+		if ingestErr == nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+const (
+	forward = iota
+	reverse
+)
+
+// inRange : Returns true if start <= key < limit (a nil start or limit leaves that side of the range unbounded)
+func inRange(cmp Comparator, key, start, limit []byte) bool {
+	return (start == nil || cmp.Compare(key, start) >= 0) && (limit == nil || cmp.Compare(key, limit) < 0)
+}
+
+// RangeScan : Scans for values across the memtables and all SStables
+// Returns an iterator positioned at the first key within [start, limit)
+func (db *ClevelDB) RangeScan(start, limit []byte) (Iterator, error) {
+	defer db.metrics.rangeScanLatency.observeSince(time.Now())
+
+	iterator := db.NewIterator(&ReadOptions{LowerBound: start, UpperBound: limit, FillCache: true})
+	return iterator, iterator.Error()
+}
+
+// NewIterator : Returns an iterator over the keys within [ro.LowerBound, ro.UpperBound), positioned at the first key
+// The iterator holds a reference to each SSTable it reads from, so it should be released once it's no longer needed
+func (db *ClevelDB) NewIterator(ro *ReadOptions) Iterator {
+	if ro == nil {
+		ro = defaultReadOptions()
+	}
+
+	// The tables returned by current are already referenced, while a snapshot's tables are referenced again (so that
+	// the iterator can outlive the snapshot)
+	var memtables []*Memtable
+	var tables []*SSTable
+	if ro.Snapshot != nil {
+		memtables, tables = ro.Snapshot.memtables, ro.Snapshot.tables
+		for _, table := range tables {
+			table.ref()
+		}
+	} else {
+		memtables, tables = db.current()
+	}
+
+	// Range tombstones from more recent memtables and tables, which will hide any keys they cover in older ones
+	var tombstones []rangeTombstone
+
+	// Add memtable iterators (newest first)
+	var iterators []Iterator
+	for _, memtable := range memtables {
+		var iterator Iterator = memtable.newIterator(ro.LowerBound, ro.UpperBound)
+		if len(tombstones) > 0 {
+			iterator = &rangeDelIterator{Iterator: iterator, tombstones: tombstones, cmp: db.comparator}
+		}
+		iterators = append(iterators, iterator)
+
+		tombstones = append(tombstones[:len(tombstones):len(tombstones)], memtable.tombstones()...)
+	}
+
+	// Add sstable iterators
+	var referenced []*SSTable
+	for _, table := range tables {
+		// Tables without any keys within the bounds (including tables that only contain range tombstones) are skipped
+		if table.overlaps(ro.LowerBound, ro.UpperBound) {
+			referenced = append(referenced, table)
+			iterators = append(iterators, &rangeDelIterator{Iterator: table.newIterator(ro), tombstones: tombstones, cmp: db.comparator})
+		} else {
+			table.unref()
+		}
+
+		tombstones = append(tombstones[:len(tombstones):len(tombstones)], table.rangeTombstones...)
+	}
+
+	iterator := &ClevelIterator{
+		db:        db,
+		iterators: iterators,
+		tables:    referenced,
+		heap:      &iteratorHeap{iterators: iterators, cmp: db.comparator},
+		current:   -1,
+	}
+	iterator.First()
+
+	return iterator
+}
+
+// ClevelIterator : Merges the iterators of the memtables and each SSTable (which are ordered from most to least
+// recent), and only exposes the most recent version of each key (skipping keys whose most recent version is a tombstone)
+//
+// While moving forward, every iterator is positioned at a key >= the current key (and kept in a min-heap), and while
+// moving backward, every iterator is positioned at a key <= the current key (and kept in a max-heap). So, changing
+// direction requires repositioning every iterator and rebuilding the heap.
+type ClevelIterator struct {
+	db        *ClevelDB
+	iterators []Iterator
+	tables    []*SSTable // the tables referenced by the iterator (until it's released)
+	heap      *iteratorHeap
+	current   int    // the most recent iterator positioned at the current key (-1 if not positioned at a key)
+	value     []byte // the current key's value (with any merge operands folded in)
+	direction int
+	err       error
+}
+
+func (i *ClevelIterator) First() bool {
+	for _, iterator := range i.iterators {
+		iterator.First()
+	}
+
+	i.direction = forward
+	i.initHeap()
+	return i.skipTombstones()
+}
+
+func (i *ClevelIterator) Last() bool {
+	for _, iterator := range i.iterators {
+		iterator.Last()
+	}
+
+	i.direction = reverse
+	i.initHeap()
+	return i.skipTombstones()
+}
+
+// Seek : Moves to the first key that is greater than or equal to the given key
+func (i *ClevelIterator) Seek(key []byte) bool {
+	for _, iterator := range i.iterators {
+		iterator.Seek(key)
+	}
+
+	i.direction = forward
+	i.initHeap()
+	return i.skipTombstones()
+}
+
+// Next : Moves to the next key that isn't a tombstone (i.e. deleted or expired keys are skipped)
+func (i *ClevelIterator) Next() bool {
+	if !i.Valid() {
+		return false
+	}
+
+	i.next()
+	return i.skipTombstones()
+}
+
+// Prev : Moves to the previous key that isn't a tombstone
+func (i *ClevelIterator) Prev() bool {
+	if !i.Valid() {
+		return false
+	}
+
+	i.prev()
+	return i.skipTombstones()
+}
+
+// next : Moves every iterator past the current key (i.e. older versions of the current key are skipped too)
+func (i *ClevelIterator) next() {
+	key := i.Key()
+
+	// Every iterator is positioned at or before the current key, so they need to be moved to (or after) it first
+	if i.direction != forward {
+		for _, iterator := range i.iterators {
+			iterator.Seek(key)
+		}
+		i.direction = forward
+		i.initHeap()
+	}
+
+	i.advanceHeap(key, Iterator.Next)
+}
+
+// prev : Moves every iterator before the current key
+func (i *ClevelIterator) prev() {
+	key := i.Key()
+
+	// Every iterator is positioned at or after the current key, so they need to be moved to (or before) it first
+	if i.direction != reverse {
+		for _, iterator := range i.iterators {
+			if !iterator.Seek(key) {
+				// Every key in the iterator is smaller than the current key
+				iterator.Last()
+			} else if i.db.comparator.Compare(iterator.Key(), key) != 0 {
+				iterator.Prev()
+			}
+		}
+		i.direction = reverse
+		i.initHeap()
+	}
+
+	i.advanceHeap(key, Iterator.Prev)
+}
+
+// initHeap : Rebuilds the heap from every valid iterator (in the current direction)
+func (i *ClevelIterator) initHeap() {
+	i.heap.reverse = i.direction == reverse
+	i.heap.items = i.heap.items[:0]
+
+	for idx, iterator := range i.iterators {
+		if iterator.Valid() {
+			i.heap.items = append(i.heap.items, idx)
+		}
+	}
+
+	heap.Init(i.heap)
+	i.current = i.heap.top()
+}
+
+// advanceHeap : Moves every iterator positioned at the key (i.e. at the top of the heap), and pushes each of
+// them back onto the heap if they're still valid
+func (i *ClevelIterator) advanceHeap(key []byte, move func(Iterator) bool) {
+	for i.heap.Len() > 0 {
+		idx := i.heap.items[0]
+		if i.db.comparator.Compare(i.iterators[idx].Key(), key) != 0 {
+			break
+		}
+
+		if move(i.iterators[idx]) {
+			// The iterator's key changed, so it needs to be moved down to its new position in the heap
+			heap.Fix(i.heap, 0)
+		} else {
+			heap.Pop(i.heap)
+		}
+	}
+
+	i.current = i.heap.top()
+}
+
+// skipTombstones : Resolves the current key's value, and keeps moving (in the current direction) until reaching
+// a key whose value isn't a tombstone
+func (i *ClevelIterator) skipTombstones() bool {
+	for i.Valid() {
+		val, err := i.resolve()
+		if err != nil {
+			i.err = err
+			i.current = -1
+			return false
+		}
+
+		if val != nil {
+			i.value = val
+			return true
+		}
+
+		if i.direction == forward {
+			i.next()
+		} else {
+			i.prev()
+		}
+	}
+
+	return false
+}
+
+// resolve : Returns the current key's value (or nil if it's a tombstone)
+// If the most recent version of the key is a merge, the operands are collected from the older iterators (which are
+// also positioned at the current key) until the key's value is found
+func (i *ClevelIterator) resolve() ([]byte, error) {
+	key := i.Key()
+
+	// Operands are ordered from oldest to newest (so each older iterator's operands are prepended)
+	var operands [][]byte
+
+	for _, iterator := range i.iterators[i.current:] {
+		if !iterator.Valid() || i.db.comparator.Compare(iterator.Key(), key) != 0 {
+			continue
+		}
+
+		mergeIterator, ok := iterator.(mergeOperandIterator)
+		if !ok || mergeIterator.operands() == nil {
+			return i.fullMerge(key, iterator.Value(), operands)
+		}
+
+		// The iterator's operands are copied, since a memtable iterator returns the memtable's own slice
+		operands = append(append([][]byte(nil), mergeIterator.operands()...), operands...)
+	}
+
+	return i.fullMerge(key, nil, operands)
+}
+
+// fullMerge : Same as ClevelDB.fullMerge, except tombstones are returned as nil (rather than notFoundInDBErr)
+func (i *ClevelIterator) fullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	val, err := i.db.fullMerge(key, existing, operands)
+	if err == notFoundInDBErr {
+		return nil, nil
+	}
+	return val, err
+}
+
+func (i *ClevelIterator) Valid() bool {
+	return i.current >= 0
+}
+
+func (i *ClevelIterator) Release() {
+	for _, iterator := range i.iterators {
+		iterator.Release()
+	}
+	i.current = -1
+
+	for _, table := range i.tables {
+		table.unref()
+	}
+	i.tables = nil
+}
+
+func (i *ClevelIterator) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+
+	for _, iterator := range i.iterators {
+		if err := iterator.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *ClevelIterator) Key() []byte {
+	if !i.Valid() {
+		return nil
+	}
+	return i.iterators[i.current].Key()
+}
+
+// Value : Returns the current key's value (with any merge operands folded in)
+func (i *ClevelIterator) Value() []byte {
+	if !i.Valid() {
+		return nil
+	}
+	return i.value
+}
+
+// iteratorHeap : A heap of (positions of) iterators, ordered by the iterators' current keys
+// In the event of a tie, the most recent iterator (i.e. the one with the smaller position) comes first
+type iteratorHeap struct {
+	iterators []Iterator
+	items     []int
+	reverse   bool // true for a max-heap (when moving backward)
+	cmp       Comparator
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *iteratorHeap) Less(a, b int) bool {
+	cmp := h.cmp.Compare(h.iterators[h.items[a]].Key(), h.iterators[h.items[b]].Key())
+	if cmp == 0 {
+		return h.items[a] < h.items[b]
+	}
+
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(a, b int) {
+	h.items[a], h.items[b] = h.items[b], h.items[a]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	h.items = append(h.items, x.(int))
+}
+
+func (h *iteratorHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// top : Returns the position of the iterator at the top of the heap (or -1 if the heap is empty)
+func (h *iteratorHeap) top() int {
+	if len(h.items) == 0 {
+		return -1
+	}
+	return h.items[0]
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// collectKeys : Returns every key (and value) visited by repeatedly calling move, starting at the current key
+func collectKeys(iter Iterator, move func() bool) []string {
+	var actual []string
+	for ok := iter.Valid(); ok; ok = move() {
+		actual = append(actual, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+	}
+	return actual
+}
+
+func checkKeys(t *testing.T, description string, actual, expected []string) {
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("%s returns unexpected keys-- Expected: %v, Actual: %v", description, expected, actual)
+	}
+}
+
+func Test_MemtableIteratorMovesInBothDirections(t *testing.T) {
+	mem := newMemtable(BytewiseComparator{})
+	for i := 0; i < 100; i++ {
+		mem.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)), 0)
+	}
+
+	iter, _ := mem.RangeScan([]byte("key010"), []byte("key014"))
+	checkKeys(t, "MemtableIterator.Next", collectKeys(iter, iter.Next), []string{"key010=10", "key011=11", "key012=12", "key013=13"})
+
+	iter.Last()
+	checkKeys(t, "MemtableIterator.Prev", collectKeys(iter, iter.Prev), []string{"key013=13", "key012=12", "key011=11", "key010=10"})
+
+	iter.Seek([]byte("key0115"))
+	checkKeys(t, "MemtableIterator.Seek", collectKeys(iter, iter.Next), []string{"key012=12", "key013=13"})
+
+	// An unbounded iterator covers every key
+	iter, _ = mem.RangeScan(nil, nil)
+	iter.Last()
+	if string(iter.Key()) != "key099" || len(collectKeys(iter, iter.Prev)) != 100 {
+		t.Errorf(`MemtableIterator.Last returns unexpected key: "%s"`, iter.Key())
+	}
+}
+
+func Test_SSIteratorMovesInBothDirectionsAcrossBlocks(t *testing.T) {
+	db := newTestClevelDB(t)
+	for i := 0; i < 100; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+	}
+	flushTestMemtable(t, db)
+
+	if len(acquireTestReader(t, db.tables[0]).index.blocks) < 2 {
+		t.Fatalf("expected the table to have multiple blocks")
+	}
+
+	iter, _ := db.tables[0].RangeScan([]byte("key040"), []byte("key046"))
+	checkKeys(t, "SSIterator.Next", collectKeys(iter, iter.Next), []string{"key040=40", "key041=41", "key042=42", "key043=43", "key044=44", "key045=45"})
+
+	iter.Last()
+	checkKeys(t, "SSIterator.Prev", collectKeys(iter, iter.Prev), []string{"key045=45", "key044=44", "key043=43", "key042=42", "key041=41", "key040=40"})
+
+	iter.Seek([]byte("key0425"))
+	checkKeys(t, "SSIterator.Seek", collectKeys(iter, iter.Next), []string{"key043=43", "key044=44", "key045=45"})
+
+	iter, _ = db.tables[0].RangeScan(nil, nil)
+	iter.Last()
+	if string(iter.Key()) != "key099" || len(collectKeys(iter, iter.Prev)) != 100 {
+		t.Errorf(`SSIterator.Last returns unexpected key: "%s"`, iter.Key())
+	}
+}
+
+func Test_ClevelIteratorMergesTablesInBothDirections(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.mergeOperator = StringAppendOperator{Delimiter: []byte(",")}
+
+	_ = db.Put([]byte("a"), []byte("1"))
+	_ = db.Put([]byte("b"), []byte("1"))
+	_ = db.Put([]byte("c"), []byte("1"))
+	_ = db.Put([]byte("e"), []byte("1"))
+	flushTestMemtable(t, db)
+
+	_ = db.Put([]byte("b"), []byte("2"))
+	_ = db.Delete([]byte("c"))
+	_ = db.Put([]byte("d"), []byte("2"))
+	_ = db.Merge([]byte("e"), []byte("2"))
+	flushTestMemtable(t, db)
+
+	_ = db.Delete([]byte("a"))
+	_ = db.Put([]byte("c"), []byte("3"))
+	_ = db.Delete([]byte("d"))
+	_ = db.Merge([]byte("e"), []byte("3"))
+	_ = db.Put([]byte("f"), []byte("3"))
+
+	expected := []string{"b=2", "c=3", "e=1,2,3", "f=3"}
+
+	iter, _ := db.RangeScan(nil, nil)
+	checkKeys(t, "ClevelIterator.Next", collectKeys(iter, iter.Next), expected)
+
+	iter.Last()
+	checkKeys(t, "ClevelIterator.Prev", collectKeys(iter, iter.Prev), []string{"f=3", "e=1,2,3", "c=3", "b=2"})
+
+	// Changing direction in the middle of the range
+	iter.Seek([]byte("d"))
+	if string(iter.Key()) != "e" || !iter.Prev() || string(iter.Key()) != "c" || !iter.Prev() || string(iter.Key()) != "b" {
+		t.Errorf(`ClevelIterator.Prev (after Seek) returns unexpected key: "%s"`, iter.Key())
+	}
+
+	if !iter.Next() || string(iter.Key()) != "c" || !iter.Next() || string(iter.Key()) != "e" {
+		t.Errorf(`ClevelIterator.Next (after Prev) returns unexpected key: "%s"`, iter.Key())
+	}
+
+	if iter.Prev(); string(iter.Key()) != "c" {
+		t.Errorf(`ClevelIterator.Prev (after Next) returns unexpected key: "%s"`, iter.Key())
+	}
+
+	// Every key (and every version of it) is deleted before "b"
+	iter.Seek([]byte("a"))
+	if iter.Prev() || iter.Valid() {
+		t.Errorf(`ClevelIterator.Prev returns unexpected key: "%s"`, iter.Key())
+	}
+
+	iter, _ = db.RangeScan([]byte("c"), []byte("e"))
+	checkKeys(t, "ClevelIterator.Next (within range)", collectKeys(iter, iter.Next), []string{"c=3"})
+}
+
+func Test_ClevelIteratorRespectsBoundsAndSkipsTables(t *testing.T) {
+	db := newTestClevelDB(t)
+
+	_ = db.Put([]byte("a"), []byte("1"))
+	_ = db.Put([]byte("b"), []byte("1"))
+	flushTestMemtable(t, db)
+
+	_ = db.Put([]byte("c"), []byte("2"))
+	_ = db.Put([]byte("d"), []byte("2"))
+	flushTestMemtable(t, db)
+
+	_ = db.Put([]byte("e"), []byte("3"))
+
+	tests := []struct {
+		lower, upper []byte
+		expected     []string
+		numTables    int
+	}{
+		{nil, nil, []string{"a=1", "b=1", "c=2", "d=2", "e=3"}, 2},
+		{[]byte("b"), []byte("d"), []string{"b=1", "c=2"}, 2},
+		{[]byte("c"), nil, []string{"c=2", "d=2", "e=3"}, 1},
+		{nil, []byte("c"), []string{"a=1", "b=1"}, 1},
+		{[]byte("bb"), []byte("bc"), nil, 0},
+	}
+
+	for _, test := range tests {
+		iter := db.NewIterator(&ReadOptions{LowerBound: test.lower, UpperBound: test.upper})
+		description := fmt.Sprintf("NewIterator([%s, %s))", test.lower, test.upper)
+
+		checkKeys(t, description, collectKeys(iter, iter.Next), test.expected)
+
+		iter.Last()
+		reversed := collectKeys(iter, iter.Prev)
+		for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+			reversed[i], reversed[j] = reversed[j], reversed[i]
+		}
+		checkKeys(t, description+" (in reverse)", reversed, test.expected)
+
+		// Tables without any keys within the bounds shouldn't be read at all (the memtable is always included)
+		if actual := len(iter.(*ClevelIterator).iterators) - 1; actual != test.numTables {
+			t.Errorf("%s reads unexpected number of tables-- Expected: %v, Actual: %v", description, test.numTables, actual)
+		}
+		iter.Release()
+	}
+}
+
+func Test_ClevelIteratorVerifiesChecksums(t *testing.T) {
+	db := newTestClevelDB(t)
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	flushTestMemtable(t, db)
+
+	// Corrupt the last byte of the value
+	reader := acquireTestReader(t, db.tables[0])
+	block := reader.index.blocks[0]
+	_, err := reader.file.WriteAt([]byte("x"), block.offset+block.size-1)
+	if err != nil {
+		t.Fatalf("error corrupting table: %v", err)
+	}
+
+	iter := db.NewIterator(&ReadOptions{VerifyChecksums: true})
+	if iter.Valid() || iter.Error() != checksumMismatchErr {
+		t.Errorf("NewIterator returns unexpected err: %v", iter.Error())
+	}
+
+	iter = db.NewIterator(nil)
+	if !iter.Valid() || string(iter.Value()) != "cassix" {
+		t.Errorf(`NewIterator returns unexpected value: "%s"`, iter.Value())
+	}
+}
+
+// Test_ClevelDBMatchesNaiveDBModel : Runs a random workload against both ClevelDB and NaiveDB (which serves as a
+// model of the expected behavior), and then checks that every read from ClevelDB matches the model
+func Test_ClevelDBMatchesNaiveDBModel(t *testing.T) {
+	seed := time.Now().UnixNano()
+	rng := rand.New(rand.NewSource(seed))
+
+	randomKey := func() []byte {
+		return []byte(fmt.Sprintf("key%03d", rng.Intn(200)))
+	}
+
+	for round := 0; round < 10; round++ {
+		db := newTestClevelDB(t)
+		db.mergeOperator = StringAppendOperator{Delimiter: []byte(",")}
+		db.partitionedIndex = round%2 == 1
+		model := newNaiveDB()
+
+		for op := 0; op < 2000; op++ {
+			key := randomKey()
+			val := []byte(fmt.Sprint(rng.Intn(1000)))
+
+			switch r := rng.Intn(100); {
+			case r < 55:
+				_ = db.Put(key, val)
+				_ = model.Put(key, val)
+			case r < 80:
+				_ = db.Delete(key)
+				_ = model.Delete(key)
+			case r < 95:
+				_ = db.Merge(key, val)
+				if existing, err := model.Get(key); err == nil {
+					val = []byte(string(existing) + "," + string(val))
+				}
+				_ = model.Put(key, val)
+			default:
+				start, limit := key, randomKey()
+				if string(start) > string(limit) {
+					start, limit = limit, start
+				}
+
+				_ = db.DeleteRange(start, limit)
+				for modelKey := range model.storage {
+					if modelKey >= string(start) && modelKey < string(limit) {
+						_ = model.Delete([]byte(modelKey))
+					}
+				}
+			}
+
+			// Flush regularly (so that keys are spread across many tables), and occasionally compact
+			if op%100 == 99 {
+				flushTestMemtable(t, db)
+			}
+			if op%700 == 699 {
+				if err := db.Compact(); err != nil {
+					t.Fatalf("error compacting: %v", err)
+				}
+			}
+		}
+
+		checkMatchesModel(t, rng, db, model, randomKey)
+		if t.Failed() {
+			t.Fatalf("ClevelDB doesn't match the model (seed: %d, round: %d)", seed, round)
+		}
+	}
+}
+
+func checkMatchesModel(t *testing.T, rng *rand.Rand, db *ClevelDB, model *NaiveDB, randomKey func() []byte) {
+	// Full scans in both directions
+	iter, _ := db.RangeScan(nil, nil)
+	modelIter, _ := model.RangeScan(nil, nil)
+	modelIter.First()
+	checkKeys(t, "ClevelIterator.Next", collectKeys(iter, iter.Next), collectKeys(modelIter, modelIter.Next))
+
+	iter.Last()
+	modelIter.Last()
+	checkKeys(t, "ClevelIterator.Prev", collectKeys(iter, iter.Prev), collectKeys(modelIter, modelIter.Prev))
+
+	// Range scans
+	for i := 0; i < 20; i++ {
+		start, limit := randomKey(), randomKey()
+		if string(start) > string(limit) {
+			start, limit = limit, start
+		}
+
+		iter, _ := db.RangeScan(start, limit)
+		modelIter, _ := model.RangeScan(start, limit)
+		modelIter.First()
+		checkKeys(t, fmt.Sprintf("RangeScan(%s, %s)", start, limit), collectKeys(iter, iter.Next), collectKeys(modelIter, modelIter.Next))
+	}
+
+	// Random walks (which frequently change direction)
+	for i := 0; i < 20; i++ {
+		key := randomKey()
+		iter.Seek(key)
+		modelIter.Seek(key)
+
+		for step := 0; step < 50 && iter.Valid() && modelIter.Valid(); step++ {
+			if string(iter.Key()) != string(modelIter.Key()) || string(iter.Value()) != string(modelIter.Value()) {
+				t.Errorf(`random walk from "%s" returns unexpected key/value at step %d-- Expected: "%s: %s", Actual: "%s: %s"`,
+					key, step, modelIter.Key(), modelIter.Value(), iter.Key(), iter.Value())
+				break
+			}
+
+			if rng.Intn(2) == 0 {
+				iter.Next()
+				modelIter.Next()
+			} else {
+				iter.Prev()
+				modelIter.Prev()
+			}
+		}
+
+		if iter.Valid() != modelIter.Valid() {
+			t.Errorf(`random walk from "%s" returns unexpected validity: %v`, key, iter.Valid())
+		}
+	}
+
+	// Point lookups (for every key, including those that don't exist), both one at a time and all at once
+	var keys [][]byte
+	for i := 0; i < 200; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key%03d", i)))
+	}
+	multiGetValues, multiGetErrs := db.MultiGet(keys)
+
+	for i, key := range keys {
+		actualValue, actualErr := db.Get(key)
+		expectedValue, expectedErr := model.Get(key)
+
+		if string(actualValue) != string(expectedValue) || actualErr != expectedErr {
+			t.Errorf(`storage.Get("%s") returns unexpected value-- Expected: "%s" (%v), Actual: "%s" (%v)`,
+				key, expectedValue, expectedErr, actualValue, actualErr)
+		}
+
+		if string(multiGetValues[i]) != string(expectedValue) || multiGetErrs[i] != expectedErr {
+			t.Errorf(`storage.MultiGet returns unexpected value for "%s"-- Expected: "%s" (%v), Actual: "%s" (%v)`,
+				key, expectedValue, expectedErr, multiGetValues[i], multiGetErrs[i])
+		}
+	}
+}
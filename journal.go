@@ -5,55 +5,236 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"path/filepath"
+	"sort"
 )
 
 const (
 	Delete uint8 = iota
 	Insert
+	InsertWithTTL
+	DeleteRange
+	Merge
 )
 
-func writeKeyValPairToFile(file *os.File, key, val []byte, sync bool) (int, error) {
-	var toAppend []byte
-	var op uint8
+// writeKeyValPairToFile : Appends a single record to the file (used by both the journal and SSTables)
+func writeKeyValPairToFile(file File, key, val []byte, expiresAt int64, sync bool) (int, error) {
+	return writeRecordToFile(file, keyValOp(val, expiresAt), key, val, expiresAt, sync)
+}
 
-	if val != nil {
-		op = Insert
-	} else {
-		op = Delete
+// keyValOp : Returns the op of the record that stores a key-value pair (a nil value is a tombstone)
+func keyValOp(val []byte, expiresAt int64) uint8 {
+	if val == nil {
+		return Delete
+	} else if expiresAt != 0 {
+		return InsertWithTTL
+	}
+	return Insert
+}
+
+// writeRecordToFile : Records are encoded as: op | key length | key | value length | value | expiry
+// Deletes have no value, and only InsertWithTTL records have the (8-byte) expiry timestamp
+// DeleteRange records store the start of the range as the key and the limit as the value
+// Merge records store their (encoded) list of operands as the value
+func writeRecordToFile(file File, op uint8, key, val []byte, expiresAt int64, sync bool) (int, error) {
+	n, err := file.Write(encodeRecord(op, key, val, expiresAt))
+	if err != nil {
+		return 0, errors.New("error writing to file")
+	}
+
+	if sync {
+		err = file.Sync()
+		if err != nil {
+			return 0, errors.New("error syncing file")
+		}
 	}
 
+	return n, nil
+}
+
+func encodeRecord(op uint8, key, val []byte, expiresAt int64) []byte {
+	var toAppend []byte
+
 	toAppend = append(toAppend, op)
 
 	toAppend = binary.BigEndian.AppendUint16(toAppend, uint16(len(key)))
 	toAppend = append(toAppend, key...)
 
-	if op == Insert {
+	if op != Delete {
 		toAppend = binary.BigEndian.AppendUint16(toAppend, uint16(len(val)))
 		toAppend = append(toAppend, val...)
 	}
 
-	n, err := file.Write(toAppend)
+	if op == InsertWithTTL {
+		toAppend = binary.BigEndian.AppendUint64(toAppend, uint64(expiresAt))
+	}
+
+	return toAppend
+}
+
+// journalPath : Returns the path of the journal with the given number
+func (db *ClevelDB) journalPath(journalNum int) string {
+	return filepath.Join(db.dir, fmt.Sprintf(journalFilename, journalNum))
+}
+
+// listJournals : Returns the numbers of the journals in the directory (in ascending order, i.e. oldest first)
+func listJournals(fs FS, dir string) ([]int, error) {
+	filenames, err := fs.List(dir)
 	if err != nil {
-		return 0, errors.New("error writing to file")
+		return nil, err
 	}
 
-	if sync {
-		err = file.Sync()
+	var journalNums []int
+	for _, filename := range filenames {
+		if journalNum, ok := scanFileNum(filename, journalFilename); ok {
+			journalNums = append(journalNums, journalNum)
+		}
+	}
+
+	sort.Ints(journalNums)
+	return journalNums, nil
+}
+
+// newJournal : Creates the journal of a new memtable (each memtable has its own journal, which is removed once the
+// memtable has been flushed, see removeJournal). Journals are numbered from the same sequence as the tables, so a
+// more recent journal always has a larger number. Must be called with db.mu held
+func (db *ClevelDB) newJournal() (File, int, error) {
+	journalNum := db.nextFileNum
+	file, err := db.fs.Create(db.journalPath(journalNum))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	db.nextFileNum++
+	return syncTimingFile{File: file, latency: &db.metrics.fsyncLatency}, journalNum, nil
+}
+
+// switchMemtable : Replaces the (full) memtable with an empty one that has a new journal, and returns the full
+// memtable, whose journal is kept until it has been flushed. Must be called with db.mu held
+func (db *ClevelDB) switchMemtable() (*Memtable, error) {
+	mem := newMemtable(db.comparator)
+	if db.journal {
+		file, journalNum, err := db.newJournal()
 		if err != nil {
-			return 0, errors.New("error syncing file")
+			return nil, err
+		}
+
+		// Every write to the full memtable's journal has already been synced, so it's only closed (a new db doesn't
+		// have a journal yet)
+		if db.journalFile != nil {
+			_ = db.journalFile.Close()
 		}
+		db.journalFile = file
+		mem.journalNum = journalNum
 	}
 
-	return n, nil
+	full := db.memtable
+	db.memtable = mem
+	return full, nil
+}
+
+// removeJournal : Removes the journal of a memtable that has been flushed (the memtable's table must already be
+// installed, see installTableFile)
+func (db *ClevelDB) removeJournal(mem *Memtable) error {
+	if mem.journalNum == 0 {
+		return nil
+	}
+	return db.fs.Remove(db.journalPath(mem.journalNum))
+}
+
+// recoverJournals : Replays each journal that hasn't been flushed yet (oldest first) into its own memtable
+// The most recent journal's memtable becomes the db's memtable (and its journal is appended to), while the older
+// memtables are flushed in the background once the db is loaded
+// A journal whose records are already in a table (i.e. the db crashed after the table was installed, but before the
+// journal was removed) is removed rather than replayed, so that its records (e.g. merge operands) aren't applied twice
+func (db *ClevelDB) recoverJournals() error {
+	journalNums, err := listJournals(db.fs, db.dir)
+	if err != nil {
+		return err
+	}
+
+	flushedJournalNum := 0
+	for _, table := range db.tables {
+		if table.journalNum > flushedJournalNum {
+			flushedJournalNum = table.journalNum
+		}
+	}
+
+	db.recovering = true
+	defer func() { db.recovering = false }()
+
+	var journalFile File
+	for _, journalNum := range journalNums {
+		if journalNum >= db.nextFileNum {
+			db.nextFileNum = journalNum + 1
+		}
+
+		if journalNum <= flushedJournalNum {
+			err = db.fs.Remove(db.journalPath(journalNum))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// The previous journal's memtable is complete, since a more recent journal was created after it
+		if journalFile != nil {
+			_ = journalFile.Close()
+			db.immutables = append(db.immutables, db.memtable)
+		}
+
+		journalFile, err = db.fs.OpenAppend(db.journalPath(journalNum))
+		if err != nil {
+			return err
+		}
+		db.memtable = newMemtable(db.comparator)
+		db.memtable.journalNum = journalNum
+
+		err = db.replayJournal(journalFile)
+		if err != nil {
+			_ = journalFile.Close()
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if journalFile == nil {
+		_, err = db.switchMemtable()
+		return err
+	}
+
+	db.journalFile = syncTimingFile{File: journalFile, latency: &db.metrics.fsyncLatency}
+	db.scheduleBackgroundWork()
+	return nil
 }
 
-func recoverMemtable(journalFile *os.File) *ClevelDB {
-	db := newClevelDB(false, journalFile)
+// replayJournal : Replays the journal into the memtable (returns corruptJournalErr if the journal is corrupt)
+// A record that was only partially written (i.e. the journal ends in the middle of it) was never acknowledged, since
+// every write is synced before it returns, so the journal is truncated after its last complete record
+func (db *ClevelDB) replayJournal(journalFile File) error {
+	// The records that are replayed are already in the journal
+	journal := db.journal
+	db.journal = false
+	defer func() { db.journal = journal }()
 
 	op := make([]byte, 1)
 	keyLen := make([]byte, 2)
 	valLen := make([]byte, 2)
+	expiresAtBytes := make([]byte, 8)
+
+	// The offset after the last complete record
+	var offset int64
+
+	// read : Fills the buffer (a single Read can return fewer bytes than requested)
+	read := func(buf []byte) error {
+		_, err := io.ReadFull(journalFile, buf)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
 
 	for {
 		_, err := journalFile.Read(op)
@@ -61,59 +242,116 @@ func recoverMemtable(journalFile *os.File) *ClevelDB {
 			break
 		} else if err != nil {
 			fmt.Printf("Error reading op: %v\n", err)
-			return nil
+			return corruptJournalErr
 		}
 
-		_, err = journalFile.Read(keyLen)
-		if err != nil {
+		// The rest of a record with an unknown op can't be read, so it can't be skipped (same as validJournalRecord)
+		if op[0] > Merge {
+			fmt.Printf("Error reading op: unknown op %d\n", op[0])
+			return corruptJournalErr
+		}
+
+		err = read(keyLen)
+		if err == io.ErrUnexpectedEOF {
+			return truncateJournal(journalFile, offset)
+		} else if err != nil {
 			fmt.Printf("Error reading key length: %v\n", err)
-			return nil
+			return corruptJournalErr
 		}
 
 		key := make([]byte, binary.BigEndian.Uint16(keyLen))
-		_, err = journalFile.Read(key)
-		if err != nil {
+		err = read(key)
+		if err == io.ErrUnexpectedEOF {
+			return truncateJournal(journalFile, offset)
+		} else if err != nil {
 			fmt.Printf("Error reading key: %v\n", err)
-			return nil
+			return corruptJournalErr
 		}
 
-		_, err = journalFile.Read(valLen)
-		if err != nil {
-			fmt.Printf("Error reading value length: %v\n", err)
-			return nil
+		// Deletes are written without a value
+		var val []byte
+		if op[0] != Delete {
+			err = read(valLen)
+			if err == io.ErrUnexpectedEOF {
+				return truncateJournal(journalFile, offset)
+			} else if err != nil {
+				fmt.Printf("Error reading value length: %v\n", err)
+				return corruptJournalErr
+			}
+
+			val = make([]byte, binary.BigEndian.Uint16(valLen))
+			err = read(val)
+			if err == io.ErrUnexpectedEOF {
+				return truncateJournal(journalFile, offset)
+			} else if err != nil {
+				fmt.Printf("Error reading value: %v\n", err)
+				return corruptJournalErr
+			}
 		}
 
-		val := make([]byte, binary.BigEndian.Uint16(valLen))
-		_, err = journalFile.Read(val)
-		if err != nil {
-			fmt.Printf("Error reading value: %v\n", err)
-			return nil
+		var expiresAt int64
+		if op[0] == InsertWithTTL {
+			err = read(expiresAtBytes)
+			if err == io.ErrUnexpectedEOF {
+				return truncateJournal(journalFile, offset)
+			} else if err != nil {
+				fmt.Printf("Error reading expiry: %v\n", err)
+				return corruptJournalErr
+			}
+			expiresAt = int64(binary.BigEndian.Uint64(expiresAtBytes))
 		}
 
-		if op[0] == Insert {
-			err := db.Put(key, val)
+		offset += int64(len(encodeRecord(op[0], key, val, expiresAt)))
+
+		if op[0] == Insert || op[0] == InsertWithTTL {
+			err := db.put(key, val, expiresAt)
 			if err != nil {
 				fmt.Printf("Error inserting key: %v\n", err)
-				return nil
+				return corruptJournalErr
 			}
 		} else if op[0] == Delete {
 			err := db.Delete(key)
 			if err != nil {
 				fmt.Printf("Error deleting key: %v\n", err)
-				return nil
+				return corruptJournalErr
+			}
+		} else if op[0] == DeleteRange {
+			err := db.DeleteRange(key, val)
+			if err != nil {
+				fmt.Printf("Error deleting range: %v\n", err)
+				return corruptJournalErr
+			}
+		} else if op[0] == Merge {
+			operands, err := decodeOperands(val)
+			if err != nil {
+				fmt.Printf("Error reading merge operands: %v\n", err)
+				return corruptJournalErr
+			}
+
+			for _, operand := range operands {
+				err = db.Merge(key, operand)
+				if err != nil {
+					fmt.Printf("Error merging key: %v\n", err)
+					return corruptJournalErr
+				}
 			}
 		}
 
 		db.memtable.size++
 	}
-	db.journal = true
-	return db
+	return nil
 }
 
-func (db *ClevelDB) clearJournal() error {
-	if db.journal {
-		return db.journalFile.Truncate(0)
-	} else {
-		return nil
+// truncateJournal : Removes the partially written record at the end of the journal (the truncation is synced, so
+// that later records aren't appended after the partial one)
+func truncateJournal(journalFile File, offset int64) error {
+	err := journalFile.Truncate(offset)
+	if err == nil {
+		err = journalFile.Sync()
+	}
+	if err != nil {
+		fmt.Printf("Error truncating journal: %v\n", err)
+		return corruptJournalErr
 	}
+	return nil
 }
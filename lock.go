@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const lockFilename = "LOCK"
+
+var dbLockedErr = errors.New("db is already open (its LOCK file is held by another process)")
+
+// DestroyDB : Removes the db in the given directory, which must not be open
+// Only the db's own files are removed (i.e. the journals, the LOCK file, the lost directory (see Repair), and the
+// tables directory's tables, MANIFEST and temporary files), and the directories are only removed once they're empty
+// Only opts.FS is used (a nil *Options uses the defaults)
+func DestroyDB(dir string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	fs := opts.fs()
+
+	lockPath := filepath.Join(dir, lockFilename)
+	if _, err := fs.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	lock, err := fs.Lock(lockPath)
+	if err != nil {
+		return err
+	}
+
+	err = removeDBFiles(fs, dir)
+	if unlockErr := lock.Close(); err == nil {
+		err = unlockErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// The LOCK file is removed last, so that the db can't be opened while its files are being removed
+	err = fs.Remove(lockPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	_ = fs.Remove(filepath.Join(dir, ssTablesDir))
+	_ = fs.Remove(dir)
+	return nil
+}
+
+func removeDBFiles(fs FS, dir string) error {
+	journalNums, err := listJournals(fs, dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, journalNum := range journalNums {
+		err = fs.Remove(filepath.Join(dir, fmt.Sprintf(journalFilename, journalNum)))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = fs.RemoveAll(filepath.Join(dir, lostDir))
+	if err != nil {
+		return err
+	}
+
+	tablesDir := filepath.Join(dir, ssTablesDir)
+	filenames, err := fs.List(tablesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, filename := range filenames {
+		if !isDBFile(filename) {
+			continue
+		}
+
+		err = fs.Remove(filepath.Join(tablesDir, filename))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDBFile : Returns true if the db created the file in its tables directory
+func isDBFile(filename string) bool {
+	return filename == manifestFilename || filename == manifestFilename+".tmp" || filename == ingestCommitFilename ||
+		filename == ingestCommitFilename+".tmp" || parseFileNum(filename) != 0 || isTmpTableFile(filename)
+}
+
+// isTmpTableFile : Returns true if the file is a table that the db hadn't finished writing yet (i.e. a new table,
+// an ingested copy or a repaired table)
+func isTmpTableFile(filename string) bool {
+	for _, pattern := range []string{tableTmpFilename, ingestFilename, repairFilename} {
+		if _, ok := scanFileNum(filename, pattern); ok {
+			return true
+		}
+	}
+	return false
+}
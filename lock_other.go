@@ -0,0 +1,45 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File locks aren't supported, so locks are only exclusive within the process
+var lockedFiles = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// lockFile : Takes an exclusive lock on the file (creating it if needed), which is held until unlockFile
+func lockFile(path string) (*os.File, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lockedFiles.Lock()
+	defer lockedFiles.Unlock()
+	if lockedFiles.paths[absPath] {
+		return nil, dbLockedErr
+	}
+
+	file, err := os.OpenFile(absPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	lockedFiles.paths[absPath] = true
+	return file, nil
+}
+
+func unlockFile(file *os.File) error {
+	lockedFiles.Lock()
+	delete(lockedFiles.paths, file.Name())
+	lockedFiles.Unlock()
+
+	return file.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ClevelDBDirectoryCanOnlyBeOpenedOnce(t *testing.T) {
+	// The operating system's file system is used, so that the LOCK file is actually locked (with flock)
+	for _, fs := range []FS{OSFS{}, NewMemFS()} {
+		dir := filepath.Join(t.TempDir(), "db")
+		opts := &Options{FS: fs, Dir: dir}
+
+		db := loadTestDBFromDir(t, fs, dir, nil)
+		_ = db.Put([]byte("key"), []byte("value"))
+
+		if _, err := loadClevelDB(opts); err != dbLockedErr {
+			t.Errorf("loadClevelDB returns unexpected error for open db: %v", err)
+		}
+		if err := DestroyDB(dir, opts); err != dbLockedErr {
+			t.Errorf("DestroyDB returns unexpected error for open db: %v", err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close returns unexpected error: %v", err)
+		}
+
+		reopened, err := loadClevelDB(opts)
+		if err != nil {
+			t.Fatalf("loadClevelDB returns unexpected error after Close: %v", err)
+		}
+		if val, err := reopened.Get([]byte("key")); string(val) != "value" || err != nil {
+			t.Errorf(`storage.Get("key") returns unexpected value after reopening: "%s" (%v)`, val, err)
+		}
+		_ = reopened.Close()
+	}
+}
+
+func Test_DestroyDBOnlyRemovesDBFiles(t *testing.T) {
+	fs, dir := NewMemFS(), "db"
+	opts := &Options{FS: fs}
+
+	db := loadTestDBFromDir(t, fs, dir, nil)
+	_ = db.Put([]byte("key"), []byte("value"))
+	flushTestMemtable(t, db)
+	_ = db.Close()
+
+	// Files that the db didn't create are left as is
+	_ = writeFile(fs, filepath.Join(dir, "notes.txt"), []byte("keep"))
+	_ = writeFile(fs, filepath.Join(dir, ssTablesDir, "backup.ss"), []byte("keep"))
+	_ = writeFile(fs, filepath.Join(dir, ssTablesDir, fmt.Sprintf(ssTableFilename, 1)+".bak"), []byte("keep"))
+	_ = writeFile(fs, filepath.Join(dir, lostDir, fmt.Sprintf(ssTableFilename, 1)), []byte("damaged"))
+	_ = writeFile(fs, filepath.Join(dir, ssTablesDir, fmt.Sprintf(ingestFilename, 7)), nil)
+
+	if err := DestroyDB(dir, opts); err != nil {
+		t.Fatalf("DestroyDB returns unexpected error: %v", err)
+	}
+
+	remaining, _ := fs.List(dir)
+	checkKeys(t, "DestroyDB remaining files", remaining, []string{"notes.txt", "sstables"})
+	remaining, _ = fs.List(filepath.Join(dir, ssTablesDir))
+	checkKeys(t, "DestroyDB remaining tables", remaining, []string{"backup.ss", "segment_1.ss.bak"})
+
+	// Destroying an empty directory removes it
+	_ = fs.Remove(filepath.Join(dir, "notes.txt"))
+	_ = fs.Remove(filepath.Join(dir, ssTablesDir, "backup.ss"))
+	_ = fs.Remove(filepath.Join(dir, ssTablesDir, "segment_1.ss.bak"))
+	if err := DestroyDB(dir, opts); err != nil {
+		t.Fatalf("DestroyDB returns unexpected error: %v", err)
+	}
+	if _, err := fs.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("DestroyDB leaves empty directory: %v", err)
+	}
+}
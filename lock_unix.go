@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile : Takes an exclusive lock on the file (creating it if needed), which is held until unlockFile
+// flock locks belong to the open file, so the lock is released if the process exits (or crashes), and a second
+// attempt to lock the file fails even within the same process
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, dbLockedErr
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func unlockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
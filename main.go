@@ -3,7 +3,7 @@ package main
 import "fmt"
 
 func main() {
-	db, err := loadClevelDB()
+	db, err := loadClevelDB(nil)
 	if err != nil {
 		return
 	}
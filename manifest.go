@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const manifestFilename = "MANIFEST"
+
+var comparatorMismatchErr = errors.New("comparator doesn't match the db's comparator")
+
+// manifest : The db's persistent metadata, which is stored (one "name value" pair per line) in the MANIFEST file
+// within the tables directory
+type manifest struct {
+	comparator string // the name of the comparator that ordered every key in the db
+}
+
+// readManifest : Reads the MANIFEST in the directory (returns os.ErrNotExist if the db doesn't have one yet)
+func readManifest(fs FS, dir string) (*manifest, error) {
+	file, err := fs.Open(filepath.Join(dir, manifestFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &manifest{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			return nil, fmt.Errorf("corrupt MANIFEST line: %q", scanner.Text())
+		}
+
+		// Unknown names are skipped (i.e. so that they can be added without breaking older versions)
+		switch name {
+		case "comparator":
+			m.comparator = value
+		}
+	}
+
+	return m, scanner.Err()
+}
+
+// writeManifest : Replaces the MANIFEST in the directory
+// The MANIFEST is written to a temporary file first and then renamed, so it's never left half-written
+func writeManifest(fs FS, dir string, m *manifest) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "comparator %s\n", m.comparator)
+
+	tmpPath := filepath.Join(dir, manifestFilename+".tmp")
+	err := writeFile(fs, tmpPath, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return fs.Rename(tmpPath, filepath.Join(dir, manifestFilename))
+}
+
+// checkManifest : Ensures the db is opened with the same comparator that it was created with (a db without a
+// MANIFEST is new, so the MANIFEST is created with the given comparator)
+func checkManifest(fs FS, dir string, cmp Comparator) error {
+	m, err := readManifest(fs, dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return writeManifest(fs, dir, &manifest{comparator: cmp.Name()})
+	} else if err != nil {
+		return err
+	}
+
+	if m.comparator != cmp.Name() {
+		return fmt.Errorf("%w: %s (db uses %s)", comparatorMismatchErr, cmp.Name(), m.comparator)
+	}
+	return nil
+}
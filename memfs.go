@@ -0,0 +1,452 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var memFileClosedErr = errors.New("file is closed")
+var memFileReadOnlyErr = errors.New("file is opened for reading only")
+
+// MemFS : An in-memory file system, which lets tests run without touching the disk (or the working directory)
+// Paths are cleaned (so "sstables/" and "./sstables" are the same directory), and "." (the root) always exists
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData // keyed by path (hard links share the same data)
+	dirs  map[string]bool
+	locks map[string]bool
+}
+
+// memData : The contents of a file
+type memData struct {
+	mu      sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS : Returns an empty MemFS
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memData), dirs: map[string]bool{".": true}, locks: make(map[string]bool)}
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	return m.open(name, true, true, false)
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	return m.open(name, false, false, false)
+}
+
+func (m *MemFS) OpenAppend(name string) (File, error) {
+	return m.open(name, true, false, true)
+}
+
+func (m *MemFS) open(name string, create, truncate, appendOnly bool) (File, error) {
+	name = filepath.Clean(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.files[name]
+	if !ok {
+		if !create {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if !m.dirs[filepath.Dir(name)] {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if m.dirs[name] {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+
+		data = &memData{modTime: timeNow()}
+		m.files[name] = data
+	}
+
+	if truncate {
+		data.mu.Lock()
+		data.data = nil
+		data.modTime = timeNow()
+		data.mu.Unlock()
+	}
+
+	return &memFile{name: name, data: data, writable: create, appendOnly: appendOnly}, nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dirs[oldname] {
+		return m.renameDir(oldname, newname)
+	}
+
+	data, ok := m.files[oldname]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if !m.dirs[filepath.Dir(newname)] {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+
+	delete(m.files, oldname)
+	m.files[newname] = data
+	return nil
+}
+
+// renameDir : Moves the directory (and everything in it) to a new path
+func (m *MemFS) renameDir(oldname, newname string) error {
+	if m.dirs[newname] || m.files[newname] != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+
+	prefix := oldname + string(filepath.Separator)
+	for path, data := range m.files {
+		if strings.HasPrefix(path, prefix) {
+			delete(m.files, path)
+			m.files[newname+path[len(oldname):]] = data
+		}
+	}
+	for path := range m.dirs {
+		if path == oldname || strings.HasPrefix(path, prefix) {
+			delete(m.dirs, path)
+			m.dirs[newname+path[len(oldname):]] = true
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+
+	if !m.dirs[name] || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if len(m.list(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+
+	delete(m.dirs, name)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	name = filepath.Clean(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := name + string(filepath.Separator)
+	for path := range m.files {
+		if path == name || strings.HasPrefix(path, prefix) {
+			delete(m.files, path)
+		}
+	}
+	for path := range m.dirs {
+		if path != "." && (path == name || strings.HasPrefix(path, prefix)) {
+			delete(m.dirs, path)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) SameFS(other FS) bool {
+	otherMemFS, ok := other.(*MemFS)
+	return ok && otherMemFS == m
+}
+
+func (m *MemFS) Link(oldname, newname string) error {
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.files[oldname]
+	if !ok || !m.dirs[filepath.Dir(newname)] {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if _, ok := m.files[newname]; ok || m.dirs[newname] {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+
+	m.files[newname] = data
+	return nil
+}
+
+func (m *MemFS) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for path := dir; !m.dirs[path]; path = filepath.Dir(path) {
+		if _, ok := m.files[path]; ok {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: errors.New("not a directory")}
+		}
+		m.dirs[path] = true
+	}
+	return nil
+}
+
+func (m *MemFS) List(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.dirs[dir] {
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: fs.ErrNotExist}
+	}
+	return m.list(dir), nil
+}
+
+// list : Returns the names of the directory's files and subdirectories (in sorted order)
+func (m *MemFS) list(dir string) []string {
+	var names []string
+	for path := range m.files {
+		if filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	for path := range m.dirs {
+		if path != "." && filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if data, ok := m.files[name]; ok {
+		return data.stat(name), nil
+	}
+	if m.dirs[name] {
+		return memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) Lock(name string) (io.Closer, error) {
+	name = filepath.Clean(name)
+
+	file, err := m.OpenAppend(name)
+	if err != nil {
+		return nil, err
+	}
+	_ = file.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.locks[name] {
+		return nil, dbLockedErr
+	}
+	m.locks[name] = true
+	return &memLock{fs: m, name: name}, nil
+}
+
+type memLock struct {
+	fs   *MemFS
+	name string
+}
+
+func (l *memLock) Close() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+
+	delete(l.fs.locks, l.name)
+	return nil
+}
+
+func (d *memData) stat(name string) os.FileInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return memFileInfo{name: filepath.Base(name), size: int64(len(d.data)), modTime: d.modTime}
+}
+
+// memFile : An open MemFS file (each open file has its own offset, like an *os.File)
+type memFile struct {
+	name       string
+	data       *memData
+	offset     int64
+	writable   bool
+	appendOnly bool // every write goes to the end of the file (like os.O_APPEND)
+	closed     bool
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(buf []byte) (int, error) {
+	n, err := f.ReadAt(buf, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(buf []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, memFileClosedErr
+	}
+
+	f.data.mu.RLock()
+	defer f.data.mu.RUnlock()
+
+	// Like an *os.File, reading nothing always succeeds (even at the end of the file)
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if offset >= int64(len(f.data.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(buf, f.data.data[offset:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(buf []byte) (int, error) {
+	if f.appendOnly {
+		f.data.mu.RLock()
+		f.offset = int64(len(f.data.data))
+		f.data.mu.RUnlock()
+	}
+
+	n, err := f.WriteAt(buf, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(buf []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, memFileClosedErr
+	}
+	if !f.writable {
+		return 0, memFileReadOnlyErr
+	}
+
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+
+	if end := offset + int64(len(buf)); end > int64(len(f.data.data)) {
+		f.data.data = append(f.data.data, make([]byte, end-int64(len(f.data.data)))...)
+	}
+	copy(f.data.data[offset:], buf)
+	f.data.modTime = timeNow()
+	return len(buf), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, memFileClosedErr
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.data.mu.RLock()
+		offset += int64(len(f.data.data))
+		f.data.mu.RUnlock()
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return memFileClosedErr
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, memFileClosedErr
+	}
+	return f.data.stat(f.name), nil
+}
+
+// Sync : The contents are already "durable" (i.e. they outlive the file), so there's nothing to sync
+func (f *memFile) Sync() error {
+	if f.closed {
+		return memFileClosedErr
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if f.closed {
+		return memFileClosedErr
+	}
+	if !f.writable {
+		return memFileReadOnlyErr
+	}
+
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+
+	if size < int64(len(f.data.data)) {
+		f.data.data = f.data.data[:size]
+	} else {
+		f.data.data = append(f.data.data, make([]byte, size-int64(len(f.data.data)))...)
+	}
+	f.data.modTime = timeNow()
+	return nil
+}
+
+// memFileInfo : Implements os.FileInfo for MemFS files and directories
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+func (i memFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | os.ModePerm
+	}
+	return 0644
+}
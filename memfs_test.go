@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_MemFSBehavesLikeOSFS(t *testing.T) {
+	fs := NewMemFS()
+
+	if _, err := fs.Create("dir/file"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("fs.Create returns unexpected error for missing directory: %v", err)
+	}
+	_ = fs.MkdirAll("dir/sub")
+
+	// Appends go to the end of the file, regardless of the file's offset
+	file, _ := fs.OpenAppend("dir/file")
+	_, _ = file.Write([]byte("hello"))
+	_, _ = file.Seek(0, io.SeekStart)
+	_, _ = file.Write([]byte(" world"))
+	_ = file.Close()
+
+	if data, err := readFile(fs, "./dir/file"); string(data) != "hello world" || err != nil {
+		t.Errorf(`readFile returns unexpected contents: "%s" (%v)`, data, err)
+	}
+	if _, err := file.Write([]byte("!")); err != memFileClosedErr {
+		t.Errorf("file.Write returns unexpected error for closed file: %v", err)
+	}
+
+	// Hard links share their contents, even after the original is renamed or removed
+	_ = fs.Link("dir/file", "dir/sub/link")
+	_ = fs.Rename("dir/file", "dir/renamed")
+	_ = writeFile(fs, "dir/renamed", []byte("replaced"))
+	_ = fs.Remove("dir/renamed")
+
+	if data, err := readFile(fs, "dir/sub/link"); string(data) != "replaced" || err != nil {
+		t.Errorf(`readFile returns unexpected contents for link: "%s" (%v)`, data, err)
+	}
+	if _, err := fs.Stat("dir/renamed"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("fs.Stat returns unexpected error for removed file: %v", err)
+	}
+
+	if names, _ := fs.List("dir"); !reflect.DeepEqual(names, []string{"sub"}) {
+		t.Errorf("fs.List returns unexpected names: %v", names)
+	}
+	if err := fs.Remove("dir"); err == nil {
+		t.Errorf("fs.Remove returns no error for non-empty directory")
+	}
+	if err := fs.RemoveAll("dir"); err != nil || exists(fs, "dir/sub/link") {
+		t.Errorf("fs.RemoveAll returns unexpected error: %v", err)
+	}
+
+	// Locks are exclusive until they're closed
+	lock, _ := fs.Lock("LOCK")
+	if _, err := fs.Lock("LOCK"); err != dbLockedErr {
+		t.Errorf("fs.Lock returns unexpected error for locked file: %v", err)
+	}
+	_ = lock.Close()
+	if _, err := fs.Lock("LOCK"); err != nil {
+		t.Errorf("fs.Lock returns unexpected error after unlocking: %v", err)
+	}
+}
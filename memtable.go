@@ -1,16 +1,22 @@
 package main
 
-import "bytes"
+import "sync"
 
 // Memtable - In-Memory Database (backed by a Skip List)
+// Writes (which are serialized by db.writeMu) hold mu while they change the skip list, and reads hold mu for reading
+// while they search it, since reads aren't blocked by writes otherwise
 type Memtable struct {
-	header   *SkipListNode
-	topLevel int
-	size     int
+	mu              sync.RWMutex
+	header          *SkipListNode
+	topLevel        int
+	size            int
+	rangeTombstones []rangeTombstone
+	cmp             Comparator
+	journalNum      int // the number of the memtable's journal (0 if the db doesn't have a journal)
 }
 
-func newMemtable() *Memtable {
-	newMdb := &Memtable{}
+func newMemtable(cmp Comparator) *Memtable {
+	newMdb := &Memtable{cmp: cmp}
 	newMdb.header = &SkipListNode{}
 	newMdb.topLevel = 1
 	return newMdb
@@ -20,15 +26,15 @@ func newMemtable() *Memtable {
 // If key isn't found, 'current' points to the closest key greater than that key (for multi-table RangeScan support)
 // 'current' will be nil if we reached the end of the list while searching
 // skipListNode.val will be nil if key was deleted (i.e. tombstone)
+// The caller must hold mem.mu (for reading) if the memtable can be written to, and until it's done with the node
 func (mem *Memtable) Get(key []byte) (*SkipListNode, error) {
 
 	// Start with pointers from the list's header node
 	current := mem.header
-	searchKey := string(key)
 
 	// Use pointers to look ahead until you find one equal to or larger and then stop
 	for level := mem.topLevel; level > 0; level-- {
-		for current.ptrs[level-1] != nil && string(current.ptrs[level-1].key) < searchKey {
+		for current.ptrs[level-1] != nil && mem.cmp.Compare(current.ptrs[level-1].key, key) < 0 {
 			current = current.ptrs[level-1]
 		}
 	}
@@ -37,44 +43,270 @@ func (mem *Memtable) Get(key []byte) (*SkipListNode, error) {
 	current = current.ptrs[0]
 
 	// If key is found (including tombstone), return it.
-	if current != nil && string(current.key) == searchKey {
+	if current != nil && mem.cmp.Compare(current.key, key) == 0 {
 		return current, nil
 	}
 
 	return current, notFoundInTableErr
 }
 
-func (mem *Memtable) RangeScan(start, limit []byte) (Iterator, error) {
-	currentNode, err := mem.Get(start)
-	if err != nil && err != notFoundInTableErr {
-		return nil, err
+// Put : Inserts value into the skip list (a nil value is a tombstone)
+// expiresAt is a unix timestamp in nanoseconds, or 0 if the key never expires
+func (mem *Memtable) Put(key, val []byte, expiresAt int64) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	node, _ := mem.findOrInsert(key)
+
+	mem.size += len(val) - len(node.val)
+	for _, operand := range node.operands {
+		mem.size -= len(operand)
 	}
 
+	node.val = val
+	node.expiresAt = expiresAt
+	node.operands = nil
+}
+
+// findOrInsert : Returns the node with the matching key, or inserts a new (empty) node if there isn't one
+// Also returns true if the node already existed (the caller must hold mem.mu)
+func (mem *Memtable) findOrInsert(key []byte) (*SkipListNode, bool) {
+	// Track nodes who have a forward pointer that will need to be updated if a new node is inserted
+	update := make([]*SkipListNode, maxLevel)
+	current := mem.header
+
+	for level := mem.topLevel; level > 0; level-- {
+		for current.ptrs[level-1] != nil && mem.cmp.Compare(current.ptrs[level-1].key, key) < 0 {
+			current = current.ptrs[level-1]
+		}
+		// Prior to descending, store the rightmost node that was reached on the current level
+		update[level-1] = current
+	}
+
+	current = current.ptrs[0]
+
+	// If there is an existing node with the matching key, just return it.
+	// Otherwise, insert new node below.
+	if current != nil && mem.cmp.Compare(current.key, key) == 0 {
+		return current, true
+	}
+
+	// Insert new node (at random level)
+	newLevel := randomLevel()
+
+	// If the new level is higher than the current max level, we'll need to also
+	// update the header node at the new higher levels, so we Add the header's new
+	// levels to the update vector
+	if newLevel > mem.topLevel {
+		for level := mem.topLevel; level < newLevel; level++ {
+			update[level] = mem.header
+		}
+		mem.topLevel = newLevel
+	}
+
+	// Create new node with empty forward pointers
+	newNode := &SkipListNode{key: key}
+
+	for i := 0; i < newLevel; i++ {
+		// Use update vector to fill new node's forward pointers
+		newNode.ptrs[i] = update[i].ptrs[i]
+		// Re-direct the update vector's pointers to point at the new node
+		update[i].ptrs[i] = newNode
+	}
+
+	mem.size += len(key)
+
+	return newNode, false
+}
+
+// findLessThan : Returns the last node whose key is smaller than the given key (or nil if there isn't one)
+func (mem *Memtable) findLessThan(key []byte) *SkipListNode {
+	current := mem.header
+
+	for level := mem.topLevel; level > 0; level-- {
+		for current.ptrs[level-1] != nil && mem.cmp.Compare(current.ptrs[level-1].key, key) < 0 {
+			current = current.ptrs[level-1]
+		}
+	}
+
+	if current == mem.header {
+		return nil
+	}
+	return current
+}
+
+// findLast : Returns the node with the largest key (or nil if the skip list is empty)
+func (mem *Memtable) findLast() *SkipListNode {
+	current := mem.header
+
+	// Follow the pointers at each level until reaching the end of the list, before descending
+	for level := mem.topLevel; level > 0; level-- {
+		for current.ptrs[level-1] != nil {
+			current = current.ptrs[level-1]
+		}
+	}
+
+	if current == mem.header {
+		return nil
+	}
+	return current
+}
+
+// RangeScan : Returns an iterator over the keys within [start, limit), positioned at the first key
+func (mem *Memtable) RangeScan(start, limit []byte) (Iterator, error) {
+	iterator := mem.newIterator(start, limit)
+	iterator.First()
+
+	return iterator, nil
+}
+
+// newIterator : Returns an (unpositioned) iterator over the keys within [start, limit)
+func (mem *Memtable) newIterator(start, limit []byte) *MemtableIterator {
 	return &MemtableIterator{
-			currentNode: currentNode,
-			limit:       limit,
-		},
-		nil
+		mem:   mem,
+		start: start,
+		limit: limit,
+	}
+}
+
+// clone : Returns a copy of the memtable (nodes are updated in place, so a snapshot needs its own copy)
+func (mem *Memtable) clone() *Memtable {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	clone := newMemtable(mem.cmp)
+	for current := mem.header.ptrs[0]; current != nil; current = current.ptrs[0] {
+		node, _ := clone.findOrInsert(current.key)
+		node.val = current.val
+		node.expiresAt = current.expiresAt
+		if current.operands != nil {
+			// Merge modifies the operands in place too
+			node.operands = append([][]byte{}, current.operands...)
+		}
+	}
+
+	clone.size = mem.size
+	clone.rangeTombstones = append([]rangeTombstone(nil), mem.rangeTombstones...)
+	return clone
+}
+
+// numEntries : Returns the number of keys in the memtable (including tombstones)
+func (mem *Memtable) numEntries() int {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	numEntries := 0
+	for current := mem.header.ptrs[0]; current != nil; current = current.ptrs[0] {
+		numEntries++
+	}
+	return numEntries
 }
 
+// memoryUsage : Returns the number of bytes used by the memtable's keys, values and merge operands
+func (mem *Memtable) memoryUsage() int {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	return mem.size
+}
+
+// tombstones : Returns the memtable's range tombstones (DeleteRange only ever appends to them, so the returned slice
+// doesn't change)
+func (mem *Memtable) tombstones() []rangeTombstone {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	return mem.rangeTombstones
+}
+
+// MemtableIterator : Iterates over the skip list in either direction
+// Moving forward simply follows the pointers at the lowest level, whereas moving backward searches the skip list
+// for the node preceding the current one (since nodes don't have backward pointers)
+// Each move holds the memtable's lock for reading, and copies the current node's value (the node itself is changed in
+// place by later writes to its key)
 type MemtableIterator struct {
-	currentNode *SkipListNode
+	mem         *Memtable
+	currentNode *SkipListNode // nil if the iterator isn't positioned at a key
+	start       []byte
 	limit       []byte
+
+	// The current node's value, expiry and merge operands, as of when the iterator moved to it
+	val          []byte
+	expiresAt    int64
+	nodeOperands [][]byte
+}
+
+// move : Moves to the node returned by fn (while the memtable's lock is held), and copies the node's value
+func (i *MemtableIterator) move(fn func() *SkipListNode) bool {
+	i.mem.mu.RLock()
+	defer i.mem.mu.RUnlock()
+
+	i.currentNode = fn()
+	if i.currentNode != nil && !inRange(i.mem.cmp, i.currentNode.key, i.start, i.limit) {
+		i.currentNode = nil
+	}
+
+	i.val, i.expiresAt, i.nodeOperands = nil, 0, nil
+	if i.currentNode != nil {
+		i.val, i.expiresAt = i.currentNode.val, i.currentNode.expiresAt
+		if i.currentNode.operands != nil {
+			// Merge combines operands in place
+			i.nodeOperands = append([][]byte{}, i.currentNode.operands...)
+		}
+	}
+	return i.currentNode != nil
+}
+
+func (i *MemtableIterator) First() bool {
+	if i.start == nil {
+		return i.move(func() *SkipListNode { return i.mem.header.ptrs[0] })
+	}
+	return i.Seek(i.start)
+}
+
+func (i *MemtableIterator) Last() bool {
+	if i.limit == nil {
+		return i.move(i.mem.findLast)
+	}
+
+	// The limit is exclusive, so the last key is the one right before it
+	return i.move(func() *SkipListNode { return i.mem.findLessThan(i.limit) })
+}
+
+// Seek : Moves to the first key that is greater than or equal to the given key
+func (i *MemtableIterator) Seek(key []byte) bool {
+	if i.start != nil && i.mem.cmp.Compare(key, i.start) < 0 {
+		key = i.start
+	}
+
+	return i.move(func() *SkipListNode {
+		node, _ := i.mem.Get(key)
+		return node
+	})
 }
 
 func (i *MemtableIterator) Next() bool {
-	if bytes.Compare(i.currentNode.key, i.limit) >= 0 {
+	if i.currentNode == nil {
 		return false
 	}
 
-	i.currentNode = i.currentNode.ptrs[0]
+	current := i.currentNode
+	return i.move(func() *SkipListNode { return current.ptrs[0] })
+}
 
-	// Reached end-of-table
+func (i *MemtableIterator) Prev() bool {
 	if i.currentNode == nil {
 		return false
 	}
 
-	return true
+	current := i.currentNode
+	return i.move(func() *SkipListNode { return i.mem.findLessThan(current.key) })
+}
+
+func (i *MemtableIterator) Valid() bool {
+	return i.currentNode != nil
+}
+
+func (i *MemtableIterator) Release() {
+	i.currentNode = nil
 }
 
 func (i *MemtableIterator) Error() error {
@@ -82,9 +314,23 @@ func (i *MemtableIterator) Error() error {
 }
 
 func (i *MemtableIterator) Key() []byte {
+	if i.currentNode == nil {
+		return nil
+	}
 	return i.currentNode.key
 }
 
+// Value : Returns nil for deleted and expired keys (i.e. both are treated as tombstones), and for merge operands
 func (i *MemtableIterator) Value() []byte {
-	return i.currentNode.val
+	if i.currentNode == nil || isExpired(i.expiresAt) {
+		return nil
+	}
+	return i.val
+}
+
+func (i *MemtableIterator) operands() [][]byte {
+	if i.currentNode == nil {
+		return nil
+	}
+	return i.nodeOperands
 }
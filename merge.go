@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var noMergeOperatorErr = errors.New("no merge operator registered")
+var invalidMergeOperandErr = errors.New("invalid merge operand")
+
+// MergeOperator : Combines merge operands (written by Merge) with a key's existing value, so that
+// read-modify-write operations (e.g. counters) don't need to Get the existing value first
+type MergeOperator interface {
+	// FullMerge : Applies the operands (oldest first) to the existing value, which is nil if the key doesn't exist
+	FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error)
+
+	// PartialMerge : Combines two operands (left is the older one) into a single operand
+	// Returns false if the operands can't be combined without the existing value
+	PartialMerge(key, leftOperand, rightOperand []byte) ([]byte, bool)
+}
+
+// Merge : Stores a merge operand for the key, which is folded into the key's value lazily
+// (i.e. by Get, RangeScan and Compact) using the registered MergeOperator
+func (db *ClevelDB) Merge(key, operand []byte) error {
+	if db.mergeOperator == nil {
+		return noMergeOperatorErr
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	err := db.makeRoomForWrite()
+	if err != nil {
+		return err
+	}
+
+	if db.journal {
+		_, err := writeRecordToFile(db.journalFile, Merge, key, encodeOperands([][]byte{operand}), 0, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.memtable.Merge(key, operand, db.mergeOperator)
+}
+
+// Merge : Folds the operand into the key's value if the memtable already has one (including tombstones),
+// otherwise the operand is stored until a read or compaction finds the key's value in an older table
+func (mem *Memtable) Merge(key, operand []byte, mergeOperator MergeOperator) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	node, found := mem.findOrInsert(key)
+
+	// A new node has no value (which it would need in order to be folded), so it only stores the operand
+	if !found {
+		node.operands = [][]byte{operand}
+		mem.size += len(operand)
+		return nil
+	}
+
+	if node.operands != nil {
+		// Combine with the most recent operand if possible (i.e. to keep the list of operands short)
+		last := len(node.operands) - 1
+		combined, ok := mergeOperator.PartialMerge(key, node.operands[last], operand)
+		if ok {
+			mem.size += len(combined) - len(node.operands[last])
+			node.operands[last] = combined
+		} else {
+			node.operands = append(node.operands, operand)
+			mem.size += len(operand)
+		}
+		return nil
+	}
+
+	// Deleted and expired keys are merged as if the key doesn't exist
+	existing := node.val
+	if isExpired(node.expiresAt) {
+		existing = nil
+		node.expiresAt = 0
+	}
+
+	val, err := mergeOperator.FullMerge(key, existing, [][]byte{operand})
+	if err != nil {
+		return err
+	}
+
+	mem.size += len(val) - len(node.val)
+	node.val = val
+	return nil
+}
+
+// fullMerge : Applies the operands (oldest first) to the existing value
+// If there aren't any operands, the existing value is returned as is (or notFoundInDBErr if it's nil)
+func (db *ClevelDB) fullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	if len(operands) == 0 {
+		if existing == nil {
+			return nil, notFoundInDBErr
+		}
+		return existing, nil
+	}
+
+	if db.mergeOperator == nil {
+		return nil, noMergeOperatorErr
+	}
+
+	return db.mergeOperator.FullMerge(key, existing, operands)
+}
+
+// encodeOperands : Merge records store their operands as a list of: operand length | operand
+func encodeOperands(operands [][]byte) []byte {
+	var encoded []byte
+	for _, operand := range operands {
+		encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(operand)))
+		encoded = append(encoded, operand...)
+	}
+	return encoded
+}
+
+func decodeOperands(encoded []byte) ([][]byte, error) {
+	operands := [][]byte{}
+	for len(encoded) > 0 {
+		if len(encoded) < 2 {
+			return nil, invalidMergeOperandErr
+		}
+
+		operandLen := int(binary.BigEndian.Uint16(encoded))
+		encoded = encoded[2:]
+		if len(encoded) < operandLen {
+			return nil, invalidMergeOperandErr
+		}
+
+		operands = append(operands, encoded[:operandLen])
+		encoded = encoded[operandLen:]
+	}
+	return operands, nil
+}
+
+// mergeOperandIterator : Implemented by the memtable/SSTable iterators, so that the merged iterator can tell
+// merge operands apart from tombstones (since both have a nil value)
+type mergeOperandIterator interface {
+	Iterator
+	// operands : Returns the operands (oldest first) stored for the current key, or nil if it isn't a merge
+	operands() [][]byte
+}
+
+// UInt64AddOperator : Treats values and operands as 8-byte big-endian integers, and adds them together
+type UInt64AddOperator struct{}
+
+func (UInt64AddOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	var sum uint64
+	if existingValue != nil {
+		if len(existingValue) != 8 {
+			return nil, invalidMergeOperandErr
+		}
+		sum = binary.BigEndian.Uint64(existingValue)
+	}
+
+	for _, operand := range operands {
+		if len(operand) != 8 {
+			return nil, invalidMergeOperandErr
+		}
+		sum += binary.BigEndian.Uint64(operand)
+	}
+
+	return binary.BigEndian.AppendUint64(nil, sum), nil
+}
+
+func (UInt64AddOperator) PartialMerge(key, leftOperand, rightOperand []byte) ([]byte, bool) {
+	if len(leftOperand) != 8 || len(rightOperand) != 8 {
+		return nil, false
+	}
+
+	sum := binary.BigEndian.Uint64(leftOperand) + binary.BigEndian.Uint64(rightOperand)
+	return binary.BigEndian.AppendUint64(nil, sum), true
+}
+
+// StringAppendOperator : Appends operands to the existing value (separated by the delimiter)
+type StringAppendOperator struct {
+	Delimiter []byte
+}
+
+func (s StringAppendOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	var parts [][]byte
+	if existingValue != nil {
+		parts = append(parts, existingValue)
+	}
+	parts = append(parts, operands...)
+
+	return bytes.Join(parts, s.Delimiter), nil
+}
+
+func (s StringAppendOperator) PartialMerge(key, leftOperand, rightOperand []byte) ([]byte, bool) {
+	return bytes.Join([][]byte{leftOperand, rightOperand}, s.Delimiter), true
+}
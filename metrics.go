@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets : The upper bounds of the latency histograms' buckets (from 10µs up to 10s)
+var latencyBuckets = [...]time.Duration{
+	10 * time.Microsecond, 50 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond,
+	500 * time.Microsecond, time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Histogram : Counts durations in latencyBuckets (along with their total), and is safe for concurrent use
+type Histogram struct {
+	counts [len(latencyBuckets) + 1]int64 // the number of durations in each bucket (the last is everything above 10s)
+	count  int64
+	sum    int64 // in nanoseconds
+}
+
+// Observe : Records a duration in the histogram
+func (h *Histogram) Observe(d time.Duration) {
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+
+	atomic.AddInt64(&h.counts[bucket], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// observeSince : Records the time elapsed since start (e.g. defer h.observeSince(time.Now()))
+func (h *Histogram) observeSince(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// Count : Returns the number of durations recorded
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// metrics : The db's latency histograms and counters, which are exported by WriteMetrics
+type metrics struct {
+	getLatency         Histogram
+	putLatency         Histogram // Put and PutWithTTL
+	deleteLatency      Histogram
+	rangeScanLatency   Histogram // the time taken by RangeScan to position its iterator (not to iterate)
+	fsyncLatency       Histogram // every sync of the journal
+	flushDuration      Histogram
+	compactionDuration Histogram
+	bloomUseful        int64 // lookups that skipped a table because its bloom filter didn't contain the key
+	bloomUseless       int64 // lookups that passed a table's bloom filter, but didn't find the key in the table
+}
+
+// recordBloomCheck : Counts whether a table's bloom filter spared a lookup from searching the table
+// Tables that aren't part of a db (see OpenSSTableReader) have no metrics
+func (m *metrics) recordBloomCheck(useful bool) {
+	if m == nil {
+		return
+	}
+
+	if useful {
+		atomic.AddInt64(&m.bloomUseful, 1)
+	} else {
+		atomic.AddInt64(&m.bloomUseless, 1)
+	}
+}
+
+// syncTimingFile : Records the latency of each Sync in a histogram (used for the journal, which every write syncs)
+type syncTimingFile struct {
+	File
+	latency *Histogram
+}
+
+func (f syncTimingFile) Sync() error {
+	defer f.latency.observeSince(time.Now())
+	return f.File.Sync()
+}
+
+// WriteMetrics : Writes the db's metrics in the Prometheus text exposition format
+func (db *ClevelDB) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	m := &db.metrics
+
+	writeMetricHeader(bw, "cleveldb_operation_duration_seconds", "histogram",
+		"Latency of Get, Put, Delete and RangeScan (positioning the iterator) calls.")
+	writeHistogram(bw, "cleveldb_operation_duration_seconds", `operation="get"`, &m.getLatency)
+	writeHistogram(bw, "cleveldb_operation_duration_seconds", `operation="put"`, &m.putLatency)
+	writeHistogram(bw, "cleveldb_operation_duration_seconds", `operation="delete"`, &m.deleteLatency)
+	writeHistogram(bw, "cleveldb_operation_duration_seconds", `operation="range_scan"`, &m.rangeScanLatency)
+
+	writeMetricHeader(bw, "cleveldb_journal_fsync_duration_seconds", "histogram", "Latency of journal syncs.")
+	writeHistogram(bw, "cleveldb_journal_fsync_duration_seconds", "", &m.fsyncLatency)
+
+	writeMetricHeader(bw, "cleveldb_flush_duration_seconds", "histogram", "Duration of memtable flushes.")
+	writeHistogram(bw, "cleveldb_flush_duration_seconds", "", &m.flushDuration)
+
+	writeMetricHeader(bw, "cleveldb_compaction_duration_seconds", "histogram", "Duration of compactions.")
+	writeHistogram(bw, "cleveldb_compaction_duration_seconds", "", &m.compactionDuration)
+
+	writeMetricHeader(bw, "cleveldb_bloom_filter_useful_total", "counter",
+		"Table lookups that were skipped because the bloom filter didn't contain the key.")
+	fmt.Fprintf(bw, "cleveldb_bloom_filter_useful_total %d\n", atomic.LoadInt64(&m.bloomUseful))
+
+	writeMetricHeader(bw, "cleveldb_bloom_filter_useless_total", "counter",
+		"Table lookups that passed the bloom filter but didn't find the key.")
+	fmt.Fprintf(bw, "cleveldb_bloom_filter_useless_total %d\n", atomic.LoadInt64(&m.bloomUseless))
+
+	stats := db.BlockCacheStats()
+	writeMetricHeader(bw, "cleveldb_block_cache_hits_total", "counter", "Block cache hits.")
+	fmt.Fprintf(bw, "cleveldb_block_cache_hits_total %d\n", stats.Hits)
+
+	writeMetricHeader(bw, "cleveldb_block_cache_misses_total", "counter", "Block cache misses.")
+	fmt.Fprintf(bw, "cleveldb_block_cache_misses_total %d\n", stats.Misses)
+
+	// The hit rate is 0 until the block cache has been used
+	var hitRate float64
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		hitRate = float64(stats.Hits) / float64(lookups)
+	}
+	writeMetricHeader(bw, "cleveldb_block_cache_hit_ratio", "gauge", "Fraction of block cache lookups that were hits.")
+	fmt.Fprintf(bw, "cleveldb_block_cache_hit_ratio %s\n", formatFloat(hitRate))
+
+	return bw.Flush()
+}
+
+// MetricsHandler : Returns an HTTP handler that serves the db's metrics (see WriteMetrics), e.g. for Prometheus to
+// scrape at /metrics
+func (db *ClevelDB) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = db.WriteMetrics(w)
+	})
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeHistogram : Writes the histogram's cumulative buckets, sum and count (labels are added to every sample)
+func writeHistogram(w io.Writer, name, labels string, h *Histogram) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+
+	var cumulative int64
+	for i, bound := range latencyBuckets {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, prefix, formatFloat(bound.Seconds()), cumulative)
+	}
+	cumulative += atomic.LoadInt64(&h.counts[len(latencyBuckets)])
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, cumulative)
+
+	// The buckets are read one at a time, so the count is taken from them (rather than h.count) to stay consistent
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(time.Duration(atomic.LoadInt64(&h.sum)).Seconds()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, cumulative)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_HistogramWritesCumulativeBuckets(t *testing.T) {
+	var h Histogram
+	for _, d := range []time.Duration{5 * time.Microsecond, 10 * time.Microsecond, time.Millisecond, 20 * time.Second} {
+		h.Observe(d)
+	}
+
+	var b strings.Builder
+	writeHistogram(&b, "test_duration_seconds", `op="test"`, &h)
+	out := b.String()
+
+	// A duration that's equal to a bucket's upper bound is counted in that bucket
+	for _, line := range []string{
+		`test_duration_seconds_bucket{op="test",le="1e-05"} 2`,
+		`test_duration_seconds_bucket{op="test",le="0.0005"} 2`,
+		`test_duration_seconds_bucket{op="test",le="0.001"} 3`,
+		`test_duration_seconds_bucket{op="test",le="10"} 3`,
+		`test_duration_seconds_bucket{op="test",le="+Inf"} 4`,
+		`test_duration_seconds_sum{op="test"} 20.001015`,
+		`test_duration_seconds_count{op="test"} 4`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("writeHistogram is missing %s: %s", line, out)
+		}
+	}
+}
+
+func Test_ClevelDBServesPrometheusMetrics(t *testing.T) {
+	db := loadTestDBFromDir(t, NewMemFS(), "db", nil)
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+	_ = db.Delete([]byte("key000"))
+	flushTestMemtable(t, db)
+	_ = db.Put([]byte("key100"), []byte("value"))
+	flushTestMemtable(t, db)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact returns unexpected error: %v", err)
+	}
+
+	// Every key is in the compacted table, so its bloom filter rules out the missing keys (except false positives),
+	// along with the deleted key (whose tombstone was dropped by the compaction)
+	for i := 0; i < 20; i++ {
+		_, _ = db.Get([]byte(fmt.Sprintf("key%03d", i)))
+		_, _ = db.Get([]byte(fmt.Sprintf("missing%03d", i)))
+	}
+	iterator, _ := db.RangeScan([]byte("key000"), []byte("key010"))
+	iterator.Release()
+
+	m := &db.metrics
+	if m.bloomUseful == 0 || m.bloomUseful+m.bloomUseless != 21 {
+		t.Errorf("bloom filter returns unexpected counts: %d useful, %d useless", m.bloomUseful, m.bloomUseless)
+	}
+
+	// Every write syncs its memtable's journal
+	if m.fsyncLatency.Count() != 22 {
+		t.Errorf("journal records unexpected number of syncs: %d", m.fsyncLatency.Count())
+	}
+
+	recorder := httptest.NewRecorder()
+	db.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("MetricsHandler returns unexpected content type: %s", contentType)
+	}
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE cleveldb_operation_duration_seconds histogram",
+		`cleveldb_operation_duration_seconds_count{operation="get"} 40`,
+		`cleveldb_operation_duration_seconds_count{operation="put"} 21`,
+		`cleveldb_operation_duration_seconds_count{operation="delete"} 1`,
+		`cleveldb_operation_duration_seconds_count{operation="range_scan"} 1`,
+		`cleveldb_operation_duration_seconds_bucket{operation="get",le="+Inf"} 40`,
+		"cleveldb_journal_fsync_duration_seconds_count 22",
+		"cleveldb_flush_duration_seconds_count 2",
+		"cleveldb_compaction_duration_seconds_count 1",
+		fmt.Sprintf("cleveldb_bloom_filter_useful_total %d", m.bloomUseful),
+		"# TYPE cleveldb_block_cache_hits_total counter",
+		"# TYPE cleveldb_block_cache_hit_ratio gauge",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("MetricsHandler is missing %s: %s", line, body)
+		}
+	}
+
+	stats := db.BlockCacheStats()
+	expected := fmt.Sprintf("cleveldb_block_cache_hit_ratio %s\n", formatFloat(float64(stats.Hits)/float64(stats.Hits+stats.Misses)))
+	if stats.Hits == 0 || !strings.Contains(body, expected) {
+		t.Errorf("MetricsHandler returns unexpected block cache hit ratio (%+v): %s", stats, body)
+	}
+}
+
+func Test_ClevelDBLoadsBloomFiltersFromTables(t *testing.T) {
+	fs := NewMemFS()
+	db := loadTestDBFromDir(t, fs, "db", nil)
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+	flushTestMemtable(t, db)
+	_ = db.Close()
+
+	// The bloom filter is written into the table, so the table still has one once it's loaded from disk
+	db = loadTestDBFromDir(t, fs, "db", nil)
+	if len(db.tables) != 1 || db.tables[0].bloomFilter == nil {
+		t.Fatalf("loadClevelDB returns table without bloom filter")
+	}
+
+	for i := 0; i < 20; i++ {
+		if val, err := db.Get([]byte(fmt.Sprintf("key%03d", i))); string(val) != "value" || err != nil {
+			t.Errorf(`storage.Get("key%03d") returns unexpected value: "%s" (%v)`, i, val, err)
+		}
+		_, _ = db.Get([]byte(fmt.Sprintf("missing%03d", i)))
+	}
+
+	m := &db.metrics
+	if m.bloomUseful == 0 || m.bloomUseful+m.bloomUseless != 20 {
+		t.Errorf("bloom filter returns unexpected counts: %d useful, %d useless", m.bloomUseful, m.bloomUseless)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+// mmapFile : Memory-mapped reads aren't supported, so readers always fall back to positional reads
+func mmapFile(file File) ([]byte, error) {
+	return nil, mmapUnsupportedErr
+}
+
+func munmapFile(data []byte) error {
+	return mmapUnsupportedErr
+}
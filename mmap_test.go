@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// newTestReadDB : Returns a db with a few SSTables (10,000 keys in total), which are read using the given options
+// Only the operating system's files can be memory-mapped, so the tables are only written to disk if mmap is used
+func newTestReadDB(tb testing.TB, opts *Options) *ClevelDB {
+	db := &ClevelDB{
+		memtable:         newMemtable(opts.comparator()),
+		comparator:       opts.comparator(),
+		fs:               NewMemFS(),
+		tablesDir:        ssTablesDir,
+		nextFileNum:      1,
+		partitionedIndex: opts.PartitionedIndex,
+	}
+	if opts.UseMmapReads {
+		db.fs, db.tablesDir = OSFS{}, tb.TempDir()
+	}
+	_ = db.fs.MkdirAll(db.tablesDir)
+	if capacity := opts.blockCacheCapacity(); capacity > 0 {
+		db.blockCache = newBlockCache(capacity)
+	}
+	db.tableCache = newTableCache(db.blockCache, opts)
+
+	for i := 0; i < 10000; i++ {
+		db.memtable.Put([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("value%05d", i)), 0)
+
+		// Flushed manually (rather than once the memtable is full), so that the flush isn't in the background
+		if i%2500 == 2499 {
+			file, err := db.newTableFile()
+			if err != nil {
+				tb.Fatalf("error opening file: %v", err)
+			}
+
+			ssTable, reader, err := writeSSTable(file, db.memtable, opts.PartitionedIndex)
+			if err != nil {
+				tb.Fatalf("error writing table: %v", err)
+			}
+			if err := db.installTableFile(ssTable, reader); err != nil {
+				tb.Fatalf("error installing table: %v", err)
+			}
+
+			db.initTable(ssTable, reader)
+			db.tables = append([]*SSTable{ssTable}, db.tables...)
+			db.memtable = newMemtable(db.comparator)
+		}
+	}
+
+	return db
+}
+
+func Test_ClevelDBReadsMemoryMappedTables(t *testing.T) {
+	db := newTestReadDB(t, &Options{UseMmapReads: true, MaxOpenFiles: numNonTableFiles + 2})
+
+	reader := acquireTestReader(t, db.tables[0])
+	if reader.data == nil {
+		t.Fatalf("table isn't memory-mapped")
+	}
+
+	for i := 0; i < 10000; i += 7 {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		val, err := db.Get(key)
+		if string(val) != fmt.Sprintf("value%05d", i) || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value: "%s" (%v)`, key, val, err)
+		}
+	}
+
+	iter := db.NewIterator(&ReadOptions{LowerBound: []byte("key02495"), UpperBound: []byte("key02505")})
+	checkKeys(t, "NewIterator (with mmap)", collectKeys(iter, iter.Next), []string{
+		"key02495=value02495", "key02496=value02496", "key02497=value02497", "key02498=value02498", "key02499=value02499",
+		"key02500=value02500", "key02501=value02501", "key02502=value02502", "key02503=value02503", "key02504=value02504",
+	})
+	iter.Release()
+
+	// Values returned by Get are copied, so they're still valid once the tables are unmapped (i.e. evicted)
+	val, _ := db.Get([]byte("key00000"))
+	for i := 0; i < 10000; i += 2500 {
+		_, _ = db.Get([]byte(fmt.Sprintf("key%05d", i)))
+	}
+	if string(val) != "value00000" {
+		t.Errorf(`storage.Get("key00000") returns unexpected value after unmapping: "%s"`, val)
+	}
+}
+
+func benchmarkRandomGets(b *testing.B, opts *Options) {
+	db := newTestReadDB(b, opts)
+	rng := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _ = db.Get([]byte(fmt.Sprintf("key%05d", rng.Intn(10000))))
+	}
+}
+
+func benchmarkFullScan(b *testing.B, opts *Options) {
+	db := newTestReadDB(b, opts)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		iter := db.NewIterator(nil)
+		for ok := iter.Valid(); ok; ok = iter.Next() {
+		}
+		iter.Release()
+	}
+}
+
+func Benchmark_ClevelDBGetPread(b *testing.B) {
+	benchmarkRandomGets(b, &Options{BlockCacheCapacity: -1})
+}
+
+func Benchmark_ClevelDBGetPreadWithBlockCache(b *testing.B) {
+	benchmarkRandomGets(b, &Options{})
+}
+
+func Benchmark_ClevelDBGetMmap(b *testing.B) {
+	benchmarkRandomGets(b, &Options{UseMmapReads: true})
+}
+
+func Benchmark_ClevelDBScanPread(b *testing.B) {
+	benchmarkFullScan(b, &Options{BlockCacheCapacity: -1})
+}
+
+func Benchmark_ClevelDBScanPreadWithBlockCache(b *testing.B) {
+	benchmarkFullScan(b, &Options{})
+}
+
+func Benchmark_ClevelDBScanMmap(b *testing.B) {
+	benchmarkFullScan(b, &Options{UseMmapReads: true})
+}
+
+func Test_ClevelDBCompactsMemoryMappedTables(t *testing.T) {
+	db, err := loadClevelDB(&Options{Dir: t.TempDir(), UseMmapReads: true})
+	if err != nil {
+		t.Fatalf("error loading db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 10; j++ {
+			_ = db.Put([]byte(fmt.Sprintf("key%d%d", j, i)), []byte(fmt.Sprintf("value%d", i)))
+		}
+		flushTestMemtable(t, db)
+	}
+
+	// The compacted table's keys (and index) must not point into the old tables, which are unmapped once they're
+	// removed
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact returns unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 10; j++ {
+			key := fmt.Sprintf("key%d%d", j, i)
+			if val, err := db.Get([]byte(key)); string(val) != fmt.Sprintf("value%d", i) || err != nil {
+				t.Errorf(`storage.Get("%s") returns unexpected value after compaction: "%s" (%v)`, key, val, err)
+			}
+		}
+	}
+}
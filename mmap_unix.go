@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile : Maps the entire file into memory (read-only)
+// Only the operating system's files can be mapped (i.e. not the files of other file systems, such as MemFS)
+func mmapFile(file File) ([]byte, error) {
+	osFile, ok := file.(*os.File)
+	if !ok {
+		return nil, mmapUnsupportedErr
+	}
+
+	info, err := osFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return syscall.Mmap(int(osFile.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
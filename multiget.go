@@ -0,0 +1,194 @@
+package main
+
+import (
+	"sort"
+)
+
+// lookup : The state of a single key's lookup, which may span several memtables and tables
+type lookup struct {
+	key      []byte
+	operands [][]byte // merge operands collected so far (oldest first)
+	val      []byte
+	err      error
+	done     bool // set once the key's value (or tombstone) has been found
+}
+
+// finishLookup : Completes the lookup by folding the collected operands into the value (nil for tombstones)
+func (db *ClevelDB) finishLookup(l *lookup, val []byte) {
+	l.val, l.err = db.fullMerge(l.key, val, l.operands)
+	l.done = true
+}
+
+// applyRecord : Applies a record of the key that was found in an SSTable
+func (db *ClevelDB) applyRecord(l *lookup, op uint8, val []byte) {
+	if op != Merge {
+		// val is nil for tombstones (i.e. deleted or expired keys)
+		db.finishLookup(l, val)
+		return
+	}
+
+	// Operands are ordered from oldest to newest (so each older table's operands are prepended)
+	operands, err := decodeOperands(val)
+	if err != nil {
+		l.err = err
+		l.done = true
+		return
+	}
+	l.operands = append(operands, l.operands...)
+}
+
+// lookupInMemtable : Searches one of the memtables, which are searched (newest first) before the tables
+func (db *ClevelDB) lookupInMemtable(memtable *Memtable, l *lookup) {
+	memtable.mu.RLock()
+	defer memtable.mu.RUnlock()
+
+	node, err := memtable.Get(l.key)
+	if err == nil {
+		if node.operands == nil {
+			// Deleted and expired keys both shadow any older value in the SSTables
+			if node.val == nil || isExpired(node.expiresAt) {
+				db.finishLookup(l, nil)
+			} else {
+				db.finishLookup(l, node.val)
+			}
+			return
+		}
+
+		// Operands are ordered from oldest to newest (so each older memtable's operands are prepended)
+		l.operands = append(append([][]byte(nil), node.operands...), l.operands...)
+	}
+
+	// Code reaches here if key not found in memtable (or only merge operands were found)
+	// Keys in older memtables and tables may have been deleted by one of the memtable's range tombstones
+	if coveredByRangeTombstone(memtable.cmp, memtable.rangeTombstones, l.key) {
+		db.finishLookup(l, nil)
+	}
+}
+
+// MultiGet : Looks up every key at once, and returns their values and errors (in the same order as the keys)
+// The keys are sorted, so that each SSTable's index is searched in order and each block is read at most once
+// (keys that fall in the same block share a single read), rather than every key walking every table on its own
+func (db *ClevelDB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	lookups := make([]lookup, len(keys))
+	pending := make([]*lookup, len(keys))
+	for i, key := range keys {
+		lookups[i].key = key
+		pending[i] = &lookups[i]
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return db.comparator.Compare(pending[i].key, pending[j].key) < 0
+	})
+
+	memtables, tables := db.current()
+	defer unrefTables(tables)
+
+	for _, memtable := range memtables {
+		for _, l := range pending {
+			if !l.done {
+				db.lookupInMemtable(memtable, l)
+			}
+		}
+	}
+
+	// Search most recently flushed tables first, and stop once every key has been found
+	for _, table := range tables {
+		pending = removeDoneLookups(pending)
+		if len(pending) == 0 {
+			break
+		}
+
+		table.multiGet(db, pending)
+
+		// A table's range tombstones only apply to keys in older tables
+		for _, l := range pending {
+			if !l.done && coveredByRangeTombstone(table.cmp, table.rangeTombstones, l.key) {
+				db.finishLookup(l, nil)
+			}
+		}
+	}
+
+	vals := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	for i := range lookups {
+		if !lookups[i].done {
+			db.finishLookup(&lookups[i], nil)
+		}
+		vals[i], errs[i] = lookups[i].val, lookups[i].err
+	}
+
+	return vals, errs
+}
+
+// removeDoneLookups : Filters out the lookups that are done (in place, so their order is preserved)
+func removeDoneLookups(lookups []*lookup) []*lookup {
+	pending := lookups[:0]
+	for _, l := range lookups {
+		if !l.done {
+			pending = append(pending, l)
+		}
+	}
+	return pending
+}
+
+// multiGet : Searches the table for every (sorted) lookup, reading each block at most once
+func (ss *SSTable) multiGet(db *ClevelDB, lookups []*lookup) {
+	// Skip the table entirely if none of the keys are within its smallest and largest keys
+	if ss.smallest == nil || ss.cmp.Compare(lookups[0].key, ss.largest) > 0 ||
+		ss.cmp.Compare(lookups[len(lookups)-1].key, ss.smallest) < 0 {
+		return
+	}
+
+	// The table's bloom filter rules out most of the keys that aren't in the table (same as Get), so that they don't
+	// search the index or read a block
+	var candidates []*lookup
+	for _, l := range lookups {
+		if ss.cmp.Compare(l.key, ss.smallest) < 0 || ss.cmp.Compare(l.key, ss.largest) > 0 {
+			continue
+		}
+		if ss.bloomFilter != nil && !ss.bloomFilter.MaybeContains(l.key) {
+			ss.metrics.recordBloomCheck(true)
+			continue
+		}
+		candidates = append(candidates, l)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	reader, err := ss.acquire()
+	if err != nil {
+		for _, l := range candidates {
+			l.err = err
+			l.done = true
+		}
+		return
+	}
+	defer reader.release()
+
+	ro := defaultReadOptions()
+	blockOffset := int64(-1)
+	var entries []blockEntry
+
+	for _, l := range candidates {
+		// Keys are sorted, so the block only needs to be read when moving on to the next block
+		_, blocks, blockIdx, err := ss.findBlock(reader, l.key, ro)
+		if err == nil && blocks[blockIdx].offset != blockOffset {
+			entries, err = ss.getBlock(reader, blocks[blockIdx], ro)
+		}
+		if err != nil {
+			l.err = err
+			l.done = true
+			blockOffset = -1
+			continue
+		}
+		blockOffset = blocks[blockIdx].offset
+
+		op, val, err := ss.searchBlock(reader, entries, l.key)
+		if err == nil {
+			db.applyRecord(l, op, val)
+		} else if err == notFoundInTableErr && ss.bloomFilter != nil {
+			ss.metrics.recordBloomCheck(false)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ClevelDBMultiGetReturnsValuesInOrder(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.mergeOperator = StringAppendOperator{Delimiter: []byte(",")}
+
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	_ = db.Put([]byte("b"), []byte("nitin"))
+	_ = db.Put([]byte("c"), []byte("neha"))
+	_ = db.Put([]byte("d"), []byte("david"))
+	flushTestMemtable(t, db)
+
+	_ = db.Delete([]byte("a"))
+	_ = db.Merge([]byte("b"), []byte("karli"))
+	_ = db.DeleteRange([]byte("d"), []byte("e"))
+	flushTestMemtable(t, db)
+
+	_ = db.Put([]byte("c"), []byte("karli"))
+	_ = db.Put([]byte("f"), []byte("munoz"))
+
+	keys := [][]byte{[]byte("f"), []byte("a"), []byte("c"), []byte("b"), []byte("e"), []byte("d"), []byte("c")}
+	expectedVals := []string{"munoz", "", "karli", "nitin,karli", "", "", "karli"}
+	expectedErrs := []error{nil, notFoundInDBErr, nil, nil, notFoundInDBErr, notFoundInDBErr, nil}
+
+	vals, errs := db.MultiGet(keys)
+	for i, key := range keys {
+		if string(vals[i]) != expectedVals[i] || errs[i] != expectedErrs[i] {
+			t.Errorf(`storage.MultiGet returns unexpected value for "%s": "%s" (%v)`, key, vals[i], errs[i])
+		}
+	}
+}
+
+func Test_ClevelDBMultiGetReadsEachBlockOnce(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.blockCache = newBlockCache(defaultBlockCacheCapacity)
+	db.tableCache = newTableCache(db.blockCache, &Options{})
+
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		_ = db.Put(key, []byte(fmt.Sprint(i)))
+		keys = append(keys, key)
+	}
+	flushTestMemtable(t, db)
+
+	// Every key is looked up (in reverse order), so every block is read exactly once
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+
+	vals, errs := db.MultiGet(keys)
+	for i := range keys {
+		if string(vals[i]) != fmt.Sprint(99-i) || errs[i] != nil {
+			t.Errorf(`storage.MultiGet returns unexpected value for "%s": "%s" (%v)`, keys[i], vals[i], errs[i])
+		}
+	}
+
+	numBlocks := len(acquireTestReader(t, db.tables[0]).index.blocks)
+	if stats := db.BlockCacheStats(); stats.Misses != uint64(numBlocks) || stats.Hits != 0 {
+		t.Errorf("storage.MultiGet reads unexpected number of blocks-- Expected: %v, Actual: %+v", numBlocks, stats)
+	}
+}
+
+func Test_ClevelDBMultiGetChecksBloomFilters(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.blockCache = newBlockCache(defaultBlockCacheCapacity)
+	db.tableCache = newTableCache(db.blockCache, &Options{})
+
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+		keys = append(keys, []byte(fmt.Sprintf("key%03d-missing", i)))
+	}
+	flushTestMemtable(t, db)
+
+	// Every key but the last is within the table's key range, but the table's bloom filter rules all of them out (the
+	// last key is after the table's largest key, so the table is skipped without checking its bloom filter)
+	_, errs := db.MultiGet(keys)
+	for i := range keys {
+		if errs[i] != notFoundInDBErr {
+			t.Errorf(`storage.MultiGet returns unexpected error for "%s": %v`, keys[i], errs[i])
+		}
+	}
+
+	if stats := db.BlockCacheStats(); stats.Misses != 0 || stats.Hits != 0 {
+		t.Errorf("storage.MultiGet reads unexpected number of blocks-- Expected: 0, Actual: %+v", stats)
+	}
+	if m := &db.metrics; m.bloomUseful != 99 || m.bloomUseless != 0 {
+		t.Errorf("bloom filter returns unexpected counts: %d useful, %d useless", m.bloomUseful, m.bloomUseless)
+	}
+}
@@ -27,7 +27,7 @@ func (db *NaiveDB) Put(key, value []byte) error {
 }
 
 func (db *NaiveDB) Delete(key []byte) error {
-	db.storage[string(key)] = nil
+	delete(db.storage, string(key))
 	return nil
 }
 
@@ -35,28 +35,15 @@ func (db *NaiveDB) Size() int {
 	return len(db.storage)
 }
 
+// RangeScan : Returns an iterator over the keys within [start, limit) (a nil start or limit is unbounded)
 func (db *NaiveDB) RangeScan(start, limit []byte) (Iterator, error) {
-	var keys []string
-	for k := range db.storage {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	inRange := false
 	var keysInRange []string
-	for _, key := range keys {
-		if !inRange && key == string(start) {
-			inRange = true
-		}
-
-		if inRange {
+	for key := range db.storage {
+		if inRange(BytewiseComparator{}, []byte(key), start, limit) {
 			keysInRange = append(keysInRange, key)
 		}
-
-		if inRange && key == string(limit) {
-			break
-		}
 	}
+	sort.Strings(keysInRange)
 
 	return &NaiveIterator{keys: keysInRange, db: db}, nil
 }
@@ -68,20 +55,54 @@ type NaiveIterator struct {
 	err  error
 }
 
+func (i *NaiveIterator) First() bool {
+	i.idx = 0
+	return i.Valid()
+}
+
+func (i *NaiveIterator) Last() bool {
+	i.idx = len(i.keys) - 1
+	return i.Valid()
+}
+
+func (i *NaiveIterator) Seek(key []byte) bool {
+	i.idx = sort.SearchStrings(i.keys, string(key))
+	return i.Valid()
+}
+
 func (i *NaiveIterator) Next() bool {
 	i.idx++
 	return i.idx < len(i.keys)
 }
 
+func (i *NaiveIterator) Prev() bool {
+	i.idx--
+	return i.Valid()
+}
+
+func (i *NaiveIterator) Valid() bool {
+	return i.idx >= 0 && i.idx < len(i.keys)
+}
+
+func (i *NaiveIterator) Release() {
+	i.keys = nil
+}
+
 func (i *NaiveIterator) Error() error {
 	return i.err
 }
 
 func (i *NaiveIterator) Key() []byte {
+	if !i.Valid() {
+		return nil
+	}
 	return []byte(i.keys[i.idx])
 }
 
 func (i *NaiveIterator) Value() []byte {
+	if !i.Valid() {
+		return nil
+	}
 	key := i.keys[i.idx]
 	return i.db.storage[key]
 }
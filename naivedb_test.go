@@ -16,6 +16,10 @@ func Test_NaiveDBRangeScanAndNextReturnCorrectOrderedValues(t *testing.T) {
 	testRangeScanAndNextReturnCorrectOrderedValues(t, newNaiveDB())
 }
 
+func Test_NaiveDBRangeScanAndPrevReturnCorrectReverseOrderedValues(t *testing.T) {
+	testRangeScanAndPrevReturnCorrectReverseOrderedValues(t, newNaiveDB())
+}
+
 func Benchmark_NaiveDBFillSeq(b *testing.B) {
 	benchmarkFillSeq(b, newNaiveDB())
 }
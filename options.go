@@ -0,0 +1,133 @@
+package main
+
+// Options : Configures how a ClevelDB is loaded (a nil *Options uses the defaults)
+type Options struct {
+	// MergeOperator : Combines the operands written by Merge (required to use Merge)
+	MergeOperator MergeOperator
+
+	// BlockCacheCapacity : The capacity (in bytes) of the cache of decoded blocks, which is shared by every SSTable
+	// 0 uses the default capacity (8MB), and a negative capacity disables the cache
+	BlockCacheCapacity int64
+
+	// PinIndexBlocks : Whether each open SSTable's index (including every partition of a partitioned index, which are
+	// read as soon as the table is opened) should be pinned in the block cache (i.e. charged to its capacity, without
+	// ever being evicted). Without pinning, index partitions are cached (and evicted) like data blocks
+	// Note: bloom filters aren't cached, since each table's filter is kept in memory for as long as the table exists
+	// (see SSTable.bloomFilter)
+	PinIndexBlocks bool
+
+	// MaxOpenFiles : The maximum number of files that can be open at once (most of which are used by the table
+	// cache, which opens SSTables on demand). 0 uses the default (1000)
+	MaxOpenFiles int
+
+	// UseMmapReads : Whether SSTable files should be memory-mapped, so that reads are served directly from the
+	// mapped bytes (rather than through the block cache). Iterators then return keys and values without copying
+	// them, so they're only valid until the iterator is released
+	UseMmapReads bool
+
+	// PartitionedIndex : Whether the index of each new SSTable should be split into partitions, which are loaded
+	// through the block cache on demand (only the top-level index, with an entry per partition, is kept in memory)
+	PartitionedIndex bool
+
+	// Comparator : Defines the order of keys. A db must always be reopened with the same comparator
+	// nil uses BytewiseComparator
+	Comparator Comparator
+
+	// FS : The file system that the db's files are stored in. nil uses OSFS (i.e. the operating system's files)
+	FS FS
+
+	// Dir : The directory that the db's files (i.e. the journals, the LOCK file and the tables directory) are stored
+	// in. "" is the working directory
+	Dir string
+
+	// L0SlowdownWritesTrigger : Once there are this many SSTables (every table is in level 0, since tables aren't
+	// leveled), a background compaction is scheduled and each write is delayed by 1ms until it has caught up
+	// 0 uses the default (8), and a negative trigger never slows writes down
+	L0SlowdownWritesTrigger int
+
+	// L0StopWritesTrigger : Once there are this many SSTables, a write that fills the memtable blocks until a
+	// background compaction has caught up. 0 uses the default (12), and a negative trigger never stops writes
+	L0StopWritesTrigger int
+
+	// MaxImmutableMemtables : The maximum number of full memtables that can wait to be flushed in the background,
+	// after which a write that fills the memtable blocks until a flush has finished. 0 uses the default (1)
+	MaxImmutableMemtables int
+}
+
+func (opts *Options) fs() FS {
+	if opts.FS == nil {
+		return OSFS{}
+	}
+	return opts.FS
+}
+
+func (opts *Options) maxOpenFiles() int {
+	if opts.MaxOpenFiles == 0 {
+		return defaultMaxOpenFiles
+	}
+	return opts.MaxOpenFiles
+}
+
+func (opts *Options) comparator() Comparator {
+	if opts.Comparator == nil {
+		return BytewiseComparator{}
+	}
+	return opts.Comparator
+}
+
+// l0SlowdownWritesTrigger : Returns 0 if writes are never slowed down
+func (opts *Options) l0SlowdownWritesTrigger() int {
+	if opts.L0SlowdownWritesTrigger == 0 {
+		return defaultL0SlowdownWritesTrigger
+	} else if opts.L0SlowdownWritesTrigger < 0 {
+		return 0
+	}
+	return opts.L0SlowdownWritesTrigger
+}
+
+// l0StopWritesTrigger : Returns 0 if writes are never stopped
+func (opts *Options) l0StopWritesTrigger() int {
+	if opts.L0StopWritesTrigger == 0 {
+		return defaultL0StopWritesTrigger
+	} else if opts.L0StopWritesTrigger < 0 {
+		return 0
+	}
+	return opts.L0StopWritesTrigger
+}
+
+func (opts *Options) maxImmutableMemtables() int {
+	if opts.MaxImmutableMemtables <= 0 {
+		return defaultMaxImmutableMemtables
+	}
+	return opts.MaxImmutableMemtables
+}
+
+func (opts *Options) blockCacheCapacity() int64 {
+	if opts.BlockCacheCapacity == 0 {
+		return defaultBlockCacheCapacity
+	}
+	return opts.BlockCacheCapacity
+}
+
+// ReadOptions : Configures an iterator (a nil *ReadOptions uses the defaults)
+type ReadOptions struct {
+	// LowerBound : Only keys greater than or equal to LowerBound are visited (nil means unbounded)
+	LowerBound []byte
+
+	// UpperBound : Only keys less than UpperBound are visited (nil means unbounded)
+	UpperBound []byte
+
+	// FillCache : Whether the blocks read by the iterator should be cached (bulk scans can disable it, so that
+	// they don't evict the blocks that are read frequently)
+	FillCache bool
+
+	// Snapshot : Reads the db as of the snapshot (nil reads the current state of the db)
+	Snapshot *Snapshot
+
+	// VerifyChecksums : Whether every block read by the iterator should be verified against its checksum
+	VerifyChecksums bool
+}
+
+func defaultReadOptions() *ReadOptions {
+	return &ReadOptions{FillCache: true}
+}
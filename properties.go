@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	propertyPrefix        = "cleveldb."
+	numFilesAtLevelPrefix = propertyPrefix + "num-files-at-level"
+)
+
+var unknownPropertyErr = errors.New("unknown property")
+
+// compactionStats : The work done by flushes and compactions (every table is in level 0, so it's all done at level 0)
+type compactionStats struct {
+	flushes      int64
+	compactions  int64
+	duration     time.Duration
+	bytesRead    int64 // the size of the tables that were compacted
+	bytesWritten int64 // the size of the tables that were written by flushes and compactions
+}
+
+// GetProperty : Returns the value of one of the db's properties (or unknownPropertyErr):
+// - cleveldb.num-files-at-level<N> : The number of tables at level N. Tables aren't leveled (i.e. they're all in
+// level 0), so every other level is empty
+// - cleveldb.stats : Each level's number of tables and size, along with the time spent and bytes read/written by the
+// flushes and compactions
+// - cleveldb.sstables : Each table's file number, size and key range (most recent first)
+// - cleveldb.approximate-memory-usage : The bytes used by the memtables, the block cache and the tables' bloom filters
+// - cleveldb.estimate-num-keys : The number of entries in the memtables and tables (each table's number of entries is
+// stored in its header, so no tables are read). A key that was overwritten (or deleted) is counted once per table
+// that it's in, so it's an overestimate until the tables are compacted
+func (db *ClevelDB) GetProperty(name string) (string, error) {
+	db.mu.Lock()
+	memtables := append([]*Memtable{db.memtable}, db.immutables...)
+	tables := db.tables
+	stats := db.compactionStats
+	db.mu.Unlock()
+
+	if strings.HasPrefix(name, numFilesAtLevelPrefix) {
+		level, err := strconv.Atoi(strings.TrimPrefix(name, numFilesAtLevelPrefix))
+		if err != nil || level < 0 {
+			return "", fmt.Errorf("%w: %s", unknownPropertyErr, name)
+		}
+
+		if level > 0 {
+			return "0", nil
+		}
+		return strconv.Itoa(len(tables)), nil
+	}
+
+	switch name {
+	case propertyPrefix + "stats":
+		return formatStats(tables, stats), nil
+	case propertyPrefix + "sstables":
+		return formatSSTables(tables), nil
+	case propertyPrefix + "approximate-memory-usage":
+		var usage int64
+		for _, mem := range memtables {
+			usage += int64(mem.memoryUsage())
+		}
+		usage += db.BlockCacheStats().Size
+		for _, table := range tables {
+			if table.bloomFilter != nil {
+				usage += int64(table.bloomFilter.MemoryUsage())
+			}
+		}
+		return strconv.FormatInt(usage, 10), nil
+	case propertyPrefix + "estimate-num-keys":
+		var numKeys int64
+		for _, mem := range memtables {
+			numKeys += int64(mem.numEntries())
+		}
+		for _, table := range tables {
+			numKeys += table.numEntries
+		}
+		return strconv.FormatInt(numKeys, 10), nil
+	}
+
+	return "", fmt.Errorf("%w: %s", unknownPropertyErr, name)
+}
+
+// formatStats : Formats the stats as a table with a row per level (there's only level 0)
+func formatStats(tables []*SSTable, stats compactionStats) string {
+	var size int64
+	for _, table := range tables {
+		size += table.size
+	}
+
+	var b strings.Builder
+	b.WriteString("                               Compactions\n")
+	b.WriteString("Level  Files Size(MB) Time(sec) Read(MB) Write(MB)\n")
+	b.WriteString("--------------------------------------------------\n")
+	fmt.Fprintf(&b, "%3d %8d %8.2f %9.3f %8.2f %9.2f\n", 0, len(tables), megabytes(size), stats.duration.Seconds(),
+		megabytes(stats.bytesRead), megabytes(stats.bytesWritten))
+	fmt.Fprintf(&b, "Flushes: %d, Compactions: %d\n", stats.flushes, stats.compactions)
+	return b.String()
+}
+
+// formatSSTables : Lists each level's tables as "fileNum:size['smallest' .. 'largest']"
+func formatSSTables(tables []*SSTable) string {
+	var b strings.Builder
+	b.WriteString("--- level 0 ---\n")
+	for _, table := range tables {
+		fmt.Fprintf(&b, " %d:%d[%q .. %q]\n", table.fileNum, table.size, table.smallest, table.largest)
+	}
+	return b.String()
+}
+
+func megabytes(size int64) float64 {
+	return float64(size) / 1048576
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// getTestProperty : Returns the value of the db's property
+func getTestProperty(t *testing.T, db *ClevelDB, name string) string {
+	val, err := db.GetProperty(name)
+	if err != nil {
+		t.Fatalf("GetProperty(%s) returns unexpected error: %v", name, err)
+	}
+	return val
+}
+
+func Test_ClevelDBGetPropertyDescribesTables(t *testing.T) {
+	db := newTestClevelDB(t)
+	for i := 0; i < 30; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+		if i%10 == 9 {
+			flushTestMemtable(t, db)
+		}
+	}
+	_ = db.Put([]byte("key000"), []byte("updated"))
+
+	if val := getTestProperty(t, db, "cleveldb.num-files-at-level0"); val != "3" {
+		t.Errorf("GetProperty returns unexpected number of files at level 0: %s", val)
+	}
+	if val := getTestProperty(t, db, "cleveldb.num-files-at-level1"); val != "0" {
+		t.Errorf("GetProperty returns unexpected number of files at level 1: %s", val)
+	}
+	for _, name := range []string{"cleveldb.num-files-at-level", "cleveldb.num-files-at-level-1", "leveldb.stats"} {
+		if _, err := db.GetProperty(name); !errors.Is(err, unknownPropertyErr) {
+			t.Errorf("GetProperty(%s) returns unexpected error for unknown property: %v", name, err)
+		}
+	}
+
+	// Tables are listed most recent first
+	sstables := getTestProperty(t, db, "cleveldb.sstables")
+	expected := fmt.Sprintf("--- level 0 ---\n %d:%d[\"key020\" .. \"key029\"]\n", db.tables[0].fileNum, db.tables[0].size)
+	if !strings.HasPrefix(sstables, expected) || strings.Count(sstables, "\n") != 4 {
+		t.Errorf("GetProperty returns unexpected sstables: %s", sstables)
+	}
+
+	// The key that was overwritten is counted twice (once in the memtable, and once in its table)
+	if val := getTestProperty(t, db, "cleveldb.estimate-num-keys"); val != "31" {
+		t.Errorf("GetProperty returns unexpected number of keys: %s", val)
+	}
+
+	// Each table's bloom filter has 10 bits for each of its 10 keys (i.e. 13 bytes)
+	usage, _ := strconv.ParseInt(getTestProperty(t, db, "cleveldb.approximate-memory-usage"), 10, 64)
+	if expected := int64(db.memtable.size) + db.BlockCacheStats().Size + 3*13; usage != expected {
+		t.Errorf("GetProperty returns unexpected memory usage: %d (expected %d)", usage, expected)
+	}
+
+	// Compaction reads every table and writes a single table
+	var inputSize int64
+	for _, table := range db.tables {
+		inputSize += table.size
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact returns unexpected error: %v", err)
+	}
+	stats := getTestProperty(t, db, "cleveldb.stats")
+	if !strings.Contains(stats, fmt.Sprintf("%3d %8d", 0, 1)) || !strings.Contains(stats, "Flushes: 3, Compactions: 1") {
+		t.Errorf("GetProperty returns unexpected stats: %s", stats)
+	}
+	if db.compactionStats.bytesRead != inputSize {
+		t.Errorf("compaction reads unexpected number of bytes: %d (expected %d)", db.compactionStats.bytesRead, inputSize)
+	}
+}
+
+func Test_ClevelDBEstimatesKeysInLoadedTables(t *testing.T) {
+	db := newTestClevelDB(t)
+	for i := 0; i < 20; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+	flushTestMemtable(t, db)
+
+	dir := "checkpoint"
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatalf("error creating checkpoint: %v", err)
+	}
+
+	// The loaded table's number of entries is read from its header
+	loaded := loadTestDBFromDir(t, db.fs, dir, nil)
+	if val := getTestProperty(t, loaded, "cleveldb.estimate-num-keys"); val != "20" {
+		t.Errorf("GetProperty returns unexpected number of keys for loaded table: %s", val)
+	}
+	if loaded.tables[0].size != db.tables[0].size {
+		t.Errorf("loaded table has unexpected size: %d (expected %d)", loaded.tables[0].size, db.tables[0].size)
+	}
+}
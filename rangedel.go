@@ -0,0 +1,93 @@
+package main
+
+// rangeTombstone : Marks every key in [start, limit) as deleted
+// A range tombstone only shadows keys in older tables (i.e. keys in the same memtable/SSTable are always more
+// recent, because any existing keys in the memtable are deleted when the range tombstone is written)
+type rangeTombstone struct {
+	start []byte
+	limit []byte
+}
+
+func (t rangeTombstone) covers(cmp Comparator, key []byte) bool {
+	return cmp.Compare(key, t.start) >= 0 && cmp.Compare(key, t.limit) < 0
+}
+
+// coveredByRangeTombstone : Returns true if any of the range tombstones cover the key
+func coveredByRangeTombstone(cmp Comparator, tombstones []rangeTombstone, key []byte) bool {
+	for _, tombstone := range tombstones {
+		if tombstone.covers(cmp, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteRange : Deletes every key in [start, limit) with a single range tombstone
+func (db *ClevelDB) DeleteRange(start, limit []byte) error {
+	if db.comparator.Compare(start, limit) >= 0 {
+		return nil
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	err := db.makeRoomForWrite()
+	if err != nil {
+		return err
+	}
+
+	if db.journal {
+		_, err := writeRecordToFile(db.journalFile, DeleteRange, start, limit, 0, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	db.memtable.DeleteRange(start, limit)
+	return nil
+}
+
+// DeleteRange : Replaces every existing key in [start, limit) with a tombstone, and stores a range tombstone so
+// that keys in [start, limit) are also deleted from the SSTables
+func (mem *Memtable) DeleteRange(start, limit []byte) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	current, _ := mem.Get(start)
+	for ; current != nil && mem.cmp.Compare(current.key, limit) < 0; current = current.ptrs[0] {
+		mem.size -= len(current.val)
+		for _, operand := range current.operands {
+			mem.size -= len(operand)
+		}
+
+		current.val = nil
+		current.expiresAt = 0
+		current.operands = nil
+	}
+
+	mem.rangeTombstones = append(mem.rangeTombstones, rangeTombstone{start: start, limit: limit})
+	mem.size += len(start) + len(limit)
+}
+
+// rangeDelIterator : Wraps a memtable/SSTable iterator, and reports keys that have been deleted by a range
+// tombstone (in a more recent table) as tombstones
+type rangeDelIterator struct {
+	Iterator
+	tombstones []rangeTombstone
+	cmp        Comparator
+}
+
+func (i *rangeDelIterator) Value() []byte {
+	if coveredByRangeTombstone(i.cmp, i.tombstones, i.Key()) {
+		return nil
+	}
+	return i.Iterator.Value()
+}
+
+func (i *rangeDelIterator) operands() [][]byte {
+	iterator, ok := i.Iterator.(mergeOperandIterator)
+	if !ok || coveredByRangeTombstone(i.cmp, i.tombstones, i.Key()) {
+		return nil
+	}
+	return iterator.operands()
+}
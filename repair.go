@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	lostDir           = "lost"
+	repairFilename    = "repair_%d.tmp"
+	maxLostFileSuffix = 1000
+)
+
+// RepairReport : Describes what Repair recovered (and what it couldn't)
+type RepairReport struct {
+	Tables           int      // number of tables in the repaired db
+	SalvagedTables   int      // number of damaged tables whose readable entries were rewritten into new tables
+	RecoveredEntries int      // number of entries that were recovered from damaged tables
+	JournalRecords   int      // number of journal records that were kept
+	LostFiles        []string // files (relative to the db's directory) that were moved into the lost directory
+}
+
+// Repair : Recovers as much as possible of a damaged db in the given directory (e.g. one that loadClevelDB can't
+// load), so that it can be loaded again. The db can't be open while it's repaired (Repair takes its LOCK file)
+// - Every table is validated (see SSTable.validate). A damaged table is moved into the lost directory, and the
+// entries from its readable blocks are rewritten into a new table with the same file number (so the new table has
+// the same recency as the damaged one). The readable blocks are found through the table's index, or by decoding
+// records one at a time from the beginning of the table if its header or index is unreadable (a table without a
+// header loses its journal number, which is recovered from the journals, see flushedJournal, as long as
+// opts.MergeOperator is set when the journals have merge operands)
+// - Each journal is truncated after its last readable record (the original is moved into the lost directory)
+// - A committed ingestion is finished (see finishIngestion), while unknown files in the tables directory (e.g. the
+// copy of an ingestion that wasn't committed) are moved into the lost directory
+// - The MANIFEST is rewritten (a corrupt MANIFEST is moved into the lost directory first)
+//
+// There are no sequence numbers (tables are ordered by their file numbers), and each table's key range is read
+// from the table itself when it's loaded, so neither needs to be recomputed
+// Note: records that are salvaged without an index aren't covered by a checksum. Decoding stops at the first
+// record that's corrupt (or out of order), so the entries after it are lost
+func Repair(dir string, opts *Options) (*RepairReport, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cmp := opts.comparator()
+	tablesDir := filepath.Join(dir, ssTablesDir)
+
+	fs := opts.fs()
+
+	// The db can't be repaired while it's open
+	err := fs.MkdirAll(dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := fs.Lock(filepath.Join(dir, lockFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	r := &repairer{
+		fs:            fs,
+		dir:           dir,
+		cmp:           cmp,
+		mergeOperator: opts.MergeOperator,
+		partitioned:   opts.PartitionedIndex,
+		report:        &RepairReport{},
+	}
+	err = r.repair(tablesDir)
+	if err != nil {
+		return nil, err
+	}
+	return r.report, nil
+}
+
+// repairer : The state of a single Repair
+type repairer struct {
+	fs            FS
+	dir           string
+	cmp           Comparator
+	mergeOperator MergeOperator // needed to replay journals with merge operands (see flushedJournal)
+	partitioned   bool          // whether the salvaged tables' indexes are partitioned
+	report        *RepairReport
+}
+
+func (r *repairer) repair(tablesDir string) error {
+	// A readable MANIFEST with another comparator means that the db's keys are ordered differently, in which case
+	// "repairing" the db would discard most of its entries
+	m, err := readManifest(r.fs, tablesDir)
+	if err == nil && m.comparator != r.cmp.Name() {
+		return fmt.Errorf("%w: %s (db uses %s)", comparatorMismatchErr, r.cmp.Name(), m.comparator)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		err = r.moveToLost(filepath.Join(ssTablesDir, manifestFilename))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.fs.MkdirAll(tablesDir)
+	if err != nil {
+		return err
+	}
+
+	// A committed ingestion is finished first, so that its copies are validated like any other table (an unreadable
+	// INGEST file is moved into the lost directory, and its copies with it)
+	err = finishIngestion(r.fs, tablesDir)
+	if err != nil {
+		err = r.moveToLost(filepath.Join(ssTablesDir, ingestCommitFilename))
+		if err != nil {
+			return err
+		}
+	}
+
+	filenames, err := r.fs.List(tablesDir)
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range filenames {
+		if filename == manifestFilename {
+			continue
+		}
+
+		relPath := filepath.Join(ssTablesDir, filename)
+		fileNum := parseFileNum(filename)
+		info, err := r.fs.Stat(filepath.Join(tablesDir, filename))
+		if err != nil {
+			return err
+		}
+
+		if fileNum == 0 || info.IsDir() {
+			err = r.moveToLost(relPath)
+		} else {
+			err = r.repairTable(relPath, fileNum)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	journalNums, err := listJournals(r.fs, r.dir)
+	if err != nil {
+		return err
+	}
+	for _, journalNum := range journalNums {
+		err = r.repairJournal(fmt.Sprintf(journalFilename, journalNum))
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeManifest(r.fs, tablesDir, &manifest{comparator: r.cmp.Name()})
+}
+
+// repairTable : Leaves a valid table as is, and otherwise replaces it with a table of its readable entries
+func (r *repairer) repairTable(relPath string, fileNum int) error {
+	path := filepath.Join(r.dir, relPath)
+
+	table, reader, err := loadSSTable(r.fs, path)
+	if err == nil {
+		err = table.validate(reader, r.cmp)
+		_ = reader.file.Close()
+		if err == nil {
+			r.report.Tables++
+			return nil
+		}
+	}
+
+	entries, tombstones, journalNum, err := r.salvageTable(path, fileNum)
+	if err != nil {
+		return err
+	}
+
+	// The salvaged table is written under a temporary name, so that the damaged table is only replaced once its
+	// replacement is complete
+	var tmpPath string
+	if len(entries) > 0 || len(tombstones) > 0 {
+		tmpPath = filepath.Join(r.dir, ssTablesDir, fmt.Sprintf(repairFilename, fileNum))
+		err = r.writeSalvagedTable(tmpPath, entries, tombstones, journalNum)
+		if err != nil {
+			_ = r.fs.Remove(tmpPath)
+			return err
+		}
+	}
+
+	err = r.moveToLost(relPath)
+	if err != nil {
+		return err
+	}
+
+	// A table without any readable entries is dropped
+	if tmpPath == "" {
+		return nil
+	}
+
+	err = r.fs.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	r.report.Tables++
+	r.report.SalvagedTables++
+	r.report.RecoveredEntries += len(entries)
+	return nil
+}
+
+// salvageTable : Returns the entries (in order) and range tombstones that can still be read from a damaged table,
+// along with the table's journal number (which is recovered from the journals if its header is unreadable, see
+// flushedJournal)
+func (r *repairer) salvageTable(path string, fileNum int) ([]blockEntry, []rangeTombstone, int, error) {
+	file, err := r.fs.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var entries []blockEntry
+	add := func(candidates []blockEntry) {
+		for _, entry := range candidates {
+			// Range tombstones are stored in their own block, so a DeleteRange record is as corrupt as an unknown op
+			if entry.op > Merge || entry.op == DeleteRange {
+				return
+			}
+			if len(entries) > 0 && r.cmp.Compare(entry.key, entries[len(entries)-1].key) <= 0 {
+				return
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	header, err := readHeader(file)
+	if err != nil {
+		// Without a header, the records are decoded from the beginning of the table until one of them is corrupt
+		add(decodeRecords(file, headerSizeInBytes, info.Size()))
+		return entries, nil, r.flushedJournal(fileNum, entries), nil
+	}
+
+	// The range tombstones are only kept if the whole range deletion block is readable
+	tombstones, err := loadRangeTombstonesFromSSTable(file, header.rangeDelOffset)
+	if err != nil {
+		tombstones = nil
+	}
+
+	index, err := loadIndexFromSSTable(file, header)
+	if err != nil {
+		add(decodeRecords(file, headerSizeInBytes, header.indexOffset))
+		return entries, tombstones, header.journalNum, nil
+	}
+
+	// Every block whose checksum matches is kept (i.e. a damaged block only loses its own entries)
+	ss := &SSTable{cmp: r.cmp}
+	reader := &tableReader{file: file, index: index}
+	ro := &ReadOptions{VerifyChecksums: true}
+
+	numPartitions := 0
+	if len(index.blocks) > 0 {
+		numPartitions = index.numPartitions()
+	}
+
+	for partitionIdx := 0; partitionIdx < numPartitions; partitionIdx++ {
+		blocks, err := ss.partition(reader, partitionIdx, ro)
+		if err != nil {
+			continue
+		}
+
+		for _, block := range blocks {
+			blockEntries, err := reader.readBlock(block, true)
+			if err == nil {
+				add(blockEntries)
+			}
+		}
+	}
+
+	return entries, tombstones, header.journalNum, nil
+}
+
+// flushedJournal : Returns the number of the journal that a table (whose header is unreadable) was flushed from, if
+// the journal wasn't removed (i.e. the db crashed after the table was installed), and 0 otherwise
+// The journal would otherwise be replayed on top of the salvaged table, which applies its merge operands twice. A
+// journal was flushed into the table if replaying it gives the table's (salvaged) entries, while the journals of the
+// memtables that hadn't been flushed yet give different entries, so they're still replayed
+func (r *repairer) flushedJournal(fileNum int, entries []blockEntry) int {
+	journalNums, err := listJournals(r.fs, r.dir)
+	if err != nil || len(entries) == 0 {
+		return 0
+	}
+
+	// Journals are flushed (and removed) oldest first, and a table's journal was created before the table
+	for _, journalNum := range journalNums {
+		if journalNum >= fileNum {
+			break
+		}
+
+		mem, err := r.replayJournal(fmt.Sprintf(journalFilename, journalNum))
+		if err != nil {
+			continue
+		}
+
+		// The salvaged entries may be missing the records after a corrupt one, but they're otherwise the same as
+		// the memtable's
+		node := mem.header.ptrs[0]
+		flushed := true
+		for _, entry := range entries {
+			record := encodeRecord(entry.op, entry.key, entry.val, entry.expiresAt)
+			if node == nil || !bytes.Equal(record, nodeRecord(node)) {
+				flushed = false
+				break
+			}
+			node = node.ptrs[0]
+		}
+		if flushed {
+			return journalNum
+		}
+	}
+	return 0
+}
+
+// replayJournal : Replays the readable records of the journal (whose filename is relative to the db's directory) into
+// a new memtable, the same as loading the db would (see ClevelDB.replayJournal)
+func (r *repairer) replayJournal(filename string) (*Memtable, error) {
+	data, err := readFile(r.fs, filepath.Join(r.dir, filename))
+	if err != nil {
+		return nil, err
+	}
+
+	mem := newMemtable(r.cmp)
+	for len(data) > 0 {
+		entry, n, err := decodeRecord(data)
+		if err != nil || !validJournalRecord(entry, r.cmp) {
+			break
+		}
+		data = data[n:]
+
+		switch entry.op {
+		case Delete:
+			mem.Put(entry.key, nil, 0)
+		case Insert, InsertWithTTL:
+			mem.Put(entry.key, entry.val, entry.expiresAt)
+		case DeleteRange:
+			mem.DeleteRange(entry.key, entry.val)
+		case Merge:
+			// The operands can't be combined without the merge operator
+			if r.mergeOperator == nil {
+				return nil, noMergeOperatorErr
+			}
+
+			operands, _ := decodeOperands(entry.val)
+			for _, operand := range operands {
+				err = mem.Merge(entry.key, operand, r.mergeOperator)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return mem, nil
+}
+
+// decodeRecords : Decodes records from the file (within [start, end)), stopping at the first corrupt record
+func decodeRecords(file File, start, end int64) []blockEntry {
+	if end <= start {
+		return nil
+	}
+
+	buf := make([]byte, end-start)
+	n, _ := file.ReadAt(buf, start)
+	buf = buf[:n]
+
+	var entries []blockEntry
+	for len(buf) > 0 {
+		entry, n, err := decodeRecord(buf)
+		if err != nil {
+			break
+		}
+
+		entries = append(entries, entry)
+		buf = buf[n:]
+	}
+	return entries
+}
+
+// writeSalvagedTable : Writes the entries (which are in order) and range tombstones to a new table
+func (r *repairer) writeSalvagedTable(path string, entries []blockEntry, tombstones []rangeTombstone, journalNum int) error {
+	file, err := r.fs.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	builder, err := newTableBuilder(file, r.cmp, r.partitioned)
+	if err != nil {
+		return err
+	}
+	builder.journalNum = journalNum
+
+	for _, entry := range entries {
+		err = builder.add(entry.key, encodeRecord(entry.op, entry.key, entry.val, entry.expiresAt))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, _, err = builder.finish(tombstones)
+	return err
+}
+
+// repairJournal : Truncates the journal (whose filename is relative to the db's directory) after its last readable
+// record
+func (r *repairer) repairJournal(filename string) error {
+	path := filepath.Join(r.dir, filename)
+	data, err := readFile(r.fs, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	valid := 0
+	for valid < len(data) {
+		entry, n, err := decodeRecord(data[valid:])
+		if err != nil || !validJournalRecord(entry, r.cmp) {
+			break
+		}
+
+		valid += n
+		r.report.JournalRecords++
+	}
+
+	if valid == len(data) {
+		return nil
+	}
+
+	err = r.moveToLost(filename)
+	if err != nil {
+		return err
+	}
+	return writeFile(r.fs, path, data[:valid])
+}
+
+// validJournalRecord : Returns true if the record can be replayed (see replayJournal)
+func validJournalRecord(entry blockEntry, cmp Comparator) bool {
+	switch entry.op {
+	case Delete, Insert, InsertWithTTL:
+		return true
+	case DeleteRange:
+		return cmp.Compare(entry.key, entry.val) < 0
+	case Merge:
+		_, err := decodeOperands(entry.val)
+		return err == nil
+	}
+	return false
+}
+
+// moveToLost : Moves the file (whose path is relative to the db's directory) into the lost directory
+// A suffix is added if the lost directory already has a file with the same name (e.g. from an earlier repair)
+func (r *repairer) moveToLost(relPath string) error {
+	err := r.fs.MkdirAll(filepath.Join(r.dir, lostDir))
+	if err != nil {
+		return err
+	}
+
+	lostPath := filepath.Join(r.dir, lostDir, filepath.Base(relPath))
+	for suffix := 1; exists(r.fs, lostPath); suffix++ {
+		if suffix > maxLostFileSuffix {
+			return fmt.Errorf("too many lost copies of %s", relPath)
+		}
+		lostPath = filepath.Join(r.dir, lostDir, filepath.Base(relPath)+"."+strconv.Itoa(suffix))
+	}
+
+	err = r.fs.Rename(filepath.Join(r.dir, relPath), lostPath)
+	if err != nil {
+		return err
+	}
+
+	r.report.LostFiles = append(r.report.LostFiles, relPath)
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDamagedDB : Returns the directory of a db with two tables (key000-key019 and key020-key039) and a journal
+// with two records, which the test can then damage
+func newTestDamagedDB(t *testing.T) (FS, string) {
+	db := newTestClevelDB(t)
+	for i := 0; i < 40; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+		if i%20 == 19 {
+			flushTestMemtable(t, db)
+		}
+	}
+	_ = db.Put([]byte("journal1"), []byte("value"))
+	_ = db.Put([]byte("journal2"), []byte("value"))
+
+	dir := "db"
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatalf("error creating db directory: %v", err)
+	}
+	return db.fs, dir
+}
+
+// loadTestDBErr : Returns the error from loading the db in the directory
+func loadTestDBErr(fs FS, dir string) error {
+	db, err := loadClevelDB(&Options{FS: fs, Dir: dir})
+	if db != nil {
+		_ = db.Close()
+	}
+	return err
+}
+
+// damageTestFile : Overwrites part of the file (a negative offset is relative to the end of the file)
+func damageTestFile(t *testing.T, fs FS, path string, offset int64, damage []byte) {
+	data, err := readFile(fs, path)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", path, err)
+	}
+
+	if offset < 0 {
+		offset += int64(len(data))
+	}
+	copy(data[offset:], damage)
+
+	if err := writeFile(fs, path, data); err != nil {
+		t.Fatalf("error damaging %s: %v", path, err)
+	}
+}
+
+func Test_RepairSalvagesTableWithCorruptHeader(t *testing.T) {
+	fs, dir := newTestDamagedDB(t)
+	damaged := filepath.Join(dir, ssTablesDir, fmt.Sprintf(ssTableFilename, 1))
+
+	// The header points past the end of the file
+	damageTestFile(t, fs, damaged, 0, []byte{0xff, 0xff, 0xff, 0xff})
+	if err := loadTestDBErr(fs, dir); !errors.Is(err, corruptSSTableErr) {
+		t.Fatalf("loadClevelDB returns unexpected error for corrupt header: %v", err)
+	}
+
+	report, err := Repair(dir, &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Repair returns unexpected error: %v", err)
+	}
+	if report.Tables != 2 || report.SalvagedTables != 1 || report.RecoveredEntries != 20 || report.JournalRecords != 2 {
+		t.Errorf("Repair returns unexpected report: %+v", report)
+	}
+	if len(report.LostFiles) != 1 || report.LostFiles[0] != filepath.Join(ssTablesDir, "segment_1.ss") {
+		t.Errorf("Repair returns unexpected lost files: %v", report.LostFiles)
+	}
+	if _, err := fs.Stat(filepath.Join(dir, lostDir, "segment_1.ss")); err != nil {
+		t.Errorf("Repair doesn't move damaged table into lost directory: %v", err)
+	}
+
+	db := loadTestDBFromDir(t, fs, dir, nil)
+	for _, key := range []string{"key000", "key019", "key020", "key039", "journal2"} {
+		if val, err := db.Get([]byte(key)); string(val) != "value" || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value after repair: "%s" (%v)`, key, val, err)
+		}
+	}
+}
+
+func Test_RepairDoesntReplayJournalOfTableWithCorruptHeader(t *testing.T) {
+	fs := NewMemFS()
+	opts := &Options{FS: fs, Dir: "db", MergeOperator: StringAppendOperator{Delimiter: []byte(",")}}
+	db, err := loadClevelDB(opts)
+	if err != nil {
+		t.Fatalf("error loading db: %v", err)
+	}
+
+	for i := 0; i < 12; i++ {
+		_ = db.Merge([]byte(fmt.Sprintf("key%d", i%3)), []byte(fmt.Sprint(i)))
+	}
+	journalPath := db.journalPath(db.memtable.journalNum)
+	journal, _ := readFile(fs, journalPath)
+	flushTestMemtable(t, db)
+	damaged := filepath.Join("db", ssTablesDir, fmt.Sprintf(ssTableFilename, db.tables[0].fileNum))
+
+	// The next memtable's journal is older than the table (it was created before the table was flushed), but it
+	// hasn't been flushed, so it's still replayed
+	_ = db.Merge([]byte("key0"), []byte("unflushed"))
+	_ = db.Close()
+
+	// The db crashed after the table was installed, but before the journal was removed, and then the table's header
+	// was damaged
+	_ = writeFile(fs, journalPath, journal)
+	damageTestFile(t, fs, damaged, 0, []byte{0xff, 0xff, 0xff, 0xff})
+
+	report, err := Repair("db", opts)
+	if err != nil {
+		t.Fatalf("Repair returns unexpected error: %v", err)
+	}
+	if report.SalvagedTables != 1 || report.RecoveredEntries != 3 {
+		t.Errorf("Repair returns unexpected report: %+v", report)
+	}
+
+	db = loadTestDBFromDir(t, fs, "db", opts)
+	expected := map[string]string{"key0": "0,3,6,9,unflushed", "key1": "1,4,7,10", "key2": "2,5,8,11"}
+	for key, expectedVal := range expected {
+		if val, err := db.Get([]byte(key)); string(val) != expectedVal || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value after repair: "%s" (%v)`, key, val, err)
+		}
+	}
+	if exists(fs, journalPath) {
+		t.Errorf("loadClevelDB doesn't remove flushed journal: %s", journalPath)
+	}
+}
+
+func Test_RepairDropsCorruptBlocksAndJournalTail(t *testing.T) {
+	fs, dir := newTestDamagedDB(t)
+	opts := &Options{FS: fs}
+	damaged := filepath.Join(dir, ssTablesDir, fmt.Sprintf(ssTableFilename, 2))
+
+	// The first record of the table (key020) no longer matches its block's checksum
+	damageTestFile(t, fs, damaged, headerSizeInBytes+4, []byte("X"))
+
+	// The journal's last record is only partially written, and the MANIFEST is unreadable
+	journalNums, _ := listJournals(fs, dir)
+	journal := filepath.Join(dir, fmt.Sprintf(journalFilename, journalNums[len(journalNums)-1]))
+	data, _ := readFile(fs, journal)
+	_ = writeFile(fs, journal, data[:len(data)-3])
+	_ = writeFile(fs, filepath.Join(dir, ssTablesDir, manifestFilename), []byte("corrupt\n"))
+
+	if err := loadTestDBErr(fs, dir); err == nil {
+		t.Fatalf("loadClevelDB returns no error for damaged db")
+	}
+
+	report, err := Repair(dir, opts)
+	if err != nil {
+		t.Fatalf("Repair returns unexpected error: %v", err)
+	}
+	if report.Tables != 2 || report.SalvagedTables != 1 || report.RecoveredEntries >= 20 || report.JournalRecords != 1 {
+		t.Errorf("Repair returns unexpected report: %+v", report)
+	}
+	if len(report.LostFiles) != 3 {
+		t.Errorf("Repair returns unexpected lost files: %v", report.LostFiles)
+	}
+
+	db := loadTestDBFromDir(t, fs, dir, nil)
+	for _, key := range []string{"key000", "key019", "key039", "journal1"} {
+		if val, err := db.Get([]byte(key)); string(val) != "value" || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value after repair: "%s" (%v)`, key, val, err)
+		}
+	}
+	for _, key := range []string{"key020", "journal2"} {
+		if val, err := db.Get([]byte(key)); err != notFoundInDBErr {
+			t.Errorf(`storage.Get("%s") returns unexpected value for lost key: "%s" (%v)`, key, val, err)
+		}
+	}
+
+	// The db can't be repaired while it's open, and repairing an intact db doesn't change anything
+	if _, err := Repair(dir, opts); err != dbLockedErr {
+		t.Errorf("Repair returns unexpected error for open db: %v", err)
+	}
+	_ = db.Close()
+
+	report, err = Repair(dir, opts)
+	if err != nil || report.SalvagedTables != 0 || len(report.LostFiles) != 0 {
+		t.Errorf("Repair returns unexpected report for intact db: %+v (%v)", report, err)
+	}
+	if _, err := Repair(dir, &Options{FS: fs, Comparator: ReverseBytewiseComparator{}}); !errors.Is(err, comparatorMismatchErr) {
+		t.Errorf("Repair returns unexpected error for mismatched comparator: %v", err)
+	}
+}
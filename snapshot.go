@@ -0,0 +1,35 @@
+package main
+
+// Snapshot : A consistent, read-only view of the db as of the time the snapshot was taken
+// Since entries don't have sequence numbers, a snapshot keeps its own copy of the memtable (which is small), and
+// holds a reference to each SSTable so that compaction can't close their files while the snapshot is in use
+// The full memtables that are waiting to be flushed are never written to again, so they're shared rather than copied
+type Snapshot struct {
+	db        *ClevelDB
+	memtables []*Memtable // newest first (see ClevelDB.current)
+	tables    []*SSTable
+}
+
+// NewSnapshot : Returns a snapshot of the current state of the db, which should be released once it's no longer needed
+// Writes are blocked while the memtable is copied, so that the copy doesn't include part of a write (or race with it)
+func (db *ClevelDB) NewSnapshot() *Snapshot {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	memtables, tables := db.current()
+	memtables[0] = memtables[0].clone()
+
+	return &Snapshot{db: db, memtables: memtables, tables: tables}
+}
+
+// Get : Same as ClevelDB.Get, except the key is read as of the snapshot
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	return s.db.get(s.memtables, s.tables, key)
+}
+
+// Release : Releases the snapshot's references to the SSTables (the snapshot can't be read afterward)
+func (s *Snapshot) Release() {
+	unrefTables(s.tables)
+	s.tables = nil
+	s.memtables = []*Memtable{newMemtable(s.db.comparator)}
+}
@@ -1,324 +1,790 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"log"
-	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
 )
 
 const ssTablesDir = "sstables/"
-const ssTableFilename = ssTablesDir + "segment_%d.ss"
-const indexOffsetSizeInBytes = 4
+const ssTableFilename = "segment_%d.ss"
+
+// New tables are written under a temporary name, and only renamed to their segment name once they're complete
+const tableTmpFilename = "table_%d.tmp"
+
+// The header stores the offsets of the index, of the filter block and of the range deletion block (each as a uint32),
+// followed by the type of the index (as a uint8), the table's journal number (as a uint32, see SSTable.journalNum) and
+// the number of records in the table (as a uint32), and a checksum of the table's contents (as a uint32, see
+// tableBuilder.finish)
+const headerSizeInBytes = 25
+
+const (
+	singleLevelIndex uint8 = iota
+	partitionedIndex
+)
 
 // Purposely kept small for testing; should ideally be a multiple of disk block size (e.g. 4KB)
 const indexBlockSizeInBytes = 20
 
+var checksumMismatchErr = errors.New("block checksum mismatch")
+var corruptSSTableErr = errors.New("corrupt SSTable")
+
+// SSTable : The metadata of an SSTable, which is kept in memory for the lifetime of the table
+// The table's file (and its index) are only opened on demand through the table cache
 type SSTable struct {
-	file        *os.File
-	index       *Index
-	bloomFilter *BloomFilter
+	fileNum         int
+	path            string
+	rangeTombstones []rangeTombstone
+	bloomFilter     *BloomFilter
+	smallest        []byte // smallest and largest keys in the table (nil if the table has no keys)
+	largest         []byte
+	refs            int32 // the db, snapshots and iterators each hold a reference
+	obsolete        bool  // set once compaction has replaced the table (its file is removed once there are no references)
+	blockCache      *BlockCache
+	tableCache      *TableCache
+	cmp             Comparator
+	fs              FS     // the file system that the table's file is stored in
+	size            int64  // the size of the table's file (in bytes)
+	numEntries      int64  // the number of records in the table (stored in the header)
+	checksum        uint32 // the checksum of the table's contents (stored in the header, see tableBuilder.finish)
+	metrics         *metrics
+
+	// The most recent journal whose records are in the table (or in an older table), i.e. the journal of the
+	// memtable that the table was flushed from, or the most recent journal of the tables that it was compacted from
+	// (0 if the table wasn't written from a journal, e.g. an ingested table)
+	journalNum int
 }
 
-type Index struct {
-	blocks []indexBlock
-	offset int64
-}
+// flushMemtable : Flushes the memtable in the foreground (e.g. before ingesting files that overlap it), after the full
+// memtables that are waiting to be flushed, so that tables are still flushed in the order that their keys were written
+// The background work must be paused (see pauseBackgroundWork). Same as a background flush, the db can't be written
+// to once a flush fails
+func flushMemtable(db *ClevelDB) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.bgErr != nil {
+		return db.bgErr
+	}
+
+	// An empty memtable has nothing to flush (but the full memtables are still flushed)
+	if db.memtable.header.ptrs[0] != nil || len(db.memtable.rangeTombstones) > 0 {
+		full, err := db.switchMemtable()
+		if err != nil {
+			return err
+		}
+		db.immutables = append(db.immutables, full)
+	}
 
-type indexBlock struct {
-	key    []byte
-	offset int64
-	size   int64
+	for len(db.immutables) > 0 && db.bgErr == nil {
+		db.bgErr = db.flushImmutable()
+	}
+	return db.bgErr
 }
 
-func flushMemtable(db *ClevelDB, file *os.File) (*SSTable, error) {
-	// Clear contents of file
-	err := file.Truncate(0)
+// writeMemtable : Writes the (full) memtable to a new table, and removes the memtable's journal once the table is
+// installed (a crash in between leaves the journal behind, which isn't replayed since its records are in the table)
+func (db *ClevelDB) writeMemtable(file File, mem *Memtable) (*SSTable, error) {
+	start := time.Now()
+
+	ssTable, reader, err := writeSSTable(file, mem, db.partitionedIndex)
+	if err != nil {
+		return nil, err
+	}
+	err = db.installTableFile(ssTable, reader)
 	if err != nil {
 		return nil, err
 	}
+	db.initTable(ssTable, reader)
 
-	db.flushingMemtable = db.memtable
-	db.memtable = newMemtable()
+	err = db.removeJournal(mem)
+	if err != nil {
+		return nil, err
+	}
 
-	// Begin reading from first node of skip list (at the node's lowest level)
-	current := db.flushingMemtable.header.ptrs[0]
+	db.mu.Lock()
+	db.compactionStats.flushes++
+	db.compactionStats.duration += time.Since(start)
+	db.compactionStats.bytesWritten += ssTable.size
+	db.mu.Unlock()
+	db.metrics.flushDuration.observeSince(start)
 
-	var currentOffset int64
-	var currentBlockSize int
-	var indexBlocks []indexBlock
+	return ssTable, nil
+}
 
-	// Reserve some space to store the index offset (once we know where the index will begin)
-	_, err = file.Seek(indexOffsetSizeInBytes, io.SeekStart)
+// installTableFile : Renames a table that was written under a temporary name (see newTableFile) to its segment
+// name. The table's file is synced before it's renamed, so a partially written table is never loaded after a crash
+func (db *ClevelDB) installTableFile(ss *SSTable, reader *tableReader) error {
+	fileNum, ok := scanFileNum(filepath.Base(ss.path), tableTmpFilename)
+	if !ok {
+		return fmt.Errorf("unexpected table filename %s", ss.path)
+	}
+
+	path := filepath.Join(db.tablesDir, fmt.Sprintf(ssTableFilename, fileNum))
+	err := db.fs.Rename(ss.path, path)
 	if err != nil {
-		return nil, err
+		_ = reader.file.Close()
+		_ = db.fs.Remove(ss.path)
+		return err
+	}
+
+	ss.fileNum, ss.path, reader.fileNum = fileNum, path, fileNum
+	return nil
+}
+
+// writeSSTable : Writes every key-value pair in the memtable (including tombstones) to the file, followed by
+// the index and the memtable's range tombstones
+// File layout: header | key-value pairs | index | filter block | range deletion block
+// If partitioned is set, the index is split into partitions (see writeIndex)
+// Returns the table's metadata along with a reader (for the file, which is still open)
+func writeSSTable(file File, mem *Memtable, partitioned bool) (*SSTable, *tableReader, error) {
+	// Begin reading from first node of skip list (at the node's lowest level)
+	current := mem.header.ptrs[0]
+	if current == nil && len(mem.rangeTombstones) == 0 {
+		return nil, nil, errors.New("cannot write an empty memtable to an SSTable")
 	}
 
-	// Initialize the first block (which starts immediately after the index offset)
-	activeBlock := indexBlock{
-		key:    current.key,
-		offset: indexOffsetSizeInBytes,
+	builder, err := newTableBuilder(file, mem.cmp, partitioned)
+	if err != nil {
+		return nil, nil, err
 	}
+	builder.journalNum = mem.journalNum
 
-	// Write "sorted" key-value pairs to file while also accumulating  "sorted" index blocks
-	for current != nil {
-		numBytes, err := writeKeyValPairToFile(file, current.key, current.val, false)
+	// Write "sorted" key-value pairs to file while the builder accumulates "sorted" index blocks
+	for ; current != nil; current = current.ptrs[0] {
+		err = builder.add(current.key, nodeRecord(current))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+	}
 
-		currentBlockSize += numBytes
+	return builder.finish(mem.rangeTombstones)
+}
 
-		currentOffset, err = file.Seek(0, io.SeekCurrent)
-		if err != nil {
-			fmt.Printf("Error retrieving current offset: %v\n", err)
-			return nil, err
+// nodeRecord : Encodes a memtable node as the record that's written to an SSTable (i.e. the node's merge operands, or
+// its value)
+func nodeRecord(node *SkipListNode) []byte {
+	if node.operands != nil {
+		return encodeRecord(Merge, node.key, encodeOperands(node.operands), 0)
+	}
+	return encodeRecord(keyValOp(node.val, node.expiresAt), node.key, node.val, node.expiresAt)
+}
+
+// tableBuilder : Writes an SSTable one record at a time (records must be added in sorted order), splitting the
+// records into blocks as it goes
+type tableBuilder struct {
+	file        *checksumFile
+	cmp         Comparator
+	partitioned bool
+	offset      int64 // where the next record will be written
+	blocks      []indexBlock
+	activeBlock indexBlock
+	blockSize   int // size of the active block (0 if the next record starts a new block)
+	largest     []byte
+	numEntries  int64
+	keyHashes   []uint32 // the hash of every key in the table (the bloom filter is sized by finish, see bloomHash)
+	journalNum  int      // see SSTable.journalNum
+}
+
+func newTableBuilder(file File, cmp Comparator, partitioned bool) (*tableBuilder, error) {
+	// Clear contents of file
+	err := file.Truncate(0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reserve some space to store the header (once we know where the index and range deletion block will begin)
+	_, err = file.Seek(headerSizeInBytes, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tableBuilder{
+		file:        &checksumFile{File: file},
+		cmp:         cmp,
+		partitioned: partitioned,
+		offset:      headerSizeInBytes,
+	}, nil
+}
+
+// checksumFile : Updates the checksum of the table's contents as they're written (the header is written last, at the
+// beginning of the file, so it isn't part of the checksum until the table is finished)
+type checksumFile struct {
+	File
+	checksum uint32
+}
+
+func (f *checksumFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.checksum = crc32.Update(f.checksum, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+// add : Writes the key's (encoded) record, which must sort after every key that was added before it
+// The key is copied, since the table keeps its index keys (and its largest key) after the caller's key is gone
+// (e.g. a key read from a memory-mapped table that compaction removes)
+func (b *tableBuilder) add(key, record []byte) error {
+	key = append([]byte(nil), key...)
+
+	// Initialize the next block (which starts immediately after the header, or after the previous block)
+	// A block's index key only needs to separate it from the previous block, so it can be shorter than its first key
+	if b.blockSize == 0 {
+		blockKey := key
+		if b.largest != nil {
+			blockKey = indexSeparator(b.cmp, b.largest, key)
 		}
+		b.activeBlock = indexBlock{key: blockKey, offset: b.offset}
+	}
 
-		current = current.ptrs[0]
+	numBytes, err := b.file.Write(record)
+	if err != nil {
+		return errors.New("error writing to file")
+	}
 
-		// Once we reach end of skip list or size of index block crosses threshold, append to blocks slice
-		if currentBlockSize >= indexBlockSizeInBytes || current == nil {
-			activeBlock.size = int64(currentBlockSize)
-			currentBlockSize = 0
-			indexBlocks = append(indexBlocks, activeBlock)
+	b.offset += int64(numBytes)
+	b.blockSize += numBytes
+	b.numEntries++
+	b.keyHashes = append(b.keyHashes, bloomHash(key))
+	b.activeBlock.checksum = crc32.Update(b.activeBlock.checksum, crc32.IEEETable, record)
+	b.largest = key
 
-			// Initialize next block (if we aren't at end of skip list)
-			if current != nil {
-				nextBlockOffset := uint32(currentOffset)
-				activeBlock = indexBlock{
-					key:    current.key,
-					offset: int64(nextBlockOffset),
-				}
-			}
+	// Once the size of the block crosses the threshold, append it to the blocks slice
+	if b.blockSize >= indexBlockSizeInBytes {
+		b.finishBlock()
+	}
+	return nil
+}
+
+func (b *tableBuilder) finishBlock() {
+	b.activeBlock.size = int64(b.blockSize)
+	b.blocks = append(b.blocks, b.activeBlock)
+	b.blockSize = 0
+}
 
+// finish : Writes the index, the bloom filter, the range tombstones and the header (and syncs the file)
+// The header ends with a checksum of the rest of the file (i.e. of everything after the header, followed by the rest of
+// the header), which is taken as the table is written, so a table's checksum is known without reading the table (e.g.
+// when it's backed up, see CreateNewBackup)
+// Note: a table may only contain range tombstones, in which case it has no blocks (and an empty filter block)
+func (b *tableBuilder) finish(tombstones []rangeTombstone) (*SSTable, *tableReader, error) {
+	if b.blockSize > 0 {
+		b.finishBlock()
+	}
+
+	index, err := writeIndex(b.file, b.blocks, b.partitioned)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filterOffset, err := b.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var bloomFilter *BloomFilter
+	if len(b.keyHashes) > 0 {
+		bloomFilter = newBloomFilterFromHashes(b.keyHashes, bloomNumHashes)
+		_, err = b.file.Write(bloomFilter.encode())
+		if err != nil {
+			return nil, nil, errors.New("error writing bloom filter to file")
 		}
 	}
 
-	indexOffset := currentOffset
+	rangeDelOffset, err := b.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// Start writing index blocks (immediately after the key-value data on disk)
-	for _, block := range indexBlocks {
+	// The range deletion block is written last (i.e. it runs until the end of the file)
+	for _, tombstone := range tombstones {
 		var toAppend []byte
 
-		toAppend = binary.BigEndian.AppendUint16(toAppend, uint16(len(block.key)))
-		toAppend = append(toAppend, block.key...)
-		toAppend = binary.BigEndian.AppendUint32(toAppend, uint32(block.offset))
-		toAppend = binary.BigEndian.AppendUint32(toAppend, uint32(block.size))
+		toAppend = binary.BigEndian.AppendUint16(toAppend, uint16(len(tombstone.start)))
+		toAppend = append(toAppend, tombstone.start...)
+		toAppend = binary.BigEndian.AppendUint16(toAppend, uint16(len(tombstone.limit)))
+		toAppend = append(toAppend, tombstone.limit...)
 
-		_, err := file.Write(toAppend)
+		_, err := b.file.Write(toAppend)
 		if err != nil {
-			return nil, errors.New("error writing index block to file")
+			return nil, nil, errors.New("error writing range tombstone to file")
 		}
 	}
 
-	// We can store the index offset value in the space we set aside at the beginning of the file
-	var indexOffsetBytes []byte
-	indexOffsetBytes = binary.BigEndian.AppendUint32(indexOffsetBytes, uint32(indexOffset))
-	_, err = file.WriteAt(indexOffsetBytes, 0)
+	size, err := b.file.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// We can store the offsets (and the type of the index) in the space we set aside at the beginning of the file
+	indexType := singleLevelIndex
+	if index.partitioned {
+		indexType = partitionedIndex
+	}
+
+	var header []byte
+	header = binary.BigEndian.AppendUint32(header, uint32(index.offset))
+	header = binary.BigEndian.AppendUint32(header, uint32(filterOffset))
+	header = binary.BigEndian.AppendUint32(header, uint32(rangeDelOffset))
+	header = append(header, indexType)
+	header = binary.BigEndian.AppendUint32(header, uint32(b.journalNum))
+	header = binary.BigEndian.AppendUint32(header, uint32(b.numEntries))
+	checksum := crc32.Update(b.file.checksum, crc32.IEEETable, header)
+	header = binary.BigEndian.AppendUint32(header, checksum)
+	_, err = b.file.WriteAt(header, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = b.file.Sync()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ssTable := &SSTable{
+		fileNum:         parseFileNum(b.file.Name()),
+		path:            b.file.Name(),
+		rangeTombstones: tombstones,
+		bloomFilter:     bloomFilter,
+		largest:         b.largest,
+		refs:            1,
+		size:            size,
+		numEntries:      b.numEntries,
+		checksum:        checksum,
+		journalNum:      b.journalNum,
+	}
+	if len(b.blocks) > 0 {
+		ssTable.smallest = b.blocks[0].key
 	}
 
-	err = file.Sync()
+	reader := &tableReader{
+		fileNum: ssTable.fileNum,
+		file:    b.file.File,
+		index:   index,
+	}
+
+	return ssTable, reader, nil
+}
+
+// tableHeader : The offsets of the index, the filter block and the range deletion block, the type of the index, the
+// table's journal number, its number of records and its checksum
+type tableHeader struct {
+	indexOffset    int64
+	filterOffset   int64
+	rangeDelOffset int64
+	partitioned    bool
+	journalNum     int
+	numEntries     int64
+	checksum       uint32
+}
+
+// readHeader : Reads the header at the beginning of the table's file
+func readHeader(file File) (tableHeader, error) {
+	buf := make([]byte, headerSizeInBytes)
+	_, err := file.ReadAt(buf, 0)
+	if err != nil {
+		return tableHeader{}, err
+	}
+
+	header := tableHeader{
+		indexOffset:    int64(binary.BigEndian.Uint32(buf[:4])),
+		filterOffset:   int64(binary.BigEndian.Uint32(buf[4:8])),
+		rangeDelOffset: int64(binary.BigEndian.Uint32(buf[8:12])),
+		journalNum:     int(binary.BigEndian.Uint32(buf[13:17])),
+		numEntries:     int64(binary.BigEndian.Uint32(buf[17:21])),
+		checksum:       binary.BigEndian.Uint32(buf[21:25]),
+	}
+
+	// A corrupt header could point anywhere, so the offsets are checked before they're used to read the file
+	info, err := file.Stat()
+	if err != nil {
+		return tableHeader{}, err
+	}
+	if header.indexOffset < headerSizeInBytes || header.filterOffset < header.indexOffset ||
+		header.rangeDelOffset < header.filterOffset || header.rangeDelOffset > info.Size() {
+		return tableHeader{}, corruptSSTableErr
+	}
+
+	switch buf[12] {
+	case singleLevelIndex:
+	case partitionedIndex:
+		header.partitioned = true
+	default:
+		return tableHeader{}, corruptSSTableErr
+	}
+
+	return header, nil
+}
+
+// loadIndexFromSSTable : Reads the index into memory (i.e. only the top-level index, if the index is partitioned)
+// The index ends where the filter block begins (a partitioned index's header points to its top-level index, which
+// is written after the partitions)
+func loadIndexFromSSTable(file File, header tableHeader) (*Index, error) {
+	buf := make([]byte, header.filterOffset-header.indexOffset)
+	_, err := file.ReadAt(buf, header.indexOffset)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.clearJournal()
+	blocks, err := decodeIndexBlocks(buf)
 	if err != nil {
 		return nil, err
 	}
 
-	return &SSTable{
-		file:  file,
-		index: &Index{blocks: indexBlocks, offset: indexOffset},
-	}, nil
+	return &Index{blocks: blocks, offset: header.indexOffset, partitioned: header.partitioned}, nil
 }
 
-func loadIndexFromSSTable(file *os.File) (int64, []indexBlock, error) {
-	var indexBlocks []indexBlock
-	keyLengthBytes := make([]byte, 2)
-	offsetBytes := make([]byte, 4)
-	sizeBytes := make([]byte, 4)
+// loadFilterFromSSTable : Reads the bloom filter, which runs until the range deletion block begins (a table without
+// keys has an empty filter block, and no filter)
+func loadFilterFromSSTable(file File, header tableHeader) (*BloomFilter, error) {
+	if header.rangeDelOffset == header.filterOffset {
+		return nil, nil
+	}
 
-	// Read index offset
-	indexOffsetBytes := make([]byte, 4)
-	_, err := file.ReadAt(indexOffsetBytes, 0)
+	buf := make([]byte, header.rangeDelOffset-header.filterOffset)
+	_, err := file.ReadAt(buf, header.filterOffset)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
-	indexOffset := int64(binary.BigEndian.Uint32(indexOffsetBytes))
 
-	// Seek to index offset
-	_, err = file.Seek(indexOffset, io.SeekStart)
+	return decodeBloomFilter(buf)
+}
+
+// loadRangeTombstonesFromSSTable : Reads the range deletion block (which runs until the end of the file)
+func loadRangeTombstonesFromSSTable(file File, rangeDelOffset int64) ([]rangeTombstone, error) {
+	info, err := file.Stat()
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
 
-	// Read index blocks into memory
-	for {
-		_, err := file.Read(keyLengthBytes)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return 0, nil, err
-		}
-
-		keyLength := binary.BigEndian.Uint16(keyLengthBytes)
+	buf := make([]byte, info.Size()-rangeDelOffset)
+	_, err = file.ReadAt(buf, rangeDelOffset)
+	if err != nil {
+		return nil, err
+	}
 
-		key := make([]byte, keyLength)
-		_, err = file.Read(key)
-		if err != nil {
-			return 0, nil, err
+	// readLengthPrefixed : Reads a length-prefixed key from the beginning of buf
+	readLengthPrefixed := func() ([]byte, error) {
+		if len(buf) < 2 {
+			return nil, corruptSSTableErr
 		}
+		length := int(binary.BigEndian.Uint16(buf))
+		if len(buf) < 2+length {
+			return nil, corruptSSTableErr
+		}
+
+		key := buf[2 : 2+length]
+		buf = buf[2+length:]
+		return key, nil
+	}
 
-		_, err = file.Read(offsetBytes)
+	var tombstones []rangeTombstone
+	for len(buf) > 0 {
+		start, err := readLengthPrefixed()
 		if err != nil {
-			return 0, nil, err
+			return nil, err
 		}
-		offset := int64(binary.BigEndian.Uint32(offsetBytes))
 
-		_, err = file.Read(sizeBytes)
+		limit, err := readLengthPrefixed()
 		if err != nil {
-			return 0, nil, err
+			return nil, err
 		}
-		size := int64(binary.BigEndian.Uint32(sizeBytes))
 
-		indexBlocks = append(indexBlocks, indexBlock{key: key, offset: offset, size: size})
+		tombstones = append(tombstones, rangeTombstone{start: start, limit: limit})
 	}
 
-	return indexOffset, indexBlocks, nil
+	return tombstones, nil
 }
 
-func loadSSTable(file *os.File) *SSTable {
-	offset, indexBlocks, err := loadIndexFromSSTable(file)
+// openTableReader : Opens the table's file and loads its index
+func openTableReader(ss *SSTable) (*tableReader, error) {
+	file, err := ss.fs.Open(ss.path)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	index := &Index{blocks: indexBlocks, offset: offset}
+	header, err := readHeader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	index, err := loadIndexFromSSTable(file, header)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
 
-	return &SSTable{file: file, index: index}
+	return &tableReader{fileNum: ss.fileNum, file: file, index: index}, nil
 }
 
-func loadSSTables(path string) []*SSTable {
-	dirEntries, err := os.ReadDir(path)
+// loadSSTable : Reads the table's metadata (i.e. its bloom filter, its range tombstones and its smallest and largest
+// keys)
+// Returns the table along with a reader (for the file, which is still open)
+func loadSSTable(fs FS, path string) (*SSTable, *tableReader, error) {
+	ssTable := &SSTable{fileNum: parseFileNum(path), path: path, refs: 1, fs: fs}
+
+	reader, err := openTableReader(ssTable)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	// Iterates over directories (in reverse order), so that tables slice starts with most recently flushed table
-	var tables []*SSTable
-	for i := len(dirEntries) - 1; i >= 0; i-- {
-		dir := dirEntries[i]
-		file, err := os.Open(ssTablesDir + dir.Name())
-		if err != nil {
-			log.Fatal(err)
+	info, err := reader.file.Stat()
+	if err != nil {
+		_ = reader.file.Close()
+		return nil, nil, err
+	}
+	ssTable.size = info.Size()
+
+	header, err := readHeader(reader.file)
+	if err != nil {
+		_ = reader.file.Close()
+		return nil, nil, err
+	}
+	ssTable.journalNum, ssTable.numEntries, ssTable.checksum = header.journalNum, header.numEntries, header.checksum
+
+	ssTable.bloomFilter, err = loadFilterFromSSTable(reader.file, header)
+	if err != nil {
+		_ = reader.file.Close()
+		return nil, nil, err
+	}
+
+	ssTable.rangeTombstones, err = loadRangeTombstonesFromSSTable(reader.file, header.rangeDelOffset)
+	if err != nil {
+		_ = reader.file.Close()
+		return nil, nil, err
+	}
+
+	// The largest key is the last key of the last block (i.e. of the last partition, if the index is partitioned)
+	// Note: the table isn't in the block cache yet, so the last partition is read directly from the file
+	if len(reader.index.blocks) > 0 {
+		blocks, err := ssTable.partition(reader, reader.index.numPartitions()-1, &ReadOptions{VerifyChecksums: true})
+		if err != nil || len(blocks) == 0 {
+			_ = reader.file.Close()
+			return nil, nil, errors.New("error reading last index partition of SSTable")
 		}
 
-		tables = append(tables, loadSSTable(file))
+		entries, err := reader.readBlock(blocks[len(blocks)-1], true)
+		if err != nil || len(entries) == 0 {
+			_ = reader.file.Close()
+			return nil, nil, errors.New("error reading last block of SSTable")
+		}
+
+		ssTable.smallest = reader.index.blocks[0].key
+		ssTable.largest = entries[len(entries)-1].key
 	}
 
-	return tables
+	return ssTable, reader, nil
 }
 
-// Get : Searches sstable for a given key
-// If key isn't found, error is returned, and the current offset points at the next key greater than that key (for multi-table RangeScan support)
-func (ss *SSTable) Get(searchKey []byte) ([]byte, []byte, int64, error) {
-	file := ss.file
+// ref : Adds a reference to the table, which keeps its file from being removed
+func (ss *SSTable) ref() {
+	atomic.AddInt32(&ss.refs, 1)
+}
 
-	//if !ss.bloomFilter.MaybeContains(searchKey) {
-	//	return searchKey, nil, 0, notFoundInTableErr
-	//}
+// unref : Removes a reference to the table, and once there are no references left, closes its file (and removes
+// the file if the table is obsolete)
+func (ss *SSTable) unref() {
+	if atomic.AddInt32(&ss.refs, -1) > 0 {
+		return
+	}
 
-	// Find the index block which encompasses the range where the key can be found
-	targetBlock := ss.index.search(searchKey)
+	ss.tableCache.evict(ss.fileNum)
+	if ss.obsolete {
+		err := ss.fs.Remove(ss.path)
+		if err != nil {
+			fmt.Printf("error removing obsolete table: %v", err)
+		}
+	}
+}
 
-	// Seek to the selected index block's offset
-	currentOffset, err := file.Seek(targetBlock.offset, io.SeekStart)
-	if err != nil {
-		return nil, nil, 0, err
+// acquire : Returns the table's reader from the table cache (which must be released once it's no longer needed)
+func (ss *SSTable) acquire() (*tableReader, error) {
+	return ss.tableCache.acquire(ss)
+}
+
+// overlaps : Returns true if the table may contain keys within [start, limit) (a nil start or limit is unbounded)
+func (ss *SSTable) overlaps(start, limit []byte) bool {
+	// Tables that only contain range tombstones have no keys
+	if ss.smallest == nil {
+		return false
 	}
+	return (start == nil || ss.cmp.Compare(ss.largest, start) >= 0) && (limit == nil || ss.cmp.Compare(ss.smallest, limit) < 0)
+}
 
-	var key, val []byte
+// parseFileNum : Returns the number of an SSTable file (or 0 if the filename isn't a segment)
+func parseFileNum(filename string) int {
+	fileNum, ok := scanFileNum(filepath.Base(filename), ssTableFilename)
+	if !ok {
+		return 0
+	}
+	return fileNum
+}
 
-	endOfBlockOffset := targetBlock.offset + targetBlock.size
+// scanFileNum : Returns the file number in a filename that matches the pattern (e.g. ssTableFilename) exactly
+// Sscanf ignores anything after the number, so e.g. a backup copy named segment_1.ss.bak isn't mistaken for a table
+func scanFileNum(filename, pattern string) (int, bool) {
+	var fileNum int
+	_, err := fmt.Sscanf(filename, pattern, &fileNum)
+	if err != nil || fmt.Sprintf(pattern, fileNum) != filename {
+		return 0, false
+	}
+	return fileNum, true
+}
 
-	// Sequentially read each key-value pair within the target block
-	for currentOffset < endOfBlockOffset {
-		op := make([]byte, 1)
-		_, err := file.Read(op)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Printf("Error reading op: %v\n", err)
-			return nil, nil, 0, err
+// loadSSTables : Loads the metadata of every table in the directory (each table's file is handed over to the
+// table cache, which closes the least recently used files once there are too many of them)
+// A table that can't be loaded (e.g. because its header is corrupt) is returned as an error, which Repair can fix
+func (db *ClevelDB) loadSSTables(path string) ([]*SSTable, error) {
+	filenames, err := db.fs.List(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []*SSTable
+	for _, filename := range filenames {
+		// A crash in the middle of writing a table (e.g. during a flush) leaves its temporary file behind
+		if isTmpTableFile(filename) {
+			err = db.fs.Remove(filepath.Join(path, filename))
+			if err != nil {
+				return nil, err
+			}
+			continue
 		}
 
-		key, val, currentOffset, err = readKeyVal(file)
-		if err != nil {
-			return nil, nil, 0, err
+		if parseFileNum(filename) == 0 {
+			continue
 		}
 
-		// If we find Insert with a matching key, return the value
-		// If we find Delete with a matching key, return nil
-		if string(searchKey) == string(key) {
-			if op[0] == Insert {
-				return key, val, currentOffset, nil
-			} else if op[0] == Delete {
-				return key, nil, currentOffset, nil
+		table, reader, err := loadSSTable(db.fs, filepath.Join(path, filename))
+		if err != nil {
+			for _, table := range tables {
+				table.unref()
 			}
-		} else if string(key) > string(searchKey) {
-			// Since keys are sorted, reaching here means the searchKey wasn't found in the block
-			// Even though 'key' doesn't match the target key, we return it (because it's the closest key greater
-			// than the target key) in order to support multi-table RangeScan
-			return key, val, currentOffset, notFoundInTableErr
+			return nil, fmt.Errorf("error loading %s: %w", filename, err)
 		}
+
+		db.initTable(table, reader)
+		tables = append(tables, table)
 	}
 
-	return key, val, currentOffset, notFoundInTableErr
+	// Sort by file number (in descending order), so that tables slice starts with most recently flushed table
+	// Note: the directory listing is sorted by name, which would place segment_10 before segment_2
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].fileNum > tables[j].fileNum
+	})
+
+	return tables, nil
 }
 
-func readKeyVal(file *os.File) ([]byte, []byte, int64, error) {
-	keyLen := make([]byte, 2)
-	_, err := file.Read(keyLen)
-	if err != nil {
-		fmt.Printf("Error reading key length: %v\n", err)
-		return nil, nil, 0, err
+// Get : Searches sstable for a given key, and returns the op and value of the key's record
+// For Merge records, the value is the encoded list of merge operands
+func (ss *SSTable) Get(searchKey []byte) (uint8, []byte, error) {
+	// A table that only contains range tombstones has no blocks to search
+	if ss.smallest == nil {
+		return 0, nil, notFoundInTableErr
 	}
 
-	key := make([]byte, binary.BigEndian.Uint16(keyLen))
-	_, err = file.Read(key)
+	// The table's bloom filter rules out most of the keys that aren't in the table, without reading it
+	if ss.bloomFilter != nil && !ss.bloomFilter.MaybeContains(searchKey) {
+		ss.metrics.recordBloomCheck(true)
+		return 0, nil, notFoundInTableErr
+	}
+
+	reader, err := ss.acquire()
 	if err != nil {
-		fmt.Printf("Error reading key: %v\n", err)
-		return nil, nil, 0, err
+		return 0, nil, err
 	}
+	defer reader.release()
 
-	valLen := make([]byte, 2)
-	_, err = file.Read(valLen)
+	// Find the index block which encompasses the range where the key can be found
+	ro := defaultReadOptions()
+	_, blocks, blockIdx, err := ss.findBlock(reader, searchKey, ro)
 	if err != nil {
-		fmt.Printf("Error reading value length: %v\n", err)
-		return nil, nil, 0, err
+		return 0, nil, err
 	}
 
-	val := make([]byte, binary.BigEndian.Uint16(valLen))
-	_, err = file.Read(val)
+	entries, err := ss.getBlock(reader, blocks[blockIdx], ro)
 	if err != nil {
-		fmt.Printf("Error reading value: %v\n", err)
-		return nil, nil, 0, err
+		return 0, nil, err
+	}
+
+	op, val, err := ss.searchBlock(reader, entries, searchKey)
+	if err == notFoundInTableErr && ss.bloomFilter != nil {
+		ss.metrics.recordBloomCheck(false)
+	}
+	return op, val, err
+}
+
+// searchBlock : Returns the op and value of the key's record within the block's (sorted) key-value pairs
+func (ss *SSTable) searchBlock(r *tableReader, entries []blockEntry, searchKey []byte) (uint8, []byte, error) {
+	pos := sort.Search(len(entries), func(i int) bool {
+		return ss.cmp.Compare(entries[i].key, searchKey) >= 0
+	})
+	if pos == len(entries) || ss.cmp.Compare(entries[pos].key, searchKey) != 0 {
+		return 0, nil, notFoundInTableErr
+	}
+
+	// If we find Insert with a matching key, return the value
+	// If we find Delete (or an expired Insert) with a matching key, return nil
+	entry := entries[pos]
+	if isExpired(entry.expiresAt) {
+		return entry.op, nil, nil
+	}
+
+	// A memory-mapped value is copied, since it has to outlive the reader (which is released once the lookup is done)
+	if r.data != nil && entry.val != nil {
+		return entry.op, append([]byte{}, entry.val...), nil
+	}
+	return entry.op, entry.val, nil
+}
+
+// scan : Sequentially reads every record in the table (including tombstones and expired keys)
+// The blocks aren't added to the block cache, since a scan reads each of them once
+func (ss *SSTable) scan(fn func(op uint8, key, val []byte, expiresAt int64)) error {
+	if ss.smallest == nil {
+		return nil
 	}
 
-	// After the reading of a key-value pair, update the current offset
-	currentOffset, err := file.Seek(0, io.SeekCurrent)
+	reader, err := ss.acquire()
 	if err != nil {
-		fmt.Printf("Error retrieving current offset: %v\n", err)
-		return nil, nil, 0, err
+		return err
 	}
-	return key, val, currentOffset, nil
+	defer reader.release()
+
+	ro := &ReadOptions{}
+	for partitionIdx := 0; partitionIdx < reader.index.numPartitions(); partitionIdx++ {
+		blocks, err := ss.partition(reader, partitionIdx, ro)
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			entries, err := ss.getBlock(reader, block, ro)
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+				// Memory-mapped keys and values are copied, since the callback may keep them after the table is
+				// unmapped (e.g. compaction, which removes the table)
+				if reader.data != nil {
+					entry.key = append([]byte(nil), entry.key...)
+					if entry.val != nil {
+						entry.val = append([]byte(nil), entry.val...)
+					}
+				}
+				fn(entry.op, entry.key, entry.val, entry.expiresAt)
+			}
+		}
+	}
+
+	return nil
 }
 
 func (ss *SSTable) Delete(key []byte) error {
@@ -329,98 +795,404 @@ func (ss *SSTable) Put(key []byte) error {
 	panic("read-only")
 }
 
-func (ss *SSTable) RangeScan(start, limit []byte) (Iterator, error) {
-	key, val, currentOffset, err := ss.Get(start)
-	if err != nil && err != notFoundInTableErr {
+// blockEntry : A decoded key-value pair (for Merge records, the value is the encoded list of operands)
+type blockEntry struct {
+	op        uint8
+	key       []byte
+	val       []byte
+	expiresAt int64
+}
+
+// getBlock : Returns the block's decoded key-value pairs from the block cache, or reads them from disk (and adds
+// them to the cache, unless ro.FillCache is disabled)
+func (ss *SSTable) getBlock(reader *tableReader, block indexBlock, ro *ReadOptions) ([]blockEntry, error) {
+	// Memory-mapped blocks are already in memory (i.e. in the page cache), so they're decoded every time instead
+	if ss.blockCache == nil || reader.data != nil {
+		return reader.readBlock(block, ro.VerifyChecksums)
+	}
+
+	key := blockCacheKey{fileNum: ss.fileNum, offset: block.offset}
+	if cached, ok := ss.blockCache.Get(key); ok {
+		return cached.([]blockEntry), nil
+	}
+
+	entries, err := reader.readBlock(block, ro.VerifyChecksums)
+	if err != nil {
 		return nil, err
 	}
 
-	return &SSIterator{
-		file:          ss.file,
-		currentKey:    key,
-		currentVal:    val,
-		nextKeyOffset: currentOffset,
-		limit:         limit,
-	}, nil
+	if ro.FillCache {
+		ss.blockCache.Insert(key, entries, block.size)
+	}
+	return entries, nil
 }
 
-type SSIterator struct {
-	file          *os.File
-	currentKey    []byte
-	currentVal    []byte
-	nextKeyOffset int64
-	limit         []byte
+// readAt : Returns the raw bytes of a block (i.e. either a data block or an index partition)
+// If verifyChecksum is set, checksumMismatchErr is returned when the block doesn't match its checksum
+func (r *tableReader) readAt(block indexBlock, verifyChecksum bool) ([]byte, error) {
+	var buf []byte
+	if r.data != nil {
+		// The block is decoded in place (i.e. its keys and values point into the mapped file)
+		if block.offset+block.size > int64(len(r.data)) {
+			return nil, corruptSSTableErr
+		}
+		buf = r.data[block.offset : block.offset+block.size]
+	} else {
+		// Positional reads don't move the file's cursor, so any number of readers can share the file
+		buf = make([]byte, block.size)
+		_, err := r.file.ReadAt(buf, block.offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if verifyChecksum && crc32.ChecksumIEEE(buf) != block.checksum {
+		return nil, checksumMismatchErr
+	}
+	return buf, nil
 }
 
-func (i *SSIterator) Next() bool {
-	if bytes.Compare(i.currentKey, i.limit) >= 0 {
-		return false
+// readBlock : Reads an entire block from disk, and decodes each of its key-value pairs
+func (r *tableReader) readBlock(block indexBlock, verifyChecksum bool) ([]blockEntry, error) {
+	buf, err := r.readAt(block, verifyChecksum)
+	if err != nil {
+		return nil, err
 	}
 
-	file := i.file
-	for {
-		op := make([]byte, 1)
-		_, err := file.Read(op)
-		if err == io.EOF {
-			return false
-		} else if err != nil {
-			fmt.Printf("Error reading op: %v\n", err)
-			return false
+	var entries []blockEntry
+	for len(buf) > 0 {
+		entry, n, err := decodeRecord(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+		buf = buf[n:]
+	}
+
+	return entries, nil
+}
+
+// decodeRecord : Decodes the record at the beginning of buf (see writeRecordToFile for the encoding)
+// Returns the number of bytes that were decoded
+func decodeRecord(buf []byte) (blockEntry, int, error) {
+	var entry blockEntry
+	corruptErr := errors.New("corrupt record in block")
+
+	if len(buf) < 3 {
+		return entry, 0, corruptErr
+	}
+	entry.op = buf[0]
+	n := 1
+
+	keyLen := int(binary.BigEndian.Uint16(buf[n:]))
+	n += 2
+	if len(buf) < n+keyLen {
+		return entry, 0, corruptErr
+	}
+	entry.key = buf[n : n+keyLen]
+	n += keyLen
+
+	if entry.op != Delete {
+		if len(buf) < n+2 {
+			return entry, 0, corruptErr
+		}
+		valLen := int(binary.BigEndian.Uint16(buf[n:]))
+		n += 2
+		if len(buf) < n+valLen {
+			return entry, 0, corruptErr
+		}
+		entry.val = buf[n : n+valLen]
+		n += valLen
+	}
+
+	if entry.op == InsertWithTTL {
+		if len(buf) < n+8 {
+			return entry, 0, corruptErr
 		}
+		entry.expiresAt = int64(binary.BigEndian.Uint64(buf[n:]))
+		n += 8
+	}
 
-		key, val, currentOffset, err := readKeyVal(file)
+	return entry, n, nil
+}
+
+// RangeScan : Returns an iterator over the keys within [start, limit), positioned at the first key
+func (ss *SSTable) RangeScan(start, limit []byte) (Iterator, error) {
+	iterator := ss.newIterator(&ReadOptions{LowerBound: start, UpperBound: limit, FillCache: true})
+	iterator.First()
+
+	return iterator, iterator.Error()
+}
+
+// newIterator : Returns an (unpositioned) iterator over the keys within [ro.LowerBound, ro.UpperBound)
+// The iterator holds on to the table's reader until it's released
+func (ss *SSTable) newIterator(ro *ReadOptions) *SSIterator {
+	iterator := &SSIterator{
+		table:        ss,
+		partitionIdx: -1,
+		pos:          -1,
+		start:        ro.LowerBound,
+		limit:        ro.UpperBound,
+		opts:         ro,
+	}
+
+	if ss.smallest != nil {
+		iterator.reader, iterator.err = ss.acquire()
+	}
+	return iterator
+}
+
+// SSIterator : Iterates over an SSTable one block at a time (i.e. the key-value pairs of the current block are
+// kept in memory), which allows it to move backward through the block and then on to the previous block
+// If the table's index is partitioned, the data blocks of the current partition are also kept in memory
+type SSIterator struct {
+	table        *SSTable
+	reader       *tableReader // nil if the table has no keys (or if the table couldn't be opened)
+	partitionIdx int          // position of the current partition in the index (-1 if no partition is loaded)
+	partition    []indexBlock // the data blocks of the current partition
+	blockIdx     int          // position of the current block within the partition
+	entries      []blockEntry
+	pos          int // position within the current block's entries (-1 if the iterator isn't positioned at a key)
+	start        []byte
+	limit        []byte
+	opts         *ReadOptions
+	err          error
+}
+
+// loadBlock : Reads the block at the given position within the given partition (a negative blockIdx is the
+// partition's last block). Returns false if there isn't one
+func (i *SSIterator) loadBlock(partitionIdx, blockIdx int) bool {
+	i.entries = nil
+	i.pos = -1
+
+	if i.reader == nil || partitionIdx < 0 || partitionIdx >= i.reader.index.numPartitions() {
+		return false
+	}
+
+	if partitionIdx != i.partitionIdx {
+		partition, err := i.table.partition(i.reader, partitionIdx, i.opts)
 		if err != nil {
+			i.err = err
 			return false
 		}
 
-		i.nextKeyOffset = currentOffset
-		i.currentKey = key
-		i.currentVal = val
-		return true
+		i.partitionIdx = partitionIdx
+		i.partition = partition
+	}
+
+	if blockIdx < 0 {
+		blockIdx = len(i.partition) - 1
+	}
+	if blockIdx < 0 || blockIdx >= len(i.partition) {
+		return false
+	}
+
+	entries, err := i.table.getBlock(i.reader, i.partition[blockIdx], i.opts)
+	if err != nil {
+		i.err = err
+		return false
 	}
+
+	i.blockIdx = blockIdx
+	i.entries = entries
+	return true
 }
 
-func (i *SSIterator) Error() error {
-	return nil
+// loadNextBlock : Reads the block after the current one (which may be the first block of the next partition)
+func (i *SSIterator) loadNextBlock() bool {
+	if i.blockIdx+1 < len(i.partition) {
+		return i.loadBlock(i.partitionIdx, i.blockIdx+1)
+	}
+	return i.loadBlock(i.partitionIdx+1, 0)
 }
 
-func (i *SSIterator) Key() []byte {
-	return i.currentKey
+// loadPrevBlock : Reads the block before the current one (which may be the last block of the previous partition)
+func (i *SSIterator) loadPrevBlock() bool {
+	if i.blockIdx > 0 {
+		return i.loadBlock(i.partitionIdx, i.blockIdx-1)
+	}
+	return i.loadBlock(i.partitionIdx-1, -1)
 }
 
-func (i *SSIterator) Value() []byte {
-	return i.currentVal
+// loadBlockFor : Reads the block whose range matches the key
+func (i *SSIterator) loadBlockFor(key []byte) bool {
+	partitionIdx, partition, blockIdx, err := i.table.findBlock(i.reader, key, i.opts)
+	if err != nil {
+		i.entries = nil
+		i.pos = -1
+		i.err = err
+		return false
+	}
+
+	i.partitionIdx = partitionIdx
+	i.partition = partition
+	return i.loadBlock(partitionIdx, blockIdx)
 }
 
-// Performs a binary search and return the index block whose range matches the key
-func (index *Index) search(key []byte) indexBlock {
-	blocks := index.blocks
+// checkRange : Invalidates the iterator if it has moved outside of [start, limit)
+func (i *SSIterator) checkRange() bool {
+	if i.Valid() && !inRange(i.table.cmp, i.Key(), i.start, i.limit) {
+		i.pos = -1
+	}
+	return i.Valid()
+}
 
-	targetKey := string(key)
+func (i *SSIterator) First() bool {
+	if i.start != nil {
+		return i.Seek(i.start)
+	}
 
-	left := 0
-	right := len(blocks) - 1
-	var mid int
+	if !i.loadBlock(0, 0) {
+		return false
+	}
+	i.pos = 0
+	return i.checkRange()
+}
 
-	for left < right-1 {
-		mid = (right - left) / 2
-		blockKey := string(blocks[mid].key)
+func (i *SSIterator) Last() bool {
+	if i.reader == nil {
+		return false
+	}
 
-		if targetKey == blockKey {
-			return blocks[mid]
+	var loaded bool
+	if i.limit != nil {
+		loaded = i.loadBlockFor(i.limit)
+	} else {
+		loaded = i.loadBlock(i.reader.index.numPartitions()-1, -1)
+	}
+	if !loaded {
+		return false
+	}
+
+	// Find the last key (within the block) that's less than the limit
+	i.pos = len(i.entries) - 1
+	for i.limit != nil && i.pos >= 0 && i.table.cmp.Compare(i.entries[i.pos].key, i.limit) >= 0 {
+		i.pos--
+	}
+
+	// Every key in the block is greater than or equal to the limit, so the last key is at the end of the previous block
+	if i.pos < 0 {
+		if !i.loadPrevBlock() {
+			return false
 		}
+		i.pos = len(i.entries) - 1
+	}
+
+	return i.checkRange()
+}
+
+// Seek : Moves to the first key that is greater than or equal to the given key
+func (i *SSIterator) Seek(key []byte) bool {
+	if i.reader == nil {
+		return false
+	}
 
-		if targetKey < blockKey {
-			right = mid - 1
-		} else {
-			left = mid + 1
+	if i.start != nil && i.table.cmp.Compare(key, i.start) < 0 {
+		key = i.start
+	}
+
+	// Find the index block which encompasses the range where the key can be found
+	if !i.loadBlockFor(key) {
+		return false
+	}
+
+	i.pos = 0
+	for i.pos < len(i.entries) && i.table.cmp.Compare(i.entries[i.pos].key, key) < 0 {
+		i.pos++
+	}
+
+	// Every key in the block is smaller, so the first key of the next block is the closest key
+	if i.pos == len(i.entries) {
+		if !i.loadNextBlock() {
+			return false
 		}
+		i.pos = 0
 	}
 
-	// If an exact match not found, we select the block to the left because it contains the range where the key could be found
-	if targetKey >= string(blocks[right].key) {
-		return blocks[right]
-	} else {
-		return blocks[left]
+	return i.checkRange()
+}
+
+func (i *SSIterator) Next() bool {
+	if !i.Valid() {
+		return false
+	}
+
+	i.pos++
+	if i.pos == len(i.entries) {
+		if !i.loadNextBlock() {
+			return false
+		}
+		i.pos = 0
+	}
+
+	return i.checkRange()
+}
+
+func (i *SSIterator) Prev() bool {
+	if !i.Valid() {
+		return false
+	}
+
+	i.pos--
+	if i.pos < 0 {
+		if !i.loadPrevBlock() {
+			return false
+		}
+		i.pos = len(i.entries) - 1
+	}
+
+	return i.checkRange()
+}
+
+func (i *SSIterator) Valid() bool {
+	return i.pos >= 0 && i.pos < len(i.entries)
+}
+
+func (i *SSIterator) Release() {
+	i.entries = nil
+	i.partition = nil
+	i.pos = -1
+
+	if i.reader != nil {
+		i.reader.release()
+		i.reader = nil
+	}
+}
+
+func (i *SSIterator) Error() error {
+	return i.err
+}
+
+func (i *SSIterator) Key() []byte {
+	if !i.Valid() {
+		return nil
+	}
+	return i.entries[i.pos].key
+}
+
+// Value : Returns nil for tombstones (i.e. deleted and expired keys) and merge operands
+func (i *SSIterator) Value() []byte {
+	if !i.Valid() {
+		return nil
+	}
+
+	entry := i.entries[i.pos]
+	if entry.op == Merge || isExpired(entry.expiresAt) {
+		return nil
+	}
+	return entry.val
+}
+
+func (i *SSIterator) operands() [][]byte {
+	if !i.Valid() || i.entries[i.pos].op != Merge {
+		return nil
+	}
+
+	operands, err := decodeOperands(i.entries[i.pos].val)
+	if err != nil {
+		i.err = err
+		return nil
 	}
+	return operands
 }
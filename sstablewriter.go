@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+var unorderedKeysErr = errors.New("keys are not in increasing order")
+var recordTooLargeErr = errors.New("key or value is too large (the limit is 65,535 bytes)")
+var emptyTableErr = errors.New("cannot write an empty SSTable")
+
+// SSTableWriter : Builds an SSTable file outside of a db (e.g. from the output of an ETL job), which can then be
+// bulk-loaded with IngestExternalFile. Keys must be added in strictly increasing order (according to the comparator
+// of the db that the file will be ingested into)
+type SSTableWriter struct {
+	builder *tableBuilder
+	cmp     Comparator
+	lastKey []byte
+	err     error // set once a write fails (the writer can't be used afterward)
+}
+
+// NewSSTableWriter : Creates (or truncates) the file at the given path
+// Only opts.Comparator, opts.PartitionedIndex and opts.FS are used (a nil *Options uses the defaults)
+func NewSSTableWriter(path string, opts *Options) (*SSTableWriter, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	file, err := opts.fs().Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	builder, err := newTableBuilder(file, opts.comparator(), opts.PartitionedIndex)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &SSTableWriter{builder: builder, cmp: opts.comparator()}, nil
+}
+
+// Put : Adds the key's value to the table
+func (w *SSTableWriter) Put(key, val []byte) error {
+	if val == nil {
+		// A nil value is a tombstone everywhere else, so an empty value is stored instead
+		val = []byte{}
+	}
+	return w.add(key, val)
+}
+
+// Delete : Adds a tombstone for the key, which hides the key's value in older tables once the file is ingested
+func (w *SSTableWriter) Delete(key []byte) error {
+	return w.add(key, nil)
+}
+
+func (w *SSTableWriter) add(key, val []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if len(key) > math.MaxUint16 || len(val) > math.MaxUint16 {
+		return recordTooLargeErr
+	}
+	if w.lastKey != nil && w.cmp.Compare(key, w.lastKey) <= 0 {
+		return unorderedKeysErr
+	}
+
+	// The caller may reuse its buffers, so the key is copied (since the index may refer to it)
+	key = append([]byte{}, key...)
+
+	w.err = w.builder.add(key, encodeRecord(keyValOp(val, 0), key, val, 0))
+	w.lastKey = key
+	return w.err
+}
+
+// Finish : Writes the table's index and header, and closes the file
+func (w *SSTableWriter) Finish() error {
+	if w.err != nil {
+		_ = w.builder.file.Close()
+		return w.err
+	}
+
+	if w.lastKey == nil {
+		_ = w.builder.file.Close()
+		return emptyTableErr
+	}
+
+	_, _, err := w.builder.finish(nil)
+	closeErr := w.builder.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// SSTableReader : Reads an SSTable file outside of a db (e.g. to inspect a file before ingesting it)
+type SSTableReader struct {
+	table *SSTable
+}
+
+// OpenSSTableReader : Opens the file at the given path, whose keys are ordered by opts.Comparator
+// (a nil *Options uses the defaults)
+func OpenSSTableReader(path string, opts *Options) (*SSTableReader, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	table, reader, err := loadSSTable(opts.fs(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reader gets a table cache of its own (so that its file stays open until the reader is closed)
+	table.cmp = opts.comparator()
+	table.tableCache = newTableCache(nil, &Options{MaxOpenFiles: numNonTableFiles + 1, UseMmapReads: opts.UseMmapReads})
+	table.tableCache.insert(reader).release()
+
+	return &SSTableReader{table: table}, nil
+}
+
+// Get : Returns the key's value (returns notFoundInTableErr if the table doesn't have the key, and deletedErr if
+// the table has a tombstone for it)
+func (r *SSTableReader) Get(key []byte) ([]byte, error) {
+	_, val, err := r.table.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted keys (and expired keys) don't have a value
+	if val == nil {
+		return nil, deletedErr
+	}
+	return val, nil
+}
+
+// NewIterator : Returns an iterator over the table's keys within [ro.LowerBound, ro.UpperBound), positioned at
+// the first key. Tombstones are included (their value is nil)
+func (r *SSTableReader) NewIterator(ro *ReadOptions) Iterator {
+	if ro == nil {
+		ro = defaultReadOptions()
+	}
+
+	iterator := r.table.newIterator(ro)
+	iterator.First()
+	return iterator
+}
+
+// Smallest : Returns the table's smallest key (nil if the table has no keys)
+func (r *SSTableReader) Smallest() []byte {
+	return r.table.smallest
+}
+
+// Largest : Returns the table's largest key (nil if the table has no keys)
+func (r *SSTableReader) Largest() []byte {
+	return r.table.largest
+}
+
+// Close : Closes the table's file (once every iterator has been released)
+func (r *SSTableReader) Close() {
+	r.table.unref()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultMaxOpenFiles = 1000
+
+var mmapUnsupportedErr = errors.New("file can't be memory-mapped")
+
+// Some file descriptors are reserved for the journal (and any other files that aren't SSTables)
+const numNonTableFiles = 10
+
+// tableReader : An open SSTable file along with its index
+type tableReader struct {
+	fileNum  int
+	file     File
+	data     []byte // the memory-mapped file (nil unless Options.UseMmapReads is set)
+	index    *Index
+	refs     int32           // the table cache holds a reference while the reader is cached (as does each user of the reader)
+	pinnedIn *BlockCache     // the block cache that the index is pinned in (nil if it isn't pinned)
+	pinned   []blockCacheKey // the index (and its partitions) in pinnedIn, which are erased once the reader is closed
+}
+
+// release : Removes a reference to the reader, and closes its file once there are no references left
+func (r *tableReader) release() {
+	if atomic.AddInt32(&r.refs, -1) > 0 {
+		return
+	}
+
+	_ = r.file.Close()
+	if r.data != nil {
+		_ = munmapFile(r.data)
+	}
+	for _, key := range r.pinned {
+		r.pinnedIn.Erase(key)
+	}
+}
+
+// TableCache : An LRU cache of open SSTable readers (keyed by file number), which bounds the number of open files
+// Evicting a reader only closes its file once every iterator that's using it has released it, so the number of
+// open files can temporarily exceed the capacity
+type TableCache struct {
+	mu             sync.Mutex
+	capacity       int
+	lru            *list.List // readers, from most to least recently used
+	readers        map[int]*list.Element
+	blockCache     *BlockCache
+	pinIndexBlocks bool
+	useMmap        bool
+}
+
+func newTableCache(blockCache *BlockCache, opts *Options) *TableCache {
+	capacity := opts.maxOpenFiles() - numNonTableFiles
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &TableCache{
+		capacity:       capacity,
+		lru:            list.New(),
+		readers:        make(map[int]*list.Element),
+		blockCache:     blockCache,
+		pinIndexBlocks: opts.PinIndexBlocks,
+		useMmap:        opts.UseMmapReads,
+	}
+}
+
+// acquire : Returns the table's reader (opening the table's file and loading its index if it isn't cached)
+// The reader must be released once it's no longer needed
+func (c *TableCache) acquire(ss *SSTable) (*tableReader, error) {
+	c.mu.Lock()
+	if element, ok := c.readers[ss.fileNum]; ok {
+		c.lru.MoveToFront(element)
+		reader := element.Value.(*tableReader)
+		atomic.AddInt32(&reader.refs, 1)
+		c.mu.Unlock()
+		return reader, nil
+	}
+	c.mu.Unlock()
+
+	// The file is opened without holding the lock (so that cached readers can still be acquired in the meantime)
+	reader, err := openTableReader(ss)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.insert(reader), nil
+}
+
+// insert : Caches a newly opened reader, and returns it (with a reference for the caller)
+// If another reader for the same table was cached in the meantime, that one is returned instead
+func (c *TableCache) insert(reader *tableReader) *tableReader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.readers[reader.fileNum]; ok {
+		_ = reader.file.Close()
+		cached := element.Value.(*tableReader)
+		atomic.AddInt32(&cached.refs, 1)
+		return cached
+	}
+
+	// If the file can't be mapped, the reader falls back to positional reads
+	if c.useMmap && reader.data == nil {
+		reader.data, _ = mmapFile(reader.file)
+	}
+
+	// One reference for the cache, and one for the caller
+	atomic.AddInt32(&reader.refs, 2)
+	if c.blockCache != nil && c.pinIndexBlocks && len(reader.index.blocks) > 0 {
+		reader.pinIndex(c.blockCache)
+	}
+
+	c.readers[reader.fileNum] = c.lru.PushFront(reader)
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+
+	return reader
+}
+
+// evict : Removes the table's reader from the cache (e.g. once the table has been deleted)
+func (c *TableCache) evict(fileNum int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.readers[fileNum]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *TableCache) remove(element *list.Element) {
+	reader := element.Value.(*tableReader)
+	c.lru.Remove(element)
+	delete(c.readers, reader.fileNum)
+	reader.release()
+}
+
+// numOpen : Returns the number of cached readers
+func (c *TableCache) numOpen() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func Test_TableCacheBoundsOpenFiles(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.tableCache = newTableCache(nil, &Options{MaxOpenFiles: numNonTableFiles + 2})
+
+	for i := 0; i < 50; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(i)))
+		if i%10 == 9 {
+			flushTestMemtable(t, db)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if val, err := db.Get(key); string(val) != fmt.Sprint(i) || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value: "%s" (%v)`, key, val, err)
+		}
+
+		if numOpen := db.tableCache.numOpen(); numOpen > 2 {
+			t.Errorf("table cache has unexpected number of open tables: %v", numOpen)
+		}
+	}
+
+	// The iterator keeps every table's reader until it's released (even after they're evicted from the cache)
+	iter := db.NewIterator(nil)
+	if keys := collectKeys(iter, iter.Next); len(keys) != 50 {
+		t.Errorf("NewIterator returns unexpected number of keys: %v", len(keys))
+	}
+	iter.Release()
+}
+
+func Test_TableCacheKeepsCompactedTablesUntilReleased(t *testing.T) {
+	db := newTestClevelDB(t)
+	db.tableCache = newTableCache(nil, &Options{MaxOpenFiles: numNonTableFiles + 1})
+
+	_ = db.Put([]byte("a"), []byte("cassie"))
+	flushTestMemtable(t, db)
+	_ = db.Put([]byte("b"), []byte("nitin"))
+	flushTestMemtable(t, db)
+
+	oldTables := db.tables
+	iter := db.NewIterator(nil)
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	// The old tables' files can't be removed while the iterator is still using them
+	for _, table := range oldTables {
+		if _, err := db.fs.Stat(table.path); err != nil {
+			t.Errorf("compaction removes a table that's still in use: %v", err)
+		}
+	}
+
+	if keys := collectKeys(iter, iter.Next); len(keys) != 2 {
+		t.Errorf("NewIterator returns unexpected keys after compaction: %v", keys)
+	}
+	iter.Release()
+
+	for _, table := range oldTables {
+		if _, err := db.fs.Stat(table.path); !os.IsNotExist(err) {
+			t.Errorf("compaction doesn't remove table once it's released: %v", err)
+		}
+	}
+}
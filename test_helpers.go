@@ -68,7 +68,7 @@ func testRangeScanAndNextReturnCorrectOrderedValues(t *testing.T, db DB) {
 	_ = db.Put(keys[3], vals[3])
 	_ = db.Put(keys[4], vals[4])
 
-	iter, _ := db.RangeScan([]byte("b"), []byte("d"))
+	iter, _ := db.RangeScan([]byte("b"), []byte("f"))
 
 	expectedKeys := [][]byte{[]byte("b"), []byte("c"), []byte("d")}
 	expectedVals := [][]byte{[]byte("nitin"), []byte("neha"), []byte("david")}
@@ -93,6 +93,51 @@ func testRangeScanAndNextReturnCorrectOrderedValues(t *testing.T, db DB) {
 	}
 }
 
+func testRangeScanAndPrevReturnCorrectReverseOrderedValues(t *testing.T, db DB) {
+	keys := [][]byte{[]byte("b"), []byte("c"), []byte("a"), []byte("f"), []byte("d")}
+	vals := [][]byte{[]byte("nitin"), []byte("neha"), []byte("cassie"), []byte("karli"), []byte("david")}
+
+	for i := range keys {
+		_ = db.Put(keys[i], vals[i])
+	}
+
+	iter, _ := db.RangeScan([]byte("b"), []byte("f"))
+
+	if !iter.Last() {
+		t.Fatalf(`storage.RangeScan returns an iterator without a last key`)
+	}
+
+	expectedKeys := [][]byte{[]byte("d"), []byte("c"), []byte("b")}
+	expectedVals := [][]byte{[]byte("david"), []byte("neha"), []byte("nitin")}
+	expectedPrevs := []bool{true, true, false}
+
+	for i := 0; i < len(expectedKeys); i++ {
+		expectedKey := string(expectedKeys[i])
+		expectedVal := string(expectedVals[i])
+		expectedPrev := expectedPrevs[i]
+
+		actualKey := string(iter.Key())
+		actualVal := string(iter.Value())
+		actualPrev := iter.Prev()
+
+		if expectedKey != actualKey || expectedVal != actualVal {
+			t.Errorf(`storage.RangeScan returns unexpected key/value: "%s: %s" at index: %v`, actualKey, actualVal, i)
+		}
+
+		if expectedPrev != actualPrev {
+			t.Errorf(`storage.RangeScan returns unexpected prev value-- Expected: "%v, Actual: %v" at index: %v`, expectedPrev, actualPrev, i)
+		}
+	}
+
+	if !iter.Seek([]byte("bb")) || string(iter.Key()) != "c" {
+		t.Errorf(`iterator.Seek("bb") returns unexpected key: "%s"`, iter.Key())
+	}
+
+	if !iter.First() || string(iter.Key()) != "b" {
+		t.Errorf(`iterator.First() returns unexpected key: "%s"`, iter.Key())
+	}
+}
+
 func benchmarkFillSeq(b *testing.B, db DB) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	defaultL0SlowdownWritesTrigger = 8
+	defaultL0StopWritesTrigger     = 12
+	defaultMaxImmutableMemtables   = 1
+	writeSlowdownDelay             = time.Millisecond
+)
+
+// WriteStallStats : How often (and for how long) writes were slowed down or stopped because background flushes or
+// compactions fell behind
+type WriteStallStats struct {
+	Slowdowns            int64         // writes that were delayed because there were L0SlowdownWritesTrigger tables
+	SlowdownDuration     time.Duration // total time that writes were delayed for
+	L0Stops              int64         // writes that blocked because there were L0StopWritesTrigger tables
+	L0StopDuration       time.Duration
+	MemtableStops        int64 // writes that blocked because MaxImmutableMemtables memtables were waiting to be flushed
+	MemtableStopDuration time.Duration
+}
+
+// WriteStallStats : Returns the db's write stall statistics
+func (db *ClevelDB) WriteStallStats() WriteStallStats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.stallStats
+}
+
+// makeRoomForWrite : Makes sure that the memtable has room for another write, by handing a full memtable over to
+// a background flush. Writes are delayed (or blocked) while the background work is falling behind:
+// - Once there are L0SlowdownWritesTrigger tables, each write is delayed once, which spreads the delay over many
+// writes (rather than blocking a single write for as long as the compaction takes)
+// - Once MaxImmutableMemtables memtables are waiting to be flushed, or there are L0StopWritesTrigger tables, a write
+// that fills the memtable blocks until the background work has caught up
+// Returns the error from the background work if it failed (after which the db can't be written to)
+func (db *ClevelDB) makeRoomForWrite() error {
+	// The journal is replayed before the db is fully loaded, so the recovered memtable is only flushed afterwards
+	if db.recovering {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	allowDelay := true
+	stopped := false
+	for {
+		if db.bgErr != nil {
+			return db.bgErr
+		}
+
+		numTables := len(db.tables)
+		if allowDelay && db.l0SlowdownTrigger > 0 && numTables >= db.l0SlowdownTrigger {
+			db.maybeScheduleCompaction()
+
+			start := time.Now()
+			db.mu.Unlock()
+			time.Sleep(writeSlowdownDelay)
+			db.mu.Lock()
+
+			db.stallStats.Slowdowns++
+			db.stallStats.SlowdownDuration += time.Since(start)
+			allowDelay = false
+		} else if db.memtable.size <= maxMemtableSizeInBytes {
+			return nil
+		} else if len(db.immutables) >= db.maxImmutables() {
+			start := time.Now()
+			db.bgCond.Wait()
+
+			if !stopped {
+				db.stallStats.MemtableStops++
+				stopped = true
+			}
+			db.stallStats.MemtableStopDuration += time.Since(start)
+		} else if db.l0StopTrigger > 0 && numTables >= db.l0StopTrigger {
+			db.maybeScheduleCompaction()
+
+			start := time.Now()
+			db.bgCond.Wait()
+
+			if !stopped {
+				db.stallStats.L0Stops++
+				stopped = true
+			}
+			db.stallStats.L0StopDuration += time.Since(start)
+		} else {
+			// The full memtable keeps its journal until it's flushed, while new writes go to the new memtable's journal
+			full, err := db.switchMemtable()
+			if err != nil {
+				return err
+			}
+
+			db.immutables = append(db.immutables, full)
+			db.scheduleBackgroundWork()
+			return nil
+		}
+	}
+}
+
+// maxImmutables : Returns the maximum number of memtables that can wait to be flushed (at least 1)
+func (db *ClevelDB) maxImmutables() int {
+	if db.maxImmutableMemtables <= 0 {
+		return defaultMaxImmutableMemtables
+	}
+	return db.maxImmutableMemtables
+}
+
+// maybeScheduleCompaction : Schedules a background compaction once there are enough tables to slow down (or stop)
+// writes (compacting a single table wouldn't reduce the number of tables). Must be called with db.mu held
+func (db *ClevelDB) maybeScheduleCompaction() {
+	trigger := db.l0SlowdownTrigger
+	if trigger <= 0 || (db.l0StopTrigger > 0 && db.l0StopTrigger < trigger) {
+		trigger = db.l0StopTrigger
+	}
+
+	if trigger > 0 && len(db.tables) >= trigger && len(db.tables) > 1 {
+		db.compactionPending = true
+		db.scheduleBackgroundWork()
+	}
+}
+
+// scheduleBackgroundWork : Starts the background goroutine if there's work for it and it isn't already running
+// Only one flush or compaction runs at a time, so that tables are numbered in the order that their keys were written
+// (a table's file number is only assigned once its flush starts). Must be called with db.mu held
+func (db *ClevelDB) scheduleBackgroundWork() {
+	if db.bgRunning || db.bgErr != nil || (len(db.immutables) == 0 && !db.compactionPending) {
+		return
+	}
+
+	db.bgRunning = true
+	go db.backgroundWork()
+}
+
+// backgroundWork : Flushes the full memtables (oldest first), and then runs the pending compaction
+func (db *ClevelDB) backgroundWork() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for db.bgErr == nil {
+		if len(db.immutables) > 0 {
+			db.bgErr = db.flushImmutable()
+			db.maybeScheduleCompaction()
+		} else if db.compactionPending {
+			db.compactionPending = false
+
+			db.mu.Unlock()
+			err := db.compact()
+			db.mu.Lock()
+
+			db.bgErr = err
+		} else {
+			break
+		}
+
+		// Writes that are waiting for the background work are woken up after each flush or compaction
+		db.bgCond.Broadcast()
+	}
+
+	db.bgRunning = false
+	db.bgCond.Broadcast()
+}
+
+// flushImmutable : Flushes the oldest full memtable. Must be called with db.mu held (which is released while the
+// table is written)
+func (db *ClevelDB) flushImmutable() error {
+	file, err := db.newTableFile()
+	if err != nil {
+		return err
+	}
+
+	mem := db.immutables[0]
+	db.mu.Unlock()
+	ssTable, err := db.writeMemtable(file, mem)
+	db.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	// Prepends new table to slice, so tables are in descending order
+	db.tables = append([]*SSTable{ssTable}, db.tables...)
+	db.immutables = db.immutables[1:]
+	return nil
+}
+
+// waitForBackgroundWork : Waits until every full memtable has been flushed and the background work has stopped
+// (or failed)
+func (db *ClevelDB) waitForBackgroundWork() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.waitUntilIdle()
+}
+
+// waitUntilIdle : Same as waitForBackgroundWork, except db.mu must already be held
+func (db *ClevelDB) waitUntilIdle() {
+	for db.bgRunning || (len(db.immutables) > 0 && db.bgErr == nil) {
+		db.bgCond.Wait()
+	}
+}
+
+// pauseBackgroundWork : Waits for the background work to stop, and keeps it from starting again until
+// resumeBackgroundWork is called (e.g. so that Compact doesn't run at the same time as a background compaction)
+func (db *ClevelDB) pauseBackgroundWork() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.waitUntilIdle()
+	db.bgRunning = true
+}
+
+// resumeBackgroundWork : Lets the background work start again (see pauseBackgroundWork)
+func (db *ClevelDB) resumeBackgroundWork() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.bgRunning = false
+	db.scheduleBackgroundWork()
+	db.bgCond.Broadcast()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingFS : Blocks every table's Sync until the release channel is closed (i.e. flushes and compactions hang)
+type blockingFS struct {
+	FS
+	release chan struct{}
+}
+
+type blockingFile struct {
+	File
+	release chan struct{}
+}
+
+func (fs blockingFS) Create(name string) (File, error) {
+	file, err := fs.FS.Create(name)
+	if _, ok := scanFileNum(filepath.Base(name), tableTmpFilename); err != nil || !ok {
+		return file, err
+	}
+	return blockingFile{File: file, release: fs.release}, nil
+}
+
+func (f blockingFile) Sync() error {
+	<-f.release
+	return f.File.Sync()
+}
+
+// putTestKeys : Writes keys [start, end) to the db
+func putTestKeys(t *testing.T, db *ClevelDB, start, end int) {
+	for i := start; i < end; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key%05d", i)), []byte("value")); err != nil {
+			t.Errorf("storage.Put returns unexpected error: %v", err)
+		}
+	}
+}
+
+func Test_ClevelDBStopsWritesWhileImmutableMemtablesAreFlushed(t *testing.T) {
+	fs := blockingFS{FS: NewMemFS(), release: make(chan struct{})}
+	db := loadTestDBFromDir(t, fs, "db", &Options{MaxImmutableMemtables: 1})
+
+	// The first memtable is handed over to a flush (which hangs), and the second one fills up behind it
+	done := make(chan struct{})
+	go func() {
+		putTestKeys(t, db, 0, 1000)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("storage.Put doesn't block while an immutable memtable is waiting to be flushed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(fs.release)
+	<-done
+	db.waitForBackgroundWork()
+
+	stats := db.WriteStallStats()
+	if stats.MemtableStops == 0 || stats.MemtableStopDuration < 25*time.Millisecond {
+		t.Errorf("WriteStallStats returns unexpected memtable stops: %+v", stats)
+	}
+	for _, key := range []string{"key00000", "key00999"} {
+		if val, err := db.Get([]byte(key)); string(val) != "value" || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value after stall: "%s" (%v)`, key, val, err)
+		}
+	}
+}
+
+func Test_ClevelDBReadsMemtablesWaitingToBeFlushed(t *testing.T) {
+	fs := blockingFS{FS: NewMemFS(), release: make(chan struct{})}
+	defer close(fs.release)
+	db := loadTestDBFromDir(t, fs, "db", &Options{MaxImmutableMemtables: 2, MergeOperator: UInt64AddOperator{}})
+
+	// The first memtable is handed over to a flush (which hangs), so its keys are only in memory
+	_ = db.Merge([]byte("counter"), uint64Bytes(1))
+	putTestKeys(t, db, 0, 400)
+	_ = db.Merge([]byte("counter"), uint64Bytes(2))
+	_ = db.Delete([]byte("key00001"))
+	_ = db.DeleteRange([]byte("key00010"), []byte("key00020"))
+
+	db.mu.Lock()
+	numImmutables := len(db.immutables)
+	db.mu.Unlock()
+	if numImmutables != 1 {
+		t.Fatalf("db has unexpected number of memtables waiting to be flushed: %d", numImmutables)
+	}
+
+	tests := []struct {
+		key, val string
+		err      error
+	}{
+		{"key00000", "value", nil},
+		{"key00001", "", notFoundInDBErr},
+		{"key00015", "", notFoundInDBErr},
+		{"key00399", "value", nil},
+	}
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+
+	keys := make([][]byte, len(tests))
+	for i, test := range tests {
+		keys[i] = []byte(test.key)
+		if val, err := db.Get(keys[i]); string(val) != test.val || err != test.err {
+			t.Errorf(`storage.Get("%s") returns unexpected value: "%s" (%v)`, test.key, val, err)
+		}
+		if val, err := snapshot.Get(keys[i]); string(val) != test.val || err != test.err {
+			t.Errorf(`snapshot.Get("%s") returns unexpected value: "%s" (%v)`, test.key, val, err)
+		}
+	}
+	vals, errs := db.MultiGet(keys)
+	for i, test := range tests {
+		if string(vals[i]) != test.val || errs[i] != test.err {
+			t.Errorf(`storage.MultiGet returns unexpected value for "%s": "%s" (%v)`, test.key, vals[i], errs[i])
+		}
+	}
+
+	// The operands from both memtables are folded together (oldest first)
+	if val, err := db.Get([]byte("counter")); err != nil || binary.BigEndian.Uint64(val) != 3 {
+		t.Errorf(`storage.Get("counter") returns unexpected value: %v (%v)`, val, err)
+	}
+
+	iter := db.NewIterator(nil)
+	defer iter.Release()
+	if keys := collectKeys(iter, iter.Next); len(keys) != 1+400-11 || keys[1] != "key00000=value" || keys[2] != "key00002=value" {
+		t.Errorf("NewIterator returns unexpected number of keys: %d", len(keys))
+	}
+}
+
+func Test_ClevelDBSlowsDownAndStopsWritesWhenTablesPileUp(t *testing.T) {
+	db := loadTestDBFromDir(t, NewMemFS(), "db", &Options{L0SlowdownWritesTrigger: -1, L0StopWritesTrigger: 3})
+	for i := 0; i < 3; i++ {
+		putTestKeys(t, db, i*10, i*10+10)
+		flushTestMemtable(t, db)
+	}
+
+	// Writes only stop once the memtable is full, at which point they wait for a background compaction
+	putTestKeys(t, db, 100, 1000)
+	db.waitForBackgroundWork()
+
+	stats := db.WriteStallStats()
+	if stats.L0Stops != 1 || stats.L0StopDuration == 0 || stats.Slowdowns != 0 {
+		t.Errorf("WriteStallStats returns unexpected stops: %+v", stats)
+	}
+	_, tables := db.current()
+	unrefTables(tables)
+	if len(tables) > 2 {
+		t.Errorf("background compaction leaves unexpected tables: %d", len(tables))
+	}
+
+	// Every write is delayed once there are enough tables, until the background compaction catches up
+	db.l0SlowdownTrigger = 2
+	flushTestMemtable(t, db)
+	putTestKeys(t, db, 2000, 2010)
+	db.waitForBackgroundWork()
+
+	stats = db.WriteStallStats()
+	if stats.Slowdowns == 0 || stats.SlowdownDuration < time.Duration(stats.Slowdowns)*writeSlowdownDelay {
+		t.Errorf("WriteStallStats returns unexpected slowdowns: %+v", stats)
+	}
+	_, tables = db.current()
+	unrefTables(tables)
+	if len(tables) != 1 {
+		t.Errorf("background compaction leaves unexpected tables: %d", len(tables))
+	}
+	for _, key := range []string{"key00000", "key00029", "key00999", "key02009"} {
+		if val, err := db.Get([]byte(key)); string(val) != "value" || err != nil {
+			t.Errorf(`storage.Get("%s") returns unexpected value after compaction: "%s" (%v)`, key, val, err)
+		}
+	}
+}